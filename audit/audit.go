@@ -0,0 +1,109 @@
+// Package audit records security relevant events of user accounts, such as
+// logins and password changes, so users and support can tell what happened
+// to an account and from where.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"reflect"
+	"time"
+)
+
+// Event types, stored as audit_events.event_type.
+const (
+	EventRegister        = "register"
+	EventLoginSucceeded  = "login_succeeded"
+	EventLoginFailed     = "login_failed"
+	EventProfileUpdated  = "profile_updated"
+	EventPhoneChanged    = "phone_changed"
+	EventPasswordChanged = "password_changed"
+	EventTokenRevoked    = "token_revoked"
+	EventAccountDeleted  = "account_deleted"
+	EventAccountDisabled = "account_disabled"
+	EventAccountEnabled  = "account_enabled"
+	EventAccountUnlocked = "account_unlocked"
+	EventRoleChanged     = "role_changed"
+)
+
+// Event is something that happened to the account of UserID. ActorID is who
+// made it happen, empty when the request was not authenticated, e.g. a login.
+// Reason tells events of the same type apart, e.g. why a login failed.
+type Event struct {
+	Type      string
+	UserID    string
+	ActorID   string
+	Reason    string
+	IPAddress string
+	UserAgent string
+	Changes   map[string]Change
+	CreatedAt time.Time
+}
+
+// Change is the value of one field before and after the event. Secrets such
+// as passwords are never recorded, only that they changed.
+type Change struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// Diff returns a Change for every field whose value differs between before
+// and after. A field missing on one side counts as nil.
+func Diff(before, after map[string]interface{}) map[string]Change {
+	changes := map[string]Change{}
+	for field, from := range before {
+		if to := after[field]; !reflect.DeepEqual(from, to) {
+			changes[field] = Change{From: from, To: to}
+		}
+	}
+	for field, to := range after {
+		if _, ok := before[field]; !ok && to != nil {
+			changes[field] = Change{From: nil, To: to}
+		}
+	}
+	return changes
+}
+
+// AuditLogger records events. Events are never changed or removed once
+// logged.
+type AuditLogger interface {
+	Log(ctx context.Context, event Event) error
+}
+
+// EventStore is the part of the repository that stores audit events.
+type EventStore interface {
+	CreateAuditEvent(context.Context, repository.AuditEvent) error
+}
+
+// DatabaseLogger writes events to the audit_events table, with the changes as
+// a JSON object.
+type DatabaseLogger struct {
+	store EventStore
+}
+
+func NewDatabaseLogger(store EventStore) *DatabaseLogger {
+	return &DatabaseLogger{store: store}
+}
+
+func (l *DatabaseLogger) Log(ctx context.Context, event Event) error {
+	row := repository.AuditEvent{
+		UserID:    event.UserID,
+		EventType: event.Type,
+		Reason:    event.Reason,
+		IPAddress: event.IPAddress,
+		UserAgent: event.UserAgent,
+		CreatedAt: event.CreatedAt,
+	}
+	if event.ActorID != "" {
+		row.ActorID = &event.ActorID
+	}
+	if len(event.Changes) > 0 {
+		changes, err := json.Marshal(event.Changes)
+		if err != nil {
+			return err
+		}
+		row.Changes = changes
+	}
+	return l.store.CreateAuditEvent(ctx, row)
+}
@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"context"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   map[string]interface{}
+		after    map[string]interface{}
+		expected map[string]Change
+	}{
+		{
+			name:     "Nothing Changed",
+			before:   map[string]interface{}{"full_name": "John Doe", "pending_phone_number": nil},
+			after:    map[string]interface{}{"full_name": "John Doe", "pending_phone_number": nil},
+			expected: map[string]Change{},
+		},
+		{
+			name:   "Changed Field",
+			before: map[string]interface{}{"full_name": "Old Name", "pending_phone_number": nil},
+			after:  map[string]interface{}{"full_name": "John Doe", "pending_phone_number": nil},
+			expected: map[string]Change{
+				"full_name": {From: "Old Name", To: "John Doe"},
+			},
+		},
+		{
+			name:   "Added And Removed Fields",
+			before: map[string]interface{}{"pending_phone_number": "+62234567891"},
+			after:  map[string]interface{}{"full_name": "John Doe"},
+			expected: map[string]Change{
+				"full_name":            {From: nil, To: "John Doe"},
+				"pending_phone_number": {From: "+62234567891", To: nil},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Diff(tc.before, tc.after))
+		})
+	}
+}
+
+type memoryEventStore struct {
+	events []repository.AuditEvent
+}
+
+func (m *memoryEventStore) CreateAuditEvent(_ context.Context, event repository.AuditEvent) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+func TestDatabaseLogger(t *testing.T) {
+	store := &memoryEventStore{}
+	logger := NewDatabaseLogger(store)
+	now := time.Now()
+
+	err := logger.Log(context.Background(), Event{
+		Type:      EventLoginFailed,
+		UserID:    "mockUserID",
+		Reason:    "invalid_password",
+		IPAddress: "203.0.113.7",
+		CreatedAt: now,
+	})
+	assert.NoError(t, err)
+
+	err = logger.Log(context.Background(), Event{
+		Type:    EventPhoneChanged,
+		UserID:  "mockUserID",
+		ActorID: "mockUserID",
+		Changes: map[string]Change{
+			"phone_number": {From: "+62234567890", To: "+62234567891"},
+		},
+		CreatedAt: now,
+	})
+	assert.NoError(t, err)
+
+	assert.Len(t, store.events, 2)
+	assert.Equal(t, EventLoginFailed, store.events[0].EventType)
+	assert.Equal(t, "invalid_password", store.events[0].Reason)
+	assert.Nil(t, store.events[0].ActorID)
+	assert.Nil(t, store.events[0].Changes)
+
+	assert.Equal(t, "mockUserID", *store.events[1].ActorID)
+	assert.JSONEq(t, `{"phone_number": {"from": "+62234567890", "to": "+62234567891"}}`, string(store.events[1].Changes))
+}
@@ -1,15 +1,29 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/labstack/echo/v4"
 	echoSwagger "github.com/swaggo/echo-swagger"
+	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/SawitProRecruitment/UserService/audit"
 	_ "github.com/SawitProRecruitment/UserService/docs"
 	"github.com/SawitProRecruitment/UserService/generated"
 	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/SawitProRecruitment/UserService/middleware"
+	"github.com/SawitProRecruitment/UserService/migrations"
+	"github.com/SawitProRecruitment/UserService/notifier"
+	"github.com/SawitProRecruitment/UserService/purge"
 	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/SawitProRecruitment/UserService/utils"
 	_ "github.com/joho/godotenv/autoload"
 )
 
@@ -23,25 +37,347 @@ import (
 // @name						Authorization
 // @host localhost:1323
 func main() {
+	// SIGTERM, e.g. from docker stop, starts a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	e := echo.New()
 
-	var server generated.ServerInterface = newServer()
+	swagger, err := generated.GetSwagger()
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+
+	keyRing, err := utils.LoadKeyRing(os.Getenv("JWT_KEYS_DIR"), os.Getenv("JWT_ACTIVE_KEY_ID"))
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+
+	// Without a trusted proxy in front, X-Forwarded-For could be used to dodge
+	// the per-IP rate limit, so the client IP is taken from the connection.
+	e.IPExtractor = echo.ExtractIPDirect()
+	e.Use(middleware.Timeout(timeout("REQUEST_TIMEOUT", 10*time.Second)))
+	e.Use(middleware.RateLimiter(middleware.RateLimiterConfig{
+		Skipper: middleware.SkipUnlessRoute("POST /login", "POST /login/mfa", "POST /register", "POST /password/forgot", "POST /password/reset",
+			"POST /phone/verify/request", "POST /phone/verify/confirm", "PUT /user/password",
+			"DELETE /user"),
+		Store:       middleware.NewMemoryRateLimitStore(),
+		IPLimit:     rateLimit("RATE_LIMIT_PER_IP", middleware.RateLimit{Requests: 20, Per: time.Minute}),
+		PhoneLimit:  rateLimit("RATE_LIMIT_PER_PHONE", middleware.RateLimit{Requests: 5, Per: time.Minute}),
+		PhoneRegion: os.Getenv("PHONE_DEFAULT_REGION"),
+	}))
+
+	repo := newRepository()
+	if requireMigrations() {
+		checkMigrations(repo)
+	}
+	revocationCache := middleware.NewRevocationCache(repo, revocationCacheTTL())
+
+	// Every operation marked with jwtAuth in api.yml needs a verified access token
+	e.Use(middleware.JWTWithConfig(middleware.JWTConfig{
+		Skipper:     middleware.SkipUnsecuredRoutes(swagger),
+		Keys:        keyRing,
+		Issuer:      utils.JWTIssuer(),
+		Audience:    utils.JWTAudience(),
+		Revocations: revocationCache,
+		Optional:    middleware.OptionalAuthRoutes(swagger),
+		Scopes:      middleware.RouteScopes(swagger),
+	}))
+
+	// Operations with x-roles in api.yml are only for users holding one of them
+	e.Use(middleware.Authorize(middleware.AuthorizeConfig{
+		Roles: middleware.RouteRoles(swagger),
+	}))
+
+	queue := newNotifier(repo)
+	var server generated.ServerInterface = newServer(repo, revocationCache, keyRing, queue)
+
+	// Accounts deleted through DELETE /user are removed for good once their
+	// grace period is over
+	go purge.NewPurger(repo, accountPurge()).Run(ctx)
 
 	generated.RegisterHandlers(e, server)
 
 	// Endpoint for serving Swagger JSON
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
 	port := os.Getenv("APP_PORT")
-	e.Logger.Fatal(e.Start(fmt.Sprintf(":%s", port)))
+	go func() {
+		if err := e.Start(fmt.Sprintf(":%s", port)); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	shutdown(e, queue)
+}
+
+// shutdown lets the requests in flight finish, then delivers the messages
+// still queued, giving both SHUTDOWN_TIMEOUT together. Messages that can't be
+// delivered in time are logged as failed deliveries.
+func shutdown(e *echo.Echo, queue *notifier.Queue) {
+	ctx, cancel := context.Background(), func() {}
+	if limit := timeout("SHUTDOWN_TIMEOUT", 10*time.Second); limit > 0 {
+		ctx, cancel = context.WithTimeout(ctx, limit)
+	}
+	defer cancel()
+
+	if err := e.Shutdown(ctx); err != nil {
+		log.Println("shutting down the server:", err)
+	}
+	if err := queue.Close(ctx); err != nil {
+		log.Println("draining the notification queue:", err)
+	}
 }
 
-func newServer() *handler.Server {
+func newRepository() *repository.Repository {
 	dbDsn := os.Getenv("DATABASE_URL")
-	var repo repository.RepositoryInterface = repository.NewRepository(repository.NewRepositoryOptions{
-		Dsn: dbDsn,
+	return repository.NewRepository(repository.NewRepositoryOptions{
+		Dsn:          dbDsn,
+		QueryTimeout: timeout("DB_QUERY_TIMEOUT", 5*time.Second),
 	})
+}
+
+// requireMigrations reads REQUIRE_MIGRATIONS, which makes the server refuse to
+// start on a database that is behind the migrations it was built with.
+func requireMigrations() bool {
+	required, err := strconv.ParseBool(os.Getenv("REQUIRE_MIGRATIONS"))
+	return err == nil && required
+}
+
+func checkMigrations(repo *repository.Repository) {
+	migrator, err := migrations.NewMigrator(repo.Db, migrations.Files())
+	if err != nil {
+		log.Fatal(err)
+	}
+	pending, err := migrator.Pending(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(pending) > 0 {
+		log.Fatalf("%d migrations are pending, run `migrate up` first", len(pending))
+	}
+}
+
+func newServer(repo repository.RepositoryInterface, revocationCache *middleware.RevocationCache, keyRing *utils.KeyRing, notifications notifier.Notifier) *handler.Server {
 	opts := handler.NewServerOptions{
-		Repository: repo,
+		Repository:        repo,
+		RevocationCache:   revocationCache,
+		KeyRing:           keyRing,
+		LoginLockout:      loginLockout(),
+		Notifier:          notifications,
+		PasswordReset:     passwordReset(),
+		PasswordPolicy:    passwordPolicy(),
+		PasswordHasher:    passwordHasher(),
+		CodeHasher:        codeHasher(),
+		PhoneRegion:       os.Getenv("PHONE_DEFAULT_REGION"),
+		PhoneVerification: phoneVerification(),
+		SecretBox:         secretBox(),
+		TOTP:              handler.TOTPOptions{Issuer: os.Getenv("TOTP_ISSUER")},
+		AuditLogger:       audit.NewDatabaseLogger(repo),
+		AccountDeletion:   accountDeletion(),
 	}
 	return handler.NewServer(opts)
 }
+
+// loginLockout reads how many consecutive bad passwords lock an account and
+// for how long, falling back to handler.DefaultLockoutOptions.
+func loginLockout() handler.LockoutOptions {
+	lockout := handler.DefaultLockoutOptions
+	if attempts, err := strconv.Atoi(os.Getenv("LOGIN_MAX_FAILED_ATTEMPTS")); err == nil && attempts > 0 {
+		lockout.MaxFailedAttempts = attempts
+	}
+	if duration, err := time.ParseDuration(os.Getenv("LOGIN_LOCKOUT_DURATION")); err == nil && duration > 0 {
+		lockout.Duration = duration
+	}
+	return lockout
+}
+
+// newNotifier queues messages for the provider named by NOTIFIER_PROVIDER and
+// logs their delivery in the database.
+func newNotifier(repo repository.RepositoryInterface) *notifier.Queue {
+	opts := notifier.DefaultQueueOptions
+	if size, err := strconv.Atoi(os.Getenv("NOTIFIER_QUEUE_SIZE")); err == nil && size > 0 {
+		opts.Size = size
+	}
+	if workers, err := strconv.Atoi(os.Getenv("NOTIFIER_WORKERS")); err == nil && workers > 0 {
+		opts.Workers = workers
+	}
+	if attempts, err := strconv.Atoi(os.Getenv("NOTIFIER_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		opts.MaxAttempts = attempts
+	}
+	return notifier.NewQueue(notificationProvider(), repo, opts)
+}
+
+// notificationProvider is "webhook", posting to NOTIFIER_WEBHOOK_URL, or "log",
+// writing messages to NOTIFIER_FILE or to stdout when it is unset.
+func notificationProvider() notifier.Provider {
+	switch provider := os.Getenv("NOTIFIER_PROVIDER"); provider {
+	case "webhook":
+		url := os.Getenv("NOTIFIER_WEBHOOK_URL")
+		if url == "" {
+			log.Fatal("NOTIFIER_WEBHOOK_URL is required by the webhook notifier")
+		}
+		return notifier.NewWebhookNotifier(url, os.Getenv("NOTIFIER_WEBHOOK_TOKEN"))
+	case "", "log":
+	default:
+		log.Fatalf("unknown NOTIFIER_PROVIDER %q", provider)
+	}
+
+	path := os.Getenv("NOTIFIER_FILE")
+	if path == "" {
+		return notifier.NewLogNotifier(os.Stdout)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return notifier.NewLogNotifier(file)
+}
+
+// passwordReset reads how long a password reset code is valid, falling back to
+// handler.DefaultPasswordResetOptions.
+func passwordReset() handler.PasswordResetOptions {
+	passwordReset := handler.DefaultPasswordResetOptions
+	if ttl, err := time.ParseDuration(os.Getenv("PASSWORD_RESET_CODE_TTL")); err == nil && ttl > 0 {
+		passwordReset.CodeTTL = ttl
+	}
+	return passwordReset
+}
+
+// passwordPolicy reads the rules new passwords must meet, falling back to
+// utils.DefaultPasswordPolicy for every variable that is unset.
+func passwordPolicy() utils.PasswordPolicy {
+	policy := utils.DefaultPasswordPolicy
+	if length, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil && length >= 0 {
+		policy.MinLength = length
+	}
+	if maxBytes, err := strconv.Atoi(os.Getenv("PASSWORD_MAX_BYTES")); err == nil && maxBytes > 0 {
+		policy.MaxBytes = maxBytes
+	}
+	rules := map[string]*bool{
+		"PASSWORD_REQUIRE_UPPER":   &policy.RequireUpper,
+		"PASSWORD_REQUIRE_LOWER":   &policy.RequireLower,
+		"PASSWORD_REQUIRE_DIGIT":   &policy.RequireDigit,
+		"PASSWORD_REQUIRE_SYMBOL":  &policy.RequireSymbol,
+		"PASSWORD_REJECT_COMMON":   &policy.RejectCommon,
+		"PASSWORD_REJECT_PERSONAL": &policy.RejectPersonal,
+	}
+	for name, rule := range rules {
+		if enabled, err := strconv.ParseBool(os.Getenv(name)); err == nil {
+			*rule = enabled
+		}
+	}
+	return policy
+}
+
+// passwordHasher reads the Argon2id parameters for new password hashes, falling
+// back to utils.DefaultArgon2idParams. Changing them upgrades stored hashes as
+// users log in.
+func passwordHasher() *utils.PasswordHasher {
+	params := utils.DefaultArgon2idParams
+	if memory, err := strconv.ParseUint(os.Getenv("ARGON2_MEMORY_KIB"), 10, 32); err == nil && memory > 0 {
+		params.Memory = uint32(memory)
+	}
+	if iterations, err := strconv.ParseUint(os.Getenv("ARGON2_ITERATIONS"), 10, 32); err == nil && iterations > 0 {
+		params.Iterations = uint32(iterations)
+	}
+	if parallelism, err := strconv.ParseUint(os.Getenv("ARGON2_PARALLELISM"), 10, 8); err == nil && parallelism > 0 {
+		params.Parallelism = uint8(parallelism)
+	}
+	return utils.NewPasswordHasher(params)
+}
+
+// phoneVerification reads how long a phone verification code is valid and
+// whether unverified accounts may log in, falling back to
+// handler.DefaultPhoneVerificationOptions.
+func phoneVerification() handler.PhoneVerificationOptions {
+	verification := handler.DefaultPhoneVerificationOptions
+	if ttl, err := time.ParseDuration(os.Getenv("PHONE_VERIFICATION_CODE_TTL")); err == nil && ttl > 0 {
+		verification.CodeTTL = ttl
+	}
+	if policy := os.Getenv("UNVERIFIED_LOGIN"); policy != "" {
+		verification.UnverifiedLogin = handler.UnverifiedLoginPolicy(policy)
+	}
+	return verification
+}
+
+// secretBox reads the key TOTP secrets are encrypted with from
+// TOTP_ENCRYPTION_KEY. Two-factor authentication is unavailable without it.
+func secretBox() *utils.SecretBox {
+	key := os.Getenv("TOTP_ENCRYPTION_KEY")
+	if key == "" {
+		log.Println("TOTP_ENCRYPTION_KEY is not set, two-factor authentication is disabled")
+		return nil
+	}
+	box, err := utils.ParseSecretBoxKey(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return box
+}
+
+// codeHasher reads the key one-time codes are hashed with from CODE_HASH_KEY.
+// Without it the key is random, so codes only verify on the instance that
+// sent them and not after a restart.
+func codeHasher() *utils.CodeHasher {
+	key := os.Getenv("CODE_HASH_KEY")
+	if key == "" {
+		log.Println("CODE_HASH_KEY is not set, one-time codes only verify on this instance")
+		return nil
+	}
+	hasher, err := utils.ParseCodeHasherKey(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return hasher
+}
+
+// rateLimit reads a "<requests>/<duration>" limit from the environment variable
+// name, falling back to fallback when it is unset or invalid.
+func rateLimit(name string, fallback middleware.RateLimit) middleware.RateLimit {
+	limit, err := middleware.ParseRateLimit(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return limit
+}
+
+// revocationCacheTTL is how long a "token is not revoked" answer is trusted
+// before the database is asked again.
+func revocationCacheTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("REVOCATION_CACHE_TTL"))
+	if err != nil || ttl <= 0 {
+		return 30 * time.Second
+	}
+	return ttl
+}
+
+// accountDeletion reads how long a deleted account keeps its phone number
+// before it is purged, falling back to handler.DefaultAccountDeletionOptions.
+func accountDeletion() handler.AccountDeletionOptions {
+	opts := handler.DefaultAccountDeletionOptions
+	if grace, err := time.ParseDuration(os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD")); err == nil && grace > 0 {
+		opts.GracePeriod = grace
+	}
+	return opts
+}
+
+// accountPurge reads how often deleted accounts are looked for, falling back
+// to purge.DefaultOptions.
+func accountPurge() purge.Options {
+	opts := purge.DefaultOptions
+	if interval, err := time.ParseDuration(os.Getenv("ACCOUNT_PURGE_INTERVAL")); err == nil && interval > 0 {
+		opts.Interval = interval
+	}
+	return opts
+}
+
+// timeout reads a duration such as "10s" from the environment variable name.
+// "0" turns the timeout off.
+func timeout(name string, fallback time.Duration) time.Duration {
+	duration, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || duration < 0 {
+		return fallback
+	}
+	return duration
+}
@@ -0,0 +1,114 @@
+// Command migrate applies the versioned schema in ./migrations to the
+// database at DATABASE_URL.
+//
+//	go run ./cmd/migrate up            apply every pending migration
+//	go run ./cmd/migrate down [n]      roll back the last n migrations (1)
+//	go run ./cmd/migrate status        list migrations and when they were applied
+//	go run ./cmd/migrate baseline [v]  record migrations up to v (1) as applied without running them
+//	go run ./cmd/migrate create <name> add an empty migration to -dir
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	_ "github.com/lib/pq"
+	"os"
+	"strconv"
+
+	"github.com/SawitProRecruitment/UserService/migrations"
+	_ "github.com/joho/godotenv/autoload"
+)
+
+const usage = "usage: migrate [-dir migrations] up | down [n] | status | baseline [version] | create <name>"
+
+func main() {
+	dir := flag.String("dir", "migrations", "directory that create writes new migrations to")
+	flag.Usage = func() { fmt.Fprintln(os.Stderr, usage) }
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if args[0] == "create" {
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		up, down, err := migrations.Create(*dir, args[1])
+		exitOnError(err)
+		fmt.Println(up)
+		fmt.Println(down)
+		return
+	}
+
+	db, err := sql.Open("postgres", os.Getenv("DATABASE_URL"))
+	exitOnError(err)
+	defer db.Close()
+	migrator, err := migrations.NewMigrator(db, migrations.Files())
+	exitOnError(err)
+	ctx := context.Background()
+
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		exitOnError(err)
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+	case args[0] == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				flag.Usage()
+				os.Exit(2)
+			}
+		}
+		rolledBack, err := migrator.Down(ctx, steps)
+		for _, migration := range rolledBack {
+			fmt.Printf("rolled back %04d_%s\n", migration.Version, migration.Name)
+		}
+		exitOnError(err)
+	case args[0] == "baseline" && len(args) <= 2:
+		var version int64 = 1
+		if len(args) == 2 {
+			version, err = strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				flag.Usage()
+				os.Exit(2)
+			}
+		}
+		recorded, err := migrator.Baseline(ctx, version)
+		for _, migration := range recorded {
+			fmt.Printf("recorded %04d_%s as applied\n", migration.Version, migration.Name)
+		}
+		exitOnError(err)
+	case args[0] == "status" && len(args) == 1:
+		statuses, err := migrator.Status(ctx)
+		exitOnError(err)
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,38 @@
+// Command setrole changes the role of a user. It bootstraps the first
+// administrator, who can then hand out roles through PUT /admin/users/{id}/role.
+//
+//	go run ./cmd/setrole <user_id> <user|support|admin>
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/SawitProRecruitment/UserService/utils"
+	_ "github.com/joho/godotenv/autoload"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: setrole <user_id> <user|support|admin>")
+		os.Exit(2)
+	}
+
+	role := os.Args[2]
+	if role != utils.RoleUser && role != utils.RoleSupport && role != utils.RoleAdmin {
+		fmt.Fprintf(os.Stderr, "unknown role %q\n", role)
+		os.Exit(2)
+	}
+
+	repo := repository.NewRepository(repository.NewRepositoryOptions{
+		Dsn: os.Getenv("DATABASE_URL"),
+	})
+	if err := repo.SetUserRole(context.Background(), os.Args[1], role, time.Now()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("user %s is now %s, the new role applies from their next login\n", os.Args[1], role)
+}
@@ -0,0 +1,30 @@
+// Command unlock clears the failed login counter and lockout of a user so
+// they can log in again before the lockout expires.
+//
+//	go run ./cmd/unlock <user_id>
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/SawitProRecruitment/UserService/repository"
+	_ "github.com/joho/godotenv/autoload"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: unlock <user_id>")
+		os.Exit(2)
+	}
+
+	repo := repository.NewRepository(repository.NewRepositoryOptions{
+		Dsn: os.Getenv("DATABASE_URL"),
+	})
+	if err := repo.UnlockUser(context.Background(), os.Args[1]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("user %s unlocked\n", os.Args[1])
+}
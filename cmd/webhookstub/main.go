@@ -0,0 +1,63 @@
+// Command webhookstub stands in for an SMS gateway during local development.
+// It prints every message the webhook notifier posts to it.
+//
+//	go run ./cmd/webhookstub -addr :8090
+//
+// and set NOTIFIER_PROVIDER=webhook and
+// NOTIFIER_WEBHOOK_URL=http://localhost:8090/messages. With -fail-every n,
+// every nth request answers 503 to exercise the retries.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"sync"
+)
+
+func main() {
+	addr := flag.String("addr", ":8090", "address to listen on")
+	failEvery := flag.Int("fail-every", 0, "answer 503 to every nth request, 0 never")
+	flag.Parse()
+
+	var mu sync.Mutex
+	requests := 0
+	delivered := map[string]bool{}
+
+	http.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var msg struct {
+			ID          string `json:"id"`
+			PhoneNumber string `json:"phone_number"`
+			Body        string `json:"body"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if *failEvery > 0 && requests%*failEvery == 0 {
+			log.Printf("failing id=%s", msg.ID)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if key := r.Header.Get("Idempotency-Key"); key != "" && delivered[key] {
+			log.Printf("duplicate id=%s", key)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		delivered[r.Header.Get("Idempotency-Key")] = true
+		log.Printf("id=%s to=%s body=%q", msg.ID, msg.PhoneNumber, msg.Body)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	log.Printf("listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
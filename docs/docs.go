@@ -19,6 +19,378 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/.well-known/jwks.json": {
+            "get": {
+                "description": "Public keys used to verify the tokens issued by this service",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "GetJWKS",
+                "operationId": "GetJWKS",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/utils.JWKSet"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/users": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List and filter the users, newest first by default, for support staff",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "ListUsers",
+                "operationId": "ListUsers",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Most users to return",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor or prev_cursor of the previous response",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "created_at, last_login or full_name",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "asc or desc",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only users created at or after this time",
+                        "name": "created_from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only users created before this time",
+                        "name": "created_to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only users who last logged in at or after this time",
+                        "name": "last_login_from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only users who last logged in before this time",
+                        "name": "last_login_to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only users whose name starts with this",
+                        "name": "name_prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only users whose phone number starts with this",
+                        "name": "phone_prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "active, disabled or locked",
+                        "name": "status",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/users/search": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Find users by name, even misspelled, or by the start of their phone number, best match first, for support staff",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "SearchUsers",
+                "operationId": "SearchUsers",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "A name, or the start of a phone number",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Most users to return",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/users/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Look up a user, for support staff",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "GetUser",
+                "operationId": "GetUser",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/users/{id}/disable": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Log every session of a user out and keep them from logging in",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "DisableUser",
+                "operationId": "DisableUser",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/users/{id}/enable": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Let a disabled user log in again",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "EnableUser",
+                "operationId": "EnableUser",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/users/{id}/logout": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Log every session of a user out",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "LogoutUser",
+                "operationId": "LogoutUser",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/users/{id}/role": {
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Change the role of a user and log every session of theirs out, for administrators",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "ChangeUserRole",
+                "operationId": "ChangeUserRole",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Change User Role JSON Body",
+                        "name": "role",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/generated.ChangeUserRoleJSONRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/users/{id}/unlock": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Clear the failed login counter and lockout of a user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "UnlockUser",
+                "operationId": "UnlockUser",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
         "/login": {
             "post": {
                 "description": "Login Existing User",
@@ -28,16 +400,397 @@ const docTemplate = `{
                 "produces": [
                     "application/json"
                 ],
-                "summary": "LoginUser",
-                "operationId": "LoginUser",
+                "summary": "LoginUser",
+                "operationId": "LoginUser",
+                "parameters": [
+                    {
+                        "description": "Login User JSON Body",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/generated.LoginUserJSONRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/login/mfa": {
+            "post": {
+                "description": "Exchange the MFA token of LoginUser and a TOTP or recovery code for a token pair",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "LoginMFA",
+                "operationId": "LoginMFA",
+                "parameters": [
+                    {
+                        "description": "Login MFA JSON Body",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/generated.LoginMFAJSONRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/logout": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Revoke the current session",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Logout",
+                "operationId": "Logout",
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/logout/all": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Revoke every session of the user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "LogoutAll",
+                "operationId": "LogoutAll",
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/password/forgot": {
+            "post": {
+                "description": "Send a one-time password reset code to the phone number",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "ForgotPassword",
+                "operationId": "ForgotPassword",
+                "parameters": [
+                    {
+                        "description": "Forgot Password JSON Body",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/generated.ForgotPasswordJSONRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/password/reset": {
+            "post": {
+                "description": "Set a new password with a reset code sent by ForgotPassword",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "ResetPassword",
+                "operationId": "ResetPassword",
+                "parameters": [
+                    {
+                        "description": "Reset Password JSON Body",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/generated.ResetPasswordJSONRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/phone/verify/confirm": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Verify the phone number with the code sent by RequestPhoneVerification",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "ConfirmPhoneVerification",
+                "operationId": "ConfirmPhoneVerification",
+                "parameters": [
+                    {
+                        "description": "Confirm Phone Verification JSON Body",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/generated.ConfirmPhoneVerificationJSONRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/phone/verify/request": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Send a one-time code to the phone number waiting for verification",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "RequestPhoneVerification",
+                "operationId": "RequestPhoneVerification",
+                "parameters": [
+                    {
+                        "description": "Request Phone Verification JSON Body",
+                        "name": "user",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/generated.RequestPhoneVerificationJSONRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/register": {
+            "post": {
+                "description": "Register New User",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "RegisterTheUser",
+                "operationId": "RegisterTheUser",
+                "parameters": [
+                    {
+                        "description": "Register user JSON Body",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/generated.RegisterTheUserJSONRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/token/refresh": {
+            "post": {
+                "description": "Exchange a refresh token for a new access and refresh token",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "RefreshToken",
+                "operationId": "RefreshToken",
+                "parameters": [
+                    {
+                        "description": "Refresh Token JSON Body",
+                        "name": "token",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/generated.RefreshTokenJSONRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/user": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get User Profile",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "GetProfile",
+                "operationId": "GetProfile",
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Update User Profile",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "UpdateProfile",
+                "operationId": "UpdateProfile",
+                "parameters": [
+                    {
+                        "description": "Update User Profile JSON Body",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/generated.UpdateProfileJSONRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Delete the account of the user and log out every session",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "DeleteAccount",
+                "operationId": "DeleteAccount",
                 "parameters": [
                     {
-                        "description": "Login User JSON Body",
+                        "description": "Delete Account JSON Body",
                         "name": "user",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/generated.LoginUserJSONRequestBody"
+                            "$ref": "#/definitions/generated.DeleteAccountJSONRequestBody"
                         }
                     }
                 ],
@@ -51,28 +804,62 @@ const docTemplate = `{
                 }
             }
         },
-        "/register": {
-            "post": {
-                "description": "Register New User",
+        "/user/activity": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List the security events of the user's account, newest first",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "RegisterTheUser",
-                "operationId": "RegisterTheUser",
+                "summary": "GetActivity",
+                "operationId": "GetActivity",
                 "parameters": [
                     {
-                        "description": "Register user JSON Body",
-                        "name": "user",
-                        "in": "body",
-                        "required": true,
+                        "type": "integer",
+                        "description": "Most events to return",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only return events older than this event ID",
+                        "name": "before",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
                         "schema": {
-                            "$ref": "#/definitions/generated.RegisterTheUserJSONRequestBody"
+                            "type": "string"
                         }
                     }
+                }
+            }
+        },
+        "/user/export": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Download the profile, sessions and activity log of the user",
+                "consumes": [
+                    "application/json"
                 ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "ExportUserData",
+                "operationId": "ExportUserData",
                 "responses": {
                     "200": {
                         "description": "ok",
@@ -83,22 +870,22 @@ const docTemplate = `{
                 }
             }
         },
-        "/user": {
-            "get": {
+        "/user/mfa/totp": {
+            "post": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Get User Profile",
+                "description": "Start enrolling an authenticator app, returning its secret and otpauth:// URI",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "GetProfile",
-                "operationId": "GetProfile",
+                "summary": "EnrollTOTP",
+                "operationId": "EnrollTOTP",
                 "responses": {
                     "200": {
                         "description": "ok",
@@ -107,32 +894,132 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
+            }
+        },
+        "/user/mfa/totp/confirm": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Enable two-factor authentication with a code from the app enrolled by EnrollTOTP",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "ConfirmTOTP",
+                "operationId": "ConfirmTOTP",
+                "parameters": [
+                    {
+                        "description": "Confirm TOTP JSON Body",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/generated.ConfirmTOTPJSONRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/password": {
             "put": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Update User Profile",
+                "description": "Change the password of the user and log out every other session",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "UpdateProfile",
-                "operationId": "UpdateProfile",
+                "summary": "ChangePassword",
+                "operationId": "ChangePassword",
                 "parameters": [
                     {
-                        "description": "Update User Profile JSON Body",
+                        "description": "Change Password JSON Body",
                         "name": "user",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/generated.UpdateProfileJSONRequestBody"
+                            "$ref": "#/definitions/generated.ChangePasswordJSONRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/sessions": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List the sessions the user is logged in with",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "GetSessions",
+                "operationId": "GetSessions",
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
                         }
                     }
+                }
+            }
+        },
+        "/user/sessions/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Log out a single session of the user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "RevokeSession",
+                "operationId": "RevokeSession",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Session ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
                 "responses": {
                     "200": {
@@ -146,13 +1033,115 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "generated.ChangePasswordJSONRequestBody": {
+            "type": "object",
+            "properties": {
+                "current_password": {
+                    "type": "string"
+                },
+                "new_password": {
+                    "type": "string"
+                }
+            }
+        },
+        "generated.ChangeUserRoleJSONRequestBody": {
+            "type": "object",
+            "properties": {
+                "role": {
+                    "$ref": "#/definitions/generated.ChangeUserRoleRequestRole"
+                }
+            }
+        },
+        "generated.ChangeUserRoleRequestRole": {
+            "type": "string",
+            "enum": [
+                "admin",
+                "support",
+                "user"
+            ],
+            "x-enum-varnames": [
+                "ChangeUserRoleRequestRoleAdmin",
+                "ChangeUserRoleRequestRoleSupport",
+                "ChangeUserRoleRequestRoleUser"
+            ]
+        },
+        "generated.ConfirmPhoneVerificationJSONRequestBody": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "phone_number": {
+                    "description": "PhoneNumber Normalized to E.164, e.g. +62812345678. Numbers without a country calling code are read as numbers of the default region",
+                    "type": "string"
+                }
+            }
+        },
+        "generated.ConfirmTOTPJSONRequestBody": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                }
+            }
+        },
+        "generated.DeleteAccountJSONRequestBody": {
+            "type": "object",
+            "properties": {
+                "password": {
+                    "type": "string"
+                }
+            }
+        },
+        "generated.ForgotPasswordJSONRequestBody": {
+            "type": "object",
+            "properties": {
+                "phone_number": {
+                    "description": "PhoneNumber Normalized to E.164, e.g. +62812345678. Numbers without a country calling code are read as numbers of the default region",
+                    "type": "string"
+                }
+            }
+        },
+        "generated.LoginMFAJSONRequestBody": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "Code Code shown by the authenticator app. Either code or recovery_code is required",
+                    "type": "string"
+                },
+                "device_name": {
+                    "description": "DeviceName Name of the device logging in, shown in the session list",
+                    "type": "string"
+                },
+                "mfa_token": {
+                    "type": "string"
+                },
+                "recovery_code": {
+                    "description": "RecoveryCode One of the recovery codes given when two-factor authentication was enabled",
+                    "type": "string"
+                }
+            }
+        },
         "generated.LoginUserJSONRequestBody": {
             "type": "object",
             "properties": {
+                "device_name": {
+                    "description": "DeviceName Name of the device logging in, shown in the session list, e.g. \"Pixel 8\"",
+                    "type": "string"
+                },
                 "password": {
                     "type": "string"
                 },
                 "phone_number": {
+                    "description": "PhoneNumber Normalized to E.164, e.g. +62812345678. Numbers without a country calling code are read as numbers of the default region",
+                    "type": "string"
+                }
+            }
+        },
+        "generated.RefreshTokenJSONRequestBody": {
+            "type": "object",
+            "properties": {
+                "refresh_token": {
                     "type": "string"
                 }
             }
@@ -167,6 +1156,31 @@ const docTemplate = `{
                     "type": "string"
                 },
                 "phone_number": {
+                    "description": "PhoneNumber Normalized to E.164, e.g. +62812345678. Numbers without a country calling code are read as numbers of the default region",
+                    "type": "string"
+                }
+            }
+        },
+        "generated.RequestPhoneVerificationJSONRequestBody": {
+            "type": "object",
+            "properties": {
+                "phone_number": {
+                    "description": "PhoneNumber Normalized to E.164, e.g. +62812345678. Numbers without a country calling code are read as numbers of the default region",
+                    "type": "string"
+                }
+            }
+        },
+        "generated.ResetPasswordJSONRequestBody": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "new_password": {
+                    "type": "string"
+                },
+                "phone_number": {
+                    "description": "PhoneNumber Normalized to E.164, e.g. +62812345678. Numbers without a country calling code are read as numbers of the default region",
                     "type": "string"
                 }
             }
@@ -178,7 +1192,51 @@ const docTemplate = `{
                     "type": "string"
                 },
                 "phone_number": {
+                    "description": "PhoneNumber Normalized to E.164, e.g. +62812345678. Numbers without a country calling code are read as numbers of the default region",
+                    "type": "string"
+                }
+            }
+        },
+        "utils.JWK": {
+            "type": "object",
+            "properties": {
+                "alg": {
+                    "type": "string"
+                },
+                "crv": {
+                    "type": "string"
+                },
+                "e": {
+                    "type": "string"
+                },
+                "kid": {
                     "type": "string"
+                },
+                "kty": {
+                    "type": "string"
+                },
+                "n": {
+                    "type": "string"
+                },
+                "use": {
+                    "type": "string"
+                },
+                "x": {
+                    "type": "string"
+                },
+                "y": {
+                    "type": "string"
+                }
+            }
+        },
+        "utils.JWKSet": {
+            "type": "object",
+            "properties": {
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/utils.JWK"
+                    }
                 }
             }
         }
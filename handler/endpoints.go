@@ -2,16 +2,22 @@ package handler
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"github.com/SawitProRecruitment/UserService/audit"
 	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/middleware"
+	"github.com/SawitProRecruitment/UserService/notifier"
+	"github.com/SawitProRecruitment/UserService/phone"
 	"github.com/SawitProRecruitment/UserService/repository"
 	"github.com/SawitProRecruitment/UserService/utils"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"io"
+	"math"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -29,7 +35,7 @@ import (
 func (s *Server) RegisterTheUser(ctx echo.Context) error {
 	body, err := io.ReadAll(ctx.Request().Body)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Request Body").SetInternal(err)
 	}
 
 	var regUser generated.RegisterTheUserJSONRequestBody
@@ -47,42 +53,53 @@ func (s *Server) RegisterTheUser(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Password Cannot Be empty")
 	}
 
-	if !utils.CheckPhoneNumber(regUser.PhoneNumber) {
-		return echo.NewHTTPError(http.StatusBadRequest, "Phone Number Format is not Valid")
-	}
-
-	checkUser, err := s.Repository.CheckPhoneNumber(context.Background(), regUser.PhoneNumber)
+	phoneNumber, err := s.normalizePhoneNumber(regUser.PhoneNumber)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, "Phone Number Format is not Valid")
 	}
 
-	if checkUser != 0 {
-		return echo.NewHTTPError(http.StatusConflict, errors.New("Phone number already existed"))
+	if err := s.validatePassword(regUser.Password, phoneNumber, regUser.FullName); err != nil {
+		return err
 	}
 
 	userId := uuid.New()
-	hashedPassword, err := utils.HashPassword(regUser.Password)
+	hashedPassword, err := s.passwordHasher().Hash(regUser.Password)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return httpError(err)
 	}
 
 	user := repository.User{
 		UserID:                   userId.String(),
 		FullName:                 regUser.FullName,
-		PhoneNumber:              regUser.PhoneNumber,
+		PhoneNumber:              phoneNumber,
 		Password:                 hashedPassword,
 		SuccessfullLoginAttempts: 0,
 		CreatedAt:                time.Now(),
 		UpdatedAt:                time.Now(),
 	}
 
-	err = s.Repository.RegisterUser(user)
+	// The unique constraint on phone_number decides which of several
+	// concurrent registrations of a number wins, so there is no check first
+	err = s.Repository.RegisterUser(ctx.Request().Context(), user)
+	if repository.IsConflictOn(err, repository.ConstraintUsersPhoneNumber) {
+		return echo.NewHTTPError(http.StatusConflict, "Phone number already existed")
+	}
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return httpError(err)
 	}
 
+	s.recordAuditEvent(ctx, audit.Event{
+		Type:   audit.EventRegister,
+		UserID: user.UserID,
+		Changes: audit.Diff(nil, map[string]interface{}{
+			"full_name":    user.FullName,
+			"phone_number": user.PhoneNumber,
+		}),
+		CreatedAt: user.CreatedAt,
+	})
+
 	response := map[string]string{
-		"message": "Successfully Registered!",
+		"message": "Successfully Registered! Please Verify Your Phone Number",
 	}
 	return ctx.JSON(http.StatusCreated, response)
 }
@@ -110,36 +127,506 @@ func (s *Server) LoginUser(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Please input your phone number and password")
 	}
 
-	getUser, err := s.Repository.CheckUser(context.Background(), loginUser.PhoneNumber)
+	phoneNumber, err := s.normalizePhoneNumber(loginUser.PhoneNumber)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Phone Number Format is not Valid")
+	}
+
+	hasher := s.passwordHasher()
+	getUser, err := s.Repository.CheckUser(ctx.Request().Context(), phoneNumber)
+	if errors.Is(err, repository.ErrNotFound) {
+		// An unknown number gets the answer of a wrong password, after as much
+		// work as checking one, so it can't be told whether it is registered
+		hasher.Hash(loginUser.Password)
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Password")
+	}
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return httpError(err)
+	}
+
+	currentTime := time.Now()
+	if err := s.reserveLoginAttempt(ctx, getUser, currentTime); err != nil {
+		return err
 	}
 
-	if err := utils.CheckPassword(loginUser.Password, getUser.Password); err != nil {
+	if err := hasher.Verify(loginUser.Password, getUser.Password); err != nil {
+		s.recordLoginFailed(ctx, getUser.UserID, "invalid_password", currentTime)
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Password")
 	}
 
+	// Only told once the password was right, so the state of an account
+	// doesn't leak to whoever types in its phone number
+	if getUser.DisabledAt != nil {
+		s.recordLoginFailed(ctx, getUser.UserID, "account_disabled", currentTime)
+		return echo.NewHTTPError(http.StatusForbidden, "Account Is Disabled")
+	}
+
+	// The password was right, so the failed attempts are reset even when an
+	// unverified phone number keeps the user from logging in. With two-factor
+	// authentication they are only reset once LoginMFA checked the second
+	// factor, so the lockout also limits guesses at the code. Only the lock
+	// this attempt may have set is lifted, so LoginMFA gets one try.
+	scopes := s.loginScopes(getUser)
+	blocked := len(scopes) > 0 && s.PhoneVerification.withDefaults().UnverifiedLogin == UnverifiedLoginBlock
+	needsMFA := getUser.TOTPEnabledAt != nil && !blocked
+	if blocked {
+		if err := s.Repository.ResetFailedLoginAttempts(ctx.Request().Context(), getUser.ID); err != nil {
+			return httpError(err)
+		}
+	} else if needsMFA {
+		if err := s.Repository.ClearLoginLock(ctx.Request().Context(), getUser.ID); err != nil {
+			return httpError(err)
+		}
+	} else {
+		if err := s.Repository.RecordLoginSuccess(ctx.Request().Context(), getUser.ID, currentTime); err != nil {
+			return httpError(err)
+		}
+	}
+
+	// The plaintext password is only at hand during login, so that is when
+	// hashes made with an older algorithm or parameters get upgraded. A failed
+	// upgrade is retried on the next login rather than failing this one.
+	if hasher.NeedsRehash(getUser.Password) {
+		if err := s.rehashPassword(ctx.Request().Context(), hasher, getUser, loginUser.Password); err != nil {
+			ctx.Logger().Error(err)
+		}
+	}
+
+	if blocked {
+		s.recordLoginFailed(ctx, getUser.UserID, "phone_not_verified", currentTime)
+		return echo.NewHTTPError(http.StatusForbidden, "Phone Number Is Not Verified")
+	}
+
+	if needsMFA {
+		mfaToken, _, err := utils.GenerateMFAToken(getUser.UserID, s.KeyRing)
+		if err != nil {
+			return httpError(err)
+		}
+		return ctx.JSON(http.StatusOK, map[string]interface{}{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+		})
+	}
+
+	tokens, err := s.startTokenFamily(ctx, getUser, loginUser.DeviceName, currentTime)
+	if err != nil {
+		return httpError(err)
+	}
+
+	s.recordAuditEvent(ctx, audit.Event{
+		Type:      audit.EventLoginSucceeded,
+		UserID:    getUser.UserID,
+		Reason:    "password",
+		CreatedAt: currentTime,
+	})
+
+	response := map[string]string{
+		"access_token":  tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// reserveLoginAttempt counts a login attempt for user, refusing it while the
+// account is locked. Every attempt is counted before the password or code is
+// checked, so concurrent guesses can't get past the limit while the first ones
+// are still running.
+func (s *Server) reserveLoginAttempt(ctx echo.Context, user *repository.User, currentTime time.Time) error {
+	if user.LockedUntil != nil && user.LockedUntil.After(currentTime) {
+		s.recordLoginFailed(ctx, user.UserID, "account_locked", currentTime)
+		return accountLockedError(ctx, *user.LockedUntil, currentTime)
+	}
+
+	lockout := s.LoginLockout.withDefaults()
+	allowed, err := s.Repository.ReserveLoginAttempt(ctx.Request().Context(), repository.LoginAttemptInput{
+		ID:                user.ID,
+		MaxFailedAttempts: lockout.MaxFailedAttempts,
+		LockedUntil:       currentTime.Add(lockout.Duration),
+		AttemptedAt:       currentTime,
+	})
+	if err != nil {
+		return httpError(err)
+	}
+	if !allowed {
+		// A concurrent attempt locked the account since it was read, so the
+		// lock it stored is the one to wait for
+		locked, err := s.Repository.GetUserByUserId(ctx.Request().Context(), user.UserID)
+		if err != nil {
+			return httpError(err)
+		}
+		lockedUntil := currentTime
+		if locked.LockedUntil != nil {
+			lockedUntil = *locked.LockedUntil
+		}
+		s.recordLoginFailed(ctx, user.UserID, "account_locked", currentTime)
+		return accountLockedError(ctx, lockedUntil, currentTime)
+	}
+	return nil
+}
+
+// recordLoginFailed adds a failed login of the user to the audit log, with
+// reason telling what stopped it.
+func (s *Server) recordLoginFailed(ctx echo.Context, userID, reason string, currentTime time.Time) {
+	s.recordAuditEvent(ctx, audit.Event{
+		Type:      audit.EventLoginFailed,
+		UserID:    userID,
+		Reason:    reason,
+		CreatedAt: currentTime,
+	})
+}
+
+// rehashPassword stores a fresh hash of password for user, made with the
+// current hasher settings.
+func (s *Server) rehashPassword(ctx context.Context, hasher *utils.PasswordHasher, user *repository.User, password string) error {
+	hashedPassword, err := hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	return s.Repository.RehashPassword(ctx, user.UserID, user.Password, hashedPassword)
+}
+
+// accountLockedError tells the client the account is locked and when to try
+// again.
+func accountLockedError(ctx echo.Context, lockedUntil, currentTime time.Time) error {
+	retryAfter := int(math.Ceil(lockedUntil.Sub(currentTime).Seconds()))
+	ctx.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	return echo.NewHTTPError(http.StatusLocked, "Account Is Locked Because Of Too Many Failed Login Attempts")
+}
+
+// httpError turns an error the handler can't recover from, usually from the
+// repository, into a response with a fixed message, so no internal detail
+// reaches the client. The error itself is kept as the internal error for the
+// logs.
+func httpError(err error) *echo.HTTPError {
+	var status int
+	var message string
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		status, message = http.StatusGatewayTimeout, "Request Timed Out"
+	case errors.Is(err, repository.ErrNotFound):
+		status, message = http.StatusNotFound, "Resource Not Found"
+	case errors.Is(err, repository.ErrConflict):
+		status, message = http.StatusConflict, "Resource Already Exists"
+	case errors.Is(err, repository.ErrUnavailable), errors.Is(err, context.Canceled),
+		errors.Is(err, notifier.ErrQueueFull), errors.Is(err, notifier.ErrQueueClosed):
+		status, message = http.StatusServiceUnavailable, "Service Is Temporarily Unavailable, Please Try Again Later"
+	default:
+		status, message = http.StatusInternalServerError, "Internal Server Error"
+	}
+	return echo.NewHTTPError(status, message).SetInternal(err)
+}
+
+// RefreshToken
+//
+//	@Summary		RefreshToken
+//	@Description	Exchange a refresh token for a new access and refresh token
+//	@ID				RefreshToken
+//	@Accept			application/json
+//	@Produce		json
+//	@Param			token	body	generated.RefreshTokenJSONRequestBody	true	"Refresh Token JSON Body"
+//	@Success		200		{string}	string			"ok"
+//	@Router			/token/refresh [post]
+func (s *Server) RefreshToken(ctx echo.Context) error {
+	body, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Request Body").SetInternal(err)
+	}
+
+	var refreshRequest generated.RefreshTokenJSONRequestBody
+	json.Unmarshal(body, &refreshRequest)
+
+	if refreshRequest.RefreshToken == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Refresh Token Cannot Be Empty")
+	}
+
+	claims, err := utils.ValidateJWTToken(refreshRequest.RefreshToken, s.KeyRing, utils.JWTIssuer(), utils.JWTAudience())
+	if err != nil || claims.TokenType != utils.RefreshTokenType {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid Refresh Token")
+	}
+
+	storedToken, err := s.Repository.GetRefreshToken(ctx.Request().Context(), claims.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid Refresh Token")
+	}
+	if err != nil {
+		return httpError(err)
+	}
+
+	if storedToken.FamilyRevokedAt != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Refresh Token Has Been Revoked")
+	}
+
+	// A refresh token can only be exchanged once. Seeing it again means
+	// somebody else holds a copy, so every token of the login is revoked.
 	currentTime := time.Now()
-	getUser.SuccessfullLoginAttempts += 1
-	getUser.LastLogin = &currentTime
+	used := storedToken.UsedAt != nil
+	if !used {
+		marked, err := s.Repository.MarkRefreshTokenUsed(ctx.Request().Context(), storedToken.TokenID, currentTime)
+		if err != nil {
+			return httpError(err)
+		}
+		used = !marked
+	}
+	if used {
+		if err := s.Repository.RevokeTokenFamily(ctx.Request().Context(), storedToken.FamilyID, currentTime); err != nil {
+			return httpError(err)
+		}
+		// The access tokens of the family may be in the hands of whoever
+		// reused it, so they stop working here at once
+		if s.RevocationCache != nil {
+			s.RevocationCache.TokenFamilyRevoked(storedToken.FamilyID)
+		}
+		s.recordAuditEvent(ctx, audit.Event{
+			Type:      audit.EventTokenRevoked,
+			UserID:    storedToken.UserID,
+			Reason:    "refresh_token_reused",
+			CreatedAt: currentTime,
+		})
+		return echo.NewHTTPError(http.StatusUnauthorized, "Refresh Token Has Already Been Used")
+	}
+
+	// Restricted tokens are only renewed with the scopes the user still needs,
+	// so verifying the phone number lifts the restriction on the next refresh.
+	var scopes []string
+	if len(claims.Scopes) > 0 {
+		getUser, err := s.Repository.GetUserByUserId(ctx.Request().Context(), storedToken.UserID)
+		if err != nil {
+			return httpError(err)
+		}
+		scopes = s.loginScopes(getUser)
+	}
+
+	tokens, err := s.issueTokens(ctx.Request().Context(), storedToken.UserID, storedToken.FamilyID, claims.Role, scopes...)
+	if err != nil {
+		return httpError(err)
+	}
+
+	// The session list only needs to be roughly up to date, so a failure to
+	// record this refresh doesn't fail it
+	err = s.Repository.TouchTokenFamily(ctx.Request().Context(), repository.TokenFamilySeen{
+		FamilyID:  storedToken.FamilyID,
+		IPAddress: ctx.RealIP(),
+		UserAgent: truncate(ctx.Request().UserAgent(), maxUserAgentLength),
+		SeenAt:    currentTime,
+	})
+	if err != nil {
+		ctx.Logger().Error(err)
+	}
+
+	response := map[string]string{
+		"access_token":  tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+	}
 
-	if err := s.Repository.UpdateLoginUser(context.Background(), *getUser); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// Logout
+//
+//	@Summary		Logout
+//	@Description	Revoke the current session
+//	@ID				Logout
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Success		200		{string}	string			"ok"
+//	@Router			/logout [post]
+func (s *Server) Logout(ctx echo.Context) error {
+	principal, ok := middleware.GetPrincipal(ctx)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
 	}
 
-	accessToken, refreshToken, err := utils.GenerateJWTToken(getUser.UserID, os.Getenv("JWT_SECRET"))
+	currentTime := time.Now()
+	err := s.Repository.RevokeToken(ctx.Request().Context(), repository.RevokedToken{
+		TokenID:   principal.TokenID,
+		UserID:    principal.UserID,
+		ExpiresAt: principal.ExpiresAt,
+		RevokedAt: currentTime,
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return httpError(err)
+	}
+
+	if principal.FamilyID != "" {
+		if err := s.Repository.RevokeTokenFamily(ctx.Request().Context(), principal.FamilyID, currentTime); err != nil {
+			return httpError(err)
+		}
+	}
+
+	if s.RevocationCache != nil {
+		s.RevocationCache.TokenRevoked(principal.TokenID, principal.ExpiresAt)
+		if principal.FamilyID != "" {
+			s.RevocationCache.TokenFamilyRevoked(principal.FamilyID)
+		}
 	}
 
+	s.recordAuditEvent(ctx, audit.Event{
+		Type:      audit.EventTokenRevoked,
+		UserID:    principal.UserID,
+		Reason:    "logout",
+		CreatedAt: currentTime,
+	})
+
 	response := map[string]string{
-		"access_token":  accessToken,
-		"refresh_token": refreshToken,
+		"message": "Successfully Logged Out!",
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// LogoutAll
+//
+//	@Summary		LogoutAll
+//	@Description	Revoke every session of the user
+//	@ID				LogoutAll
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Success		200		{string}	string			"ok"
+//	@Router			/logout/all [post]
+func (s *Server) LogoutAll(ctx echo.Context) error {
+	principal, ok := middleware.GetPrincipal(ctx)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	currentTime := time.Now()
+	if err := s.revokeUserTokens(ctx, principal.UserID, currentTime); err != nil {
+		return httpError(err)
 	}
 
+	s.recordAuditEvent(ctx, audit.Event{
+		Type:      audit.EventTokenRevoked,
+		UserID:    principal.UserID,
+		Reason:    "logout_all",
+		CreatedAt: currentTime,
+	})
+
+	response := map[string]string{
+		"message": "Successfully Logged Out From All Sessions!",
+	}
 	return ctx.JSON(http.StatusOK, response)
 }
 
+// GetJWKS
+//
+//	@Summary		GetJWKS
+//	@Description	Public keys used to verify the tokens issued by this service
+//	@ID				GetJWKS
+//	@Produce		json
+//	@Success		200		{object}	utils.JWKSet
+//	@Router			/.well-known/jwks.json [get]
+func (s *Server) GetJWKS(ctx echo.Context) error {
+	ctx.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return ctx.JSON(http.StatusOK, s.KeyRing.JWKS())
+}
+
+// normalizePhoneNumber converts a phone number to E.164. Numbers typed without
+// a country calling code are read as numbers of PhoneRegion, e.g. "ID", and
+// rejected when it is empty.
+func (s *Server) normalizePhoneNumber(phoneNumber string) (string, error) {
+	return phone.Normalize(phoneNumber, s.PhoneRegion)
+}
+
+// validatePassword checks password against the configured PasswordPolicy and
+// reports every broken rule in the "errors" field of the 400 response.
+func (s *Server) validatePassword(password string, personalInfo ...string) error {
+	violations := s.PasswordPolicy.Validate(password, personalInfo...)
+	if len(violations) == 0 {
+		return nil
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, map[string]interface{}{
+		"message": "Password Does Not Meet The Password Policy",
+		"errors":  violations,
+	})
+}
+
+// loginScopes returns the scopes the tokens of user are restricted to: none
+// once the phone number is verified.
+func (s *Server) loginScopes(user *repository.User) []string {
+	if user.PhoneVerifiedAt == nil {
+		return []string{utils.ScopePhoneVerify}
+	}
+	return nil
+}
+
+// startTokenFamily opens a new token family, i.e. a new session, for the user
+// on the device making the request and issues its first token pair, with the
+// role of the user and restricted to their loginScopes.
+func (s *Server) startTokenFamily(ctx echo.Context, user *repository.User, deviceName *string, createdAt time.Time) (*utils.TokenPair, error) {
+	family := repository.TokenFamily{
+		FamilyID:   uuid.New().String(),
+		UserID:     user.UserID,
+		UserAgent:  truncate(ctx.Request().UserAgent(), maxUserAgentLength),
+		IPAddress:  ctx.RealIP(),
+		LastSeenAt: createdAt,
+		CreatedAt:  createdAt,
+	}
+	if deviceName != nil {
+		family.DeviceName = truncate(strings.TrimSpace(*deviceName), maxDeviceNameLength)
+	}
+	if err := s.Repository.CreateTokenFamily(ctx.Request().Context(), family); err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(ctx.Request().Context(), user.UserID, family.FamilyID, user.Role, s.loginScopes(user)...)
+}
+
+// Longest device name and user agent kept for a session, in characters.
+const (
+	maxDeviceNameLength = 100
+	maxUserAgentLength  = 512
+)
+
+func truncate(value string, maxLength int) string {
+	runes := []rune(value)
+	if len(runes) > maxLength {
+		return string(runes[:maxLength])
+	}
+	return value
+}
+
+// recordAuditEvent adds event to the audit log with the address and user agent
+// of the request, and the authenticated user as actor. Losing an event isn't
+// worth failing the request over, so errors are only logged.
+func (s *Server) recordAuditEvent(ctx echo.Context, event audit.Event) {
+	if s.AuditLogger == nil {
+		return
+	}
+	if principal, ok := middleware.GetPrincipal(ctx); ok {
+		event.ActorID = principal.UserID
+	}
+	event.IPAddress = ctx.RealIP()
+	event.UserAgent = truncate(ctx.Request().UserAgent(), maxUserAgentLength)
+	// The event happened even if the client has gone since
+	if err := s.AuditLogger.Log(context.WithoutCancel(ctx.Request().Context()), event); err != nil {
+		ctx.Logger().Error(err)
+	}
+}
+
+// issueTokens signs a new token pair carrying role, restricted to scopes if
+// any, and records the refresh token under the given token family.
+func (s *Server) issueTokens(ctx context.Context, userID, familyID, role string, scopes ...string) (*utils.TokenPair, error) {
+	tokens, err := utils.GenerateTokenPair(userID, familyID, role, s.KeyRing, scopes...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Repository.CreateRefreshToken(ctx, repository.RefreshToken{
+		TokenID:   tokens.RefreshTokenID,
+		FamilyID:  familyID,
+		UserID:    userID,
+		ExpiresAt: tokens.RefreshExpiresAt,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
 // GetProfile
 //
 //	@Summary		GetProfile
@@ -151,23 +638,24 @@ func (s *Server) LoginUser(ctx echo.Context) error {
 //	@Success		200		{string}	string			"ok"
 //	@Router			/user [get]
 func (s *Server) GetProfile(ctx echo.Context) error {
-	getAuth := ctx.Request().Header.Get("Authorization")
-	tokenString := utils.GetTokenFromAuthHeader(getAuth)
-	res, err := utils.DecodeJWTToken(tokenString)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	principal, ok := middleware.GetPrincipal(ctx)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
 	}
-	getUser, err := s.Repository.GetUserByUserId(context.Background(), *res)
+	getUser, err := s.Repository.GetUserByUserId(ctx.Request().Context(), principal.UserID)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		return httpError(err)
 	}
 
 	dataResponse := map[string]map[string]string{
 		"data": {
-			"full_name":    strings.TrimRight(getUser.FullName, " "),
-			"phone_number": strings.TrimSpace(getUser.PhoneNumber),
+			"full_name":    getUser.FullName,
+			"phone_number": getUser.PhoneNumber,
 		},
 	}
+	if getUser.PendingPhoneNumber != nil {
+		dataResponse["data"]["pending_phone_number"] = *getUser.PendingPhoneNumber
+	}
 	return ctx.JSON(http.StatusOK, dataResponse)
 }
 
@@ -183,16 +671,14 @@ func (s *Server) GetProfile(ctx echo.Context) error {
 //	@Success		200		{string}	string			"ok"
 //	@Router			/user [put]
 func (s *Server) UpdateProfile(ctx echo.Context) error {
-	getAuth := ctx.Request().Header.Get("Authorization")
-	tokenString := utils.GetTokenFromAuthHeader(getAuth)
-	res, err := utils.DecodeJWTToken(tokenString)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	principal, ok := middleware.GetPrincipal(ctx)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
 	}
 
 	body, err := io.ReadAll(ctx.Request().Body)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Request Body").SetInternal(err)
 	}
 
 	var updateProfile generated.UpdateProfileJSONRequestBody
@@ -206,32 +692,1756 @@ func (s *Server) UpdateProfile(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Phone Number Cannot Be Empty")
 	}
 
-	if !utils.CheckPhoneNumber(updateProfile.PhoneNumber) {
+	phoneNumber, err := s.normalizePhoneNumber(updateProfile.PhoneNumber)
+	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Phone Number Format is not Valid")
 	}
 
-	getUser, err := s.Repository.GetUserByUserId(context.Background(), *res)
+	getUser, err := s.Repository.GetUserByUserId(ctx.Request().Context(), principal.UserID)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		return httpError(err)
 	}
 
-	checkUser, err := s.Repository.CheckPhoneNumber(context.Background(), updateProfile.PhoneNumber)
+	// A new number is only claimed once it is verified, where the unique
+	// constraint on phone_number has the last word. Checking here just saves
+	// the user from verifying a number they can't have.
+	checkUser, err := s.Repository.CheckPhoneNumber(ctx.Request().Context(), phoneNumber)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		return httpError(err)
+	}
+
+	if checkUser != 0 && phoneNumber != getUser.PhoneNumber {
+		return echo.NewHTTPError(http.StatusConflict, "Phone number already existed")
 	}
+	before := profileFields(getUser)
 
-	if checkUser != 0 && strings.TrimSpace(updateProfile.PhoneNumber) != strings.TrimSpace(getUser.PhoneNumber) {
-		return echo.NewHTTPError(http.StatusConflict, errors.New("Phone number already existed"))
+	// A new phone number only replaces the current one once it is verified
+	// through ConfirmPhoneVerification.
+	message := "User Profile Successfully Updated!"
+	getUser.PendingPhoneNumber = nil
+	if phoneNumber != getUser.PhoneNumber {
+		getUser.PendingPhoneNumber = &phoneNumber
+		message = "User Profile Successfully Updated! Please Verify Your New Phone Number"
 	}
-	getUser.PhoneNumber = updateProfile.PhoneNumber
 	getUser.FullName = updateProfile.FullName
-	err = s.Repository.UpdateUserProfile(context.Background(), *getUser)
+	err = s.Repository.UpdateUserProfile(ctx.Request().Context(), *getUser)
+	if repository.IsConflictOn(err, repository.ConstraintUsersPhoneNumber) {
+		return echo.NewHTTPError(http.StatusConflict, "Phone number already existed")
+	}
+	if err != nil {
+		return httpError(err)
+	}
+
+	s.recordAuditEvent(ctx, audit.Event{
+		Type:      audit.EventProfileUpdated,
+		UserID:    getUser.UserID,
+		Changes:   audit.Diff(before, profileFields(getUser)),
+		CreatedAt: time.Now(),
+	})
+
+	response := map[string]string{
+		"message": message,
+	}
+	return ctx.JSON(http.StatusAccepted, response)
+}
+
+// profileFields returns the fields of user that UpdateProfile can change, to
+// diff them for the audit log.
+func profileFields(user *repository.User) map[string]interface{} {
+	fields := map[string]interface{}{
+		"full_name":            user.FullName,
+		"pending_phone_number": nil,
+	}
+	if user.PendingPhoneNumber != nil {
+		fields["pending_phone_number"] = *user.PendingPhoneNumber
+	}
+	return fields
+}
+
+// ChangePassword
+//
+//	@Summary		ChangePassword
+//	@Description	Change the password of the user and log out every other session
+//	@ID				ChangePassword
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Param			user	body	generated.ChangePasswordJSONRequestBody	true	"Change Password JSON Body"
+//	@Success		200		{string}	string			"ok"
+//	@Router			/user/password [put]
+func (s *Server) ChangePassword(ctx echo.Context) error {
+	principal, ok := middleware.GetPrincipal(ctx)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	body, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Request Body").SetInternal(err)
+	}
+
+	var changePassword generated.ChangePasswordJSONRequestBody
+	json.Unmarshal(body, &changePassword)
+
+	if changePassword.CurrentPassword == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Current Password Cannot Be Empty")
+	}
+
+	if changePassword.NewPassword == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "New Password Cannot Be Empty")
+	}
+
+	getUser, err := s.Repository.GetUserByUserId(ctx.Request().Context(), principal.UserID)
+	if err != nil {
+		return httpError(err)
+	}
+
+	// Guesses at the current password count towards the login lockout, so a
+	// stolen access token can't be used to find it out
+	currentTime := time.Now()
+	if err := s.reserveLoginAttempt(ctx, getUser, currentTime); err != nil {
+		return err
+	}
+
+	if err := s.passwordHasher().Verify(changePassword.CurrentPassword, getUser.Password); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Current Password")
+	}
+
+	if err := s.Repository.UnlockUser(ctx.Request().Context(), getUser.UserID); err != nil {
+		return httpError(err)
+	}
+
+	if err := s.validatePassword(changePassword.NewPassword, getUser.PhoneNumber, getUser.FullName); err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.passwordHasher().Hash(changePassword.NewPassword)
+	if err != nil {
+		return httpError(err)
+	}
+
+	if err := s.Repository.UpdatePassword(ctx.Request().Context(), getUser.UserID, hashedPassword, currentTime); err != nil {
+		return httpError(err)
+	}
+
+	// Every session, this one included, is revoked and the caller gets a fresh
+	// pair
+	if err := s.revokeUserTokens(ctx, getUser.UserID, currentTime); err != nil {
+		return httpError(err)
+	}
+
+	s.recordAuditEvent(ctx, audit.Event{
+		Type:      audit.EventPasswordChanged,
+		UserID:    getUser.UserID,
+		Reason:    "change",
+		CreatedAt: currentTime,
+	})
+
+	tokens, err := s.startTokenFamily(ctx, getUser, nil, currentTime)
+	if err != nil {
+		return httpError(err)
+	}
+
+	response := map[string]string{
+		"message":       "Password Successfully Changed!",
+		"access_token":  tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// ForgotPassword
+//
+//	@Summary		ForgotPassword
+//	@Description	Send a one-time password reset code to the phone number
+//	@ID				ForgotPassword
+//	@Accept			application/json
+//	@Produce		json
+//	@Param			user	body	generated.ForgotPasswordJSONRequestBody	true	"Forgot Password JSON Body"
+//	@Success		202		{string}	string			"ok"
+//	@Router			/password/forgot [post]
+func (s *Server) ForgotPassword(ctx echo.Context) error {
+	body, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Request Body").SetInternal(err)
+	}
+
+	var forgotPassword generated.ForgotPasswordJSONRequestBody
+	json.Unmarshal(body, &forgotPassword)
+
+	if forgotPassword.PhoneNumber == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Phone Number Cannot Be Empty")
+	}
+
+	phoneNumber, err := s.normalizePhoneNumber(forgotPassword.PhoneNumber)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, "Phone Number Format is not Valid")
 	}
 
+	// The answer is the same whether or not the number is registered, so the
+	// endpoint can't be used to find out who has an account.
 	response := map[string]string{
-		"message": "User Profile Successfully Updated!",
+		"message": "If The Phone Number Is Registered, A Reset Code Has Been Sent",
+	}
+
+	getUser, err := s.Repository.CheckUser(ctx.Request().Context(), phoneNumber)
+	registered := err == nil
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return httpError(err)
+	}
+
+	// An unknown number gets a code made and hashed too, so it costs the same
+	// work up to storing the code
+	code, err := utils.GenerateNumericCode(6)
+	if err != nil {
+		return httpError(err)
+	}
+	codeHash := s.codeHasher().Hash(code)
+	if !registered {
+		return ctx.JSON(http.StatusAccepted, response)
+	}
+
+	// From here on a failure would only ever be seen for registered numbers,
+	// so it is logged rather than answered
+	currentTime := time.Now()
+	passwordReset := s.PasswordReset.withDefaults()
+	err = s.Repository.CreatePasswordResetCode(ctx.Request().Context(), repository.PasswordResetCode{
+		UserID:      getUser.UserID,
+		PhoneNumber: getUser.PhoneNumber,
+		CodeHash:    codeHash,
+		ExpiresAt:   currentTime.Add(passwordReset.CodeTTL),
+		CreatedAt:   currentTime,
+	})
+	if err != nil {
+		ctx.Logger().Error(err)
+		return ctx.JSON(http.StatusAccepted, response)
+	}
+
+	err = s.Notifier.Send(ctx.Request().Context(), notifier.Message{
+		PhoneNumber: getUser.PhoneNumber,
+		Template:    notifier.TemplatePasswordReset,
+		Data:        map[string]any{"Code": code, "Minutes": int(passwordReset.CodeTTL.Minutes())},
+	})
+	if err != nil {
+		ctx.Logger().Error(err)
 	}
+
 	return ctx.JSON(http.StatusAccepted, response)
 }
+
+// ResetPassword
+//
+//	@Summary		ResetPassword
+//	@Description	Set a new password with a reset code sent by ForgotPassword
+//	@ID				ResetPassword
+//	@Accept			application/json
+//	@Produce		json
+//	@Param			user	body	generated.ResetPasswordJSONRequestBody	true	"Reset Password JSON Body"
+//	@Success		200		{string}	string			"ok"
+//	@Router			/password/reset [post]
+func (s *Server) ResetPassword(ctx echo.Context) error {
+	body, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Request Body").SetInternal(err)
+	}
+
+	var resetPassword generated.ResetPasswordJSONRequestBody
+	json.Unmarshal(body, &resetPassword)
+
+	if resetPassword.PhoneNumber == "" || resetPassword.Code == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Please input your phone number and reset code")
+	}
+
+	if resetPassword.NewPassword == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "New Password Cannot Be Empty")
+	}
+
+	phoneNumber, err := s.normalizePhoneNumber(resetPassword.PhoneNumber)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Phone Number Format is not Valid")
+	}
+
+	invalidCode := echo.NewHTTPError(http.StatusBadRequest, "Invalid Or Expired Reset Code")
+
+	getUser, err := s.Repository.CheckUser(ctx.Request().Context(), phoneNumber)
+	if errors.Is(err, repository.ErrNotFound) {
+		return invalidCode
+	}
+	if err != nil {
+		return httpError(err)
+	}
+
+	currentTime := time.Now()
+	resetCode, err := s.Repository.GetActivePasswordResetCode(ctx.Request().Context(), getUser.UserID,
+		phoneNumber, currentTime)
+	if errors.Is(err, repository.ErrNotFound) {
+		return invalidCode
+	}
+	if err != nil {
+		return httpError(err)
+	}
+
+	allowed, err := s.Repository.ReservePasswordResetAttempt(ctx.Request().Context(), resetCode.ID,
+		s.PasswordReset.withDefaults().MaxAttempts)
+	if err != nil {
+		return httpError(err)
+	}
+	if !allowed {
+		return invalidCode
+	}
+
+	if !s.codeHasher().Verify(resetPassword.Code, resetCode.CodeHash) {
+		return invalidCode
+	}
+
+	// Only checked once the code is, so the answer to anyone without a code
+	// doesn't tell whether the number is registered. A rejected password
+	// leaves the code usable for another try.
+	if err := s.validatePassword(resetPassword.NewPassword, getUser.PhoneNumber, getUser.FullName); err != nil {
+		return err
+	}
+
+	used, err := s.Repository.UsePasswordResetCode(ctx.Request().Context(), resetCode.ID, currentTime)
+	if err != nil {
+		return httpError(err)
+	}
+	if !used {
+		return invalidCode
+	}
+
+	hashedPassword, err := s.passwordHasher().Hash(resetPassword.NewPassword)
+	if err != nil {
+		return httpError(err)
+	}
+
+	if err := s.Repository.UpdatePassword(ctx.Request().Context(), getUser.UserID, hashedPassword, currentTime); err != nil {
+		return httpError(err)
+	}
+
+	// Whoever knew the old password is logged out, and the owner who proved
+	// control of the phone number is no longer locked out.
+	if err := s.revokeUserTokens(ctx, getUser.UserID, currentTime); err != nil {
+		return httpError(err)
+	}
+	if err := s.Repository.UnlockUser(ctx.Request().Context(), getUser.UserID); err != nil {
+		return httpError(err)
+	}
+
+	s.recordAuditEvent(ctx, audit.Event{
+		Type:      audit.EventPasswordChanged,
+		UserID:    getUser.UserID,
+		Reason:    "reset",
+		CreatedAt: currentTime,
+	})
+
+	response := map[string]string{
+		"message": "Password Successfully Reset!",
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// RequestPhoneVerification
+//
+//	@Summary		RequestPhoneVerification
+//	@Description	Send a one-time code to the phone number waiting for verification
+//	@ID				RequestPhoneVerification
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Param			user	body	generated.RequestPhoneVerificationJSONRequestBody	false	"Request Phone Verification JSON Body"
+//	@Success		202		{string}	string			"ok"
+//	@Router			/phone/verify/request [post]
+func (s *Server) RequestPhoneVerification(ctx echo.Context) error {
+	body, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Request Body").SetInternal(err)
+	}
+
+	var verifyRequest generated.RequestPhoneVerificationJSONRequestBody
+	json.Unmarshal(body, &verifyRequest)
+
+	// Like ForgotPassword, an anonymous caller gets the same answer whether or
+	// not the number belongs to an unverified account.
+	response := map[string]string{
+		"message": "If The Phone Number Needs Verification, A Code Has Been Sent",
+	}
+
+	// A failure an anonymous caller would only ever see for an unverified
+	// account is logged rather than answered
+	principal, authenticated := middleware.GetPrincipal(ctx)
+	fail := func(err error) error {
+		if authenticated {
+			return httpError(err)
+		}
+		ctx.Logger().Error(err)
+		return ctx.JSON(http.StatusAccepted, response)
+	}
+
+	var getUser *repository.User
+	var phoneNumber string
+	if authenticated {
+		getUser, err = s.Repository.GetUserByUserId(ctx.Request().Context(), principal.UserID)
+		if err != nil {
+			return httpError(err)
+		}
+		phoneNumber = phoneNumberToVerify(getUser)
+		if phoneNumber == "" {
+			return echo.NewHTTPError(http.StatusConflict, "Phone Number Is Already Verified")
+		}
+	} else {
+		if verifyRequest.PhoneNumber == nil || *verifyRequest.PhoneNumber == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "Phone Number Cannot Be Empty")
+		}
+
+		phoneNumber, err = s.normalizePhoneNumber(*verifyRequest.PhoneNumber)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Phone Number Format is not Valid")
+		}
+
+		getUser, err = s.Repository.CheckUser(ctx.Request().Context(), phoneNumber)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return httpError(err)
+		}
+		if err != nil || getUser.PhoneVerifiedAt != nil {
+			getUser = nil
+		}
+	}
+
+	// A number with nothing to verify gets a code made and hashed too, so it
+	// costs the same work up to storing the code
+	code, err := utils.GenerateNumericCode(6)
+	if err != nil {
+		return httpError(err)
+	}
+	codeHash := s.codeHasher().Hash(code)
+	if getUser == nil {
+		return ctx.JSON(http.StatusAccepted, response)
+	}
+
+	currentTime := time.Now()
+	verification := s.PhoneVerification.withDefaults()
+	err = s.Repository.CreatePhoneVerificationCode(ctx.Request().Context(), repository.PhoneVerificationCode{
+		UserID:      getUser.UserID,
+		PhoneNumber: phoneNumber,
+		CodeHash:    codeHash,
+		ExpiresAt:   currentTime.Add(verification.CodeTTL),
+		CreatedAt:   currentTime,
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	err = s.Notifier.Send(ctx.Request().Context(), notifier.Message{
+		PhoneNumber: phoneNumber,
+		Template:    notifier.TemplatePhoneVerification,
+		Data:        map[string]any{"Code": code, "Minutes": int(verification.CodeTTL.Minutes())},
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	return ctx.JSON(http.StatusAccepted, response)
+}
+
+// ConfirmPhoneVerification
+//
+//	@Summary		ConfirmPhoneVerification
+//	@Description	Verify the phone number with the code sent by RequestPhoneVerification
+//	@ID				ConfirmPhoneVerification
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Param			user	body	generated.ConfirmPhoneVerificationJSONRequestBody	true	"Confirm Phone Verification JSON Body"
+//	@Success		200		{string}	string			"ok"
+//	@Router			/phone/verify/confirm [post]
+func (s *Server) ConfirmPhoneVerification(ctx echo.Context) error {
+	body, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Request Body").SetInternal(err)
+	}
+
+	var confirmRequest generated.ConfirmPhoneVerificationJSONRequestBody
+	json.Unmarshal(body, &confirmRequest)
+
+	if confirmRequest.Code == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Verification Code Cannot Be Empty")
+	}
+
+	invalidCode := echo.NewHTTPError(http.StatusBadRequest, "Invalid Or Expired Verification Code")
+
+	var getUser *repository.User
+	var phoneNumber string
+	if principal, ok := middleware.GetPrincipal(ctx); ok {
+		getUser, err = s.Repository.GetUserByUserId(ctx.Request().Context(), principal.UserID)
+		if err != nil {
+			return httpError(err)
+		}
+		phoneNumber = phoneNumberToVerify(getUser)
+		if phoneNumber == "" {
+			return echo.NewHTTPError(http.StatusConflict, "Phone Number Is Already Verified")
+		}
+	} else {
+		if confirmRequest.PhoneNumber == nil || *confirmRequest.PhoneNumber == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "Phone Number Cannot Be Empty")
+		}
+
+		phoneNumber, err = s.normalizePhoneNumber(*confirmRequest.PhoneNumber)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Phone Number Format is not Valid")
+		}
+
+		getUser, err = s.Repository.CheckUser(ctx.Request().Context(), phoneNumber)
+		if errors.Is(err, repository.ErrNotFound) {
+			return invalidCode
+		}
+		if err != nil {
+			return httpError(err)
+		}
+		if getUser.PhoneVerifiedAt != nil {
+			return invalidCode
+		}
+	}
+
+	currentTime := time.Now()
+	verificationCode, err := s.Repository.GetActivePhoneVerificationCode(ctx.Request().Context(), getUser.UserID,
+		phoneNumber, currentTime)
+	if errors.Is(err, repository.ErrNotFound) {
+		return invalidCode
+	}
+	if err != nil {
+		return httpError(err)
+	}
+
+	allowed, err := s.Repository.ReservePhoneVerificationAttempt(ctx.Request().Context(), verificationCode.ID,
+		s.PhoneVerification.withDefaults().MaxAttempts)
+	if err != nil {
+		return httpError(err)
+	}
+	if !allowed {
+		return invalidCode
+	}
+
+	if !s.codeHasher().Verify(confirmRequest.Code, verificationCode.CodeHash) {
+		return invalidCode
+	}
+
+	// A pending number may have been registered by someone else since the
+	// code was sent.
+	if phoneNumber != getUser.PhoneNumber {
+		checkUser, err := s.Repository.CheckPhoneNumber(ctx.Request().Context(), phoneNumber)
+		if err != nil {
+			return httpError(err)
+		}
+		if checkUser != 0 {
+			return echo.NewHTTPError(http.StatusConflict, "Phone number already existed")
+		}
+	}
+
+	used, err := s.Repository.UsePhoneVerificationCode(ctx.Request().Context(), verificationCode.ID, currentTime)
+	if err != nil {
+		return httpError(err)
+	}
+	if !used {
+		return invalidCode
+	}
+
+	err = s.Repository.VerifyPhoneNumber(ctx.Request().Context(), getUser.UserID, phoneNumber, currentTime)
+	if repository.IsConflictOn(err, repository.ConstraintUsersPhoneNumber) {
+		return echo.NewHTTPError(http.StatusConflict, "Phone number already existed")
+	}
+	if err != nil {
+		return httpError(err)
+	}
+
+	// Verifying the registered number changes nothing but its status
+	if phoneNumber != getUser.PhoneNumber {
+		s.recordAuditEvent(ctx, audit.Event{
+			Type:   audit.EventPhoneChanged,
+			UserID: getUser.UserID,
+			Changes: map[string]audit.Change{
+				"phone_number": {From: getUser.PhoneNumber, To: phoneNumber},
+			},
+			CreatedAt: currentTime,
+		})
+	}
+
+	response := map[string]string{
+		"message": "Phone Number Successfully Verified!",
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// phoneNumberToVerify returns the number of user still waiting for
+// verification: a pending change, or the registered number if it was never
+// verified. It is empty when there is nothing to verify.
+func phoneNumberToVerify(user *repository.User) string {
+	if user.PendingPhoneNumber != nil {
+		return *user.PendingPhoneNumber
+	}
+	if user.PhoneVerifiedAt == nil {
+		return user.PhoneNumber
+	}
+	return ""
+}
+
+// LoginMFA
+//
+//	@Summary		LoginMFA
+//	@Description	Exchange the MFA token of LoginUser and a TOTP or recovery code for a token pair
+//	@ID				LoginMFA
+//	@Accept			application/json
+//	@Produce		json
+//	@Param			user	body	generated.LoginMFAJSONRequestBody	true	"Login MFA JSON Body"
+//	@Success		200		{string}	string			"ok"
+//	@Router			/login/mfa [post]
+func (s *Server) LoginMFA(ctx echo.Context) error {
+	body, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Request Body").SetInternal(err)
+	}
+
+	var loginMFA generated.LoginMFAJSONRequestBody
+	json.Unmarshal(body, &loginMFA)
+
+	if loginMFA.MfaToken == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "MFA Token Cannot Be Empty")
+	}
+
+	var code, recoveryCode string
+	if loginMFA.Code != nil {
+		code = strings.TrimSpace(*loginMFA.Code)
+	}
+	if loginMFA.RecoveryCode != nil {
+		recoveryCode = strings.TrimSpace(*loginMFA.RecoveryCode)
+	}
+	if code == "" && recoveryCode == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Please Input A Code Or A Recovery Code")
+	}
+
+	claims, err := utils.ValidateJWTToken(loginMFA.MfaToken, s.KeyRing, utils.JWTIssuer(), utils.JWTAudience())
+	if err != nil || claims.TokenType != utils.MFATokenType {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid MFA Token")
+	}
+
+	revoked, err := s.Repository.IsTokenRevoked(ctx.Request().Context(), claims.ID)
+	if err != nil {
+		return httpError(err)
+	}
+	if revoked {
+		return echo.NewHTTPError(http.StatusUnauthorized, "MFA Token Has Already Been Used")
+	}
+
+	getUser, err := s.Repository.GetUserByUserId(ctx.Request().Context(), claims.UserID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return httpError(err)
+	}
+	if err != nil || getUser.TOTPEnabledAt == nil || getUser.TOTPSecret == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid MFA Token")
+	}
+
+	// The account may have been disabled since the password was checked
+	if getUser.DisabledAt != nil {
+		return echo.NewHTTPError(http.StatusForbidden, "Account Is Disabled")
+	}
+
+	currentTime := time.Now()
+	if err := s.reserveLoginAttempt(ctx, getUser, currentTime); err != nil {
+		return err
+	}
+
+	valid, err := s.verifySecondFactor(ctx.Request().Context(), getUser, code, recoveryCode, currentTime)
+	if err != nil {
+		return httpError(err)
+	}
+	if !valid {
+		s.recordLoginFailed(ctx, getUser.UserID, "invalid_mfa_code", currentTime)
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Two-Factor Code")
+	}
+
+	err = s.Repository.RevokeToken(ctx.Request().Context(), repository.RevokedToken{
+		TokenID:   claims.ID,
+		UserID:    claims.UserID,
+		ExpiresAt: claims.ExpiresAt.Time,
+		RevokedAt: currentTime,
+	})
+	if err != nil {
+		return httpError(err)
+	}
+
+	if err := s.Repository.RecordLoginSuccess(ctx.Request().Context(), getUser.ID, currentTime); err != nil {
+		return httpError(err)
+	}
+
+	tokens, err := s.startTokenFamily(ctx, getUser, loginMFA.DeviceName, currentTime)
+	if err != nil {
+		return httpError(err)
+	}
+
+	reason := "totp"
+	if code == "" {
+		reason = "recovery_code"
+	}
+	s.recordAuditEvent(ctx, audit.Event{
+		Type:      audit.EventLoginSucceeded,
+		UserID:    getUser.UserID,
+		Reason:    reason,
+		CreatedAt: currentTime,
+	})
+
+	response := map[string]string{
+		"access_token":  tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// verifySecondFactor checks the TOTP code, or the recovery code when code is
+// empty, and uses it up so it can't log in again.
+func (s *Server) verifySecondFactor(ctx context.Context, user *repository.User, code, recoveryCode string, currentTime time.Time) (bool, error) {
+	if code == "" {
+		return s.Repository.UseRecoveryCode(ctx, user.UserID,
+			utils.HashRecoveryCode(recoveryCode), currentTime)
+	}
+
+	if s.SecretBox == nil {
+		return false, errors.New("two-factor authentication is not configured")
+	}
+	secret, err := s.SecretBox.Open(*user.TOTPSecret, user.UserID)
+	if err != nil {
+		return false, err
+	}
+	step, err := utils.VerifyTOTP(secret, code, currentTime)
+	if errors.Is(err, utils.ErrInvalidTOTPCode) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return s.Repository.UseTOTPStep(ctx, user.UserID, step)
+}
+
+// EnrollTOTP
+//
+//	@Summary		EnrollTOTP
+//	@Description	Start enrolling an authenticator app, returning its secret and otpauth:// URI
+//	@ID				EnrollTOTP
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Success		200		{string}	string			"ok"
+//	@Router			/user/mfa/totp [post]
+func (s *Server) EnrollTOTP(ctx echo.Context) error {
+	principal, ok := middleware.GetPrincipal(ctx)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if s.SecretBox == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "Two-Factor Authentication Is Not Available")
+	}
+
+	getUser, err := s.Repository.GetUserByUserId(ctx.Request().Context(), principal.UserID)
+	if err != nil {
+		return httpError(err)
+	}
+
+	if getUser.TOTPEnabledAt != nil {
+		return echo.NewHTTPError(http.StatusConflict, "Two-Factor Authentication Is Already Enabled")
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return httpError(err)
+	}
+	sealedSecret, err := s.SecretBox.Seal(secret, getUser.UserID)
+	if err != nil {
+		return httpError(err)
+	}
+
+	err = s.Repository.SetTOTPSecret(ctx.Request().Context(), getUser.UserID, sealedSecret, time.Now())
+	if errors.Is(err, repository.ErrConflict) {
+		return echo.NewHTTPError(http.StatusConflict, "Two-Factor Authentication Is Already Enabled")
+	}
+	if err != nil {
+		return httpError(err)
+	}
+
+	response := map[string]string{
+		"secret":      secret,
+		"otpauth_uri": utils.TOTPURI(s.TOTP.withDefaults().Issuer, getUser.PhoneNumber, secret),
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// ConfirmTOTP
+//
+//	@Summary		ConfirmTOTP
+//	@Description	Enable two-factor authentication with a code from the app enrolled by EnrollTOTP
+//	@ID				ConfirmTOTP
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Param			user	body	generated.ConfirmTOTPJSONRequestBody	true	"Confirm TOTP JSON Body"
+//	@Success		200		{string}	string			"ok"
+//	@Router			/user/mfa/totp/confirm [post]
+func (s *Server) ConfirmTOTP(ctx echo.Context) error {
+	principal, ok := middleware.GetPrincipal(ctx)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	if s.SecretBox == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "Two-Factor Authentication Is Not Available")
+	}
+
+	body, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Request Body").SetInternal(err)
+	}
+
+	var confirmTOTP generated.ConfirmTOTPJSONRequestBody
+	json.Unmarshal(body, &confirmTOTP)
+
+	if strings.TrimSpace(confirmTOTP.Code) == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Code Cannot Be Empty")
+	}
+
+	getUser, err := s.Repository.GetUserByUserId(ctx.Request().Context(), principal.UserID)
+	if err != nil {
+		return httpError(err)
+	}
+
+	if getUser.TOTPEnabledAt != nil {
+		return echo.NewHTTPError(http.StatusConflict, "Two-Factor Authentication Is Already Enabled")
+	}
+	if getUser.TOTPSecret == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Two-Factor Authentication Enrollment Has Not Been Started")
+	}
+
+	secret, err := s.SecretBox.Open(*getUser.TOTPSecret, getUser.UserID)
+	if err != nil {
+		return httpError(err)
+	}
+	currentTime := time.Now()
+	step, err := utils.VerifyTOTP(secret, confirmTOTP.Code, currentTime)
+	if errors.Is(err, utils.ErrInvalidTOTPCode) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Two-Factor Code")
+	}
+	if err != nil {
+		return httpError(err)
+	}
+
+	recoveryCodes := make([]string, s.TOTP.withDefaults().RecoveryCodes)
+	recoveryCodeHashes := make([]string, len(recoveryCodes))
+	for i := range recoveryCodes {
+		recoveryCodes[i], err = utils.GenerateRecoveryCode()
+		if err != nil {
+			return httpError(err)
+		}
+		recoveryCodeHashes[i] = utils.HashRecoveryCode(recoveryCodes[i])
+	}
+
+	// The code just checked counts as used, so it can't also log in
+	err = s.Repository.EnableTOTP(ctx.Request().Context(), repository.EnableTOTPInput{
+		UserID:             getUser.UserID,
+		Step:               step,
+		RecoveryCodeHashes: recoveryCodeHashes,
+		EnabledAt:          currentTime,
+	})
+	if err != nil {
+		return httpError(err)
+	}
+
+	response := map[string]interface{}{
+		"message":        "Two-Factor Authentication Successfully Enabled!",
+		"recovery_codes": recoveryCodes,
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// GetSessions
+//
+//	@Summary		GetSessions
+//	@Description	List the sessions the user is logged in with
+//	@ID				GetSessions
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Success		200		{string}	string			"ok"
+//	@Router			/user/sessions [get]
+func (s *Server) GetSessions(ctx echo.Context) error {
+	principal, ok := middleware.GetPrincipal(ctx)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	// A session whose refresh token expired unused can't come back
+	activeSince := time.Now().Add(-utils.RefreshTokenTTL)
+	families, err := s.Repository.GetActiveTokenFamilies(ctx.Request().Context(), principal.UserID, activeSince)
+	if err != nil {
+		return httpError(err)
+	}
+
+	sessions := make([]generated.Session, 0, len(families))
+	for _, family := range families {
+		sessions = append(sessions, session(family, principal.FamilyID))
+	}
+
+	return ctx.JSON(http.StatusOK, generated.SessionsResponse{Sessions: sessions})
+}
+
+// session describes family as a session of the user, current when it is the
+// family of the request.
+func session(family repository.TokenFamily, currentFamilyID string) generated.Session {
+	return generated.Session{
+		Id:         family.FamilyID,
+		DeviceName: family.DeviceName,
+		UserAgent:  family.UserAgent,
+		IpAddress:  family.IPAddress,
+		CreatedAt:  family.CreatedAt,
+		LastSeenAt: family.LastSeenAt,
+		Current:    family.FamilyID == currentFamilyID,
+	}
+}
+
+// RevokeSession
+//
+//	@Summary		RevokeSession
+//	@Description	Log out a single session of the user
+//	@ID				RevokeSession
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Param			id	path	string	true	"Session ID"
+//	@Success		200		{string}	string			"ok"
+//	@Router			/user/sessions/{id} [delete]
+func (s *Server) RevokeSession(ctx echo.Context, id string) error {
+	principal, ok := middleware.GetPrincipal(ctx)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	currentTime := time.Now()
+	revoked, err := s.Repository.RevokeUserTokenFamily(ctx.Request().Context(), principal.UserID, id, currentTime)
+	if err != nil {
+		return httpError(err)
+	}
+	if !revoked {
+		return echo.NewHTTPError(http.StatusNotFound, "Session Not Found")
+	}
+
+	if s.RevocationCache != nil {
+		s.RevocationCache.TokenFamilyRevoked(id)
+	}
+
+	s.recordAuditEvent(ctx, audit.Event{
+		Type:      audit.EventTokenRevoked,
+		UserID:    principal.UserID,
+		Reason:    "session_revoked",
+		CreatedAt: currentTime,
+	})
+
+	response := map[string]string{
+		"message": "Session Successfully Revoked!",
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// Page sizes of GetActivity.
+const (
+	defaultActivityLimit = 50
+	maxActivityLimit     = 100
+)
+
+// GetActivity
+//
+//	@Summary		GetActivity
+//	@Description	List the security events of the user's account, newest first
+//	@ID				GetActivity
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Param			limit	query	int	false	"Most events to return"
+//	@Param			before	query	int	false	"Only return events older than this event ID"
+//	@Success		200		{string}	string			"ok"
+//	@Router			/user/activity [get]
+func (s *Server) GetActivity(ctx echo.Context, params generated.GetActivityParams) error {
+	principal, ok := middleware.GetPrincipal(ctx)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	limit := defaultActivityLimit
+	if params.Limit != nil {
+		if *params.Limit <= 0 || *params.Limit > maxActivityLimit {
+			return echo.NewHTTPError(http.StatusBadRequest, "Limit Must Be Between 1 And "+strconv.Itoa(maxActivityLimit))
+		}
+		limit = *params.Limit
+	}
+	var before int64
+	if params.Before != nil {
+		if *params.Before <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Before Must Be A Positive Event ID")
+		}
+		before = *params.Before
+	}
+
+	auditEvents, err := s.Repository.GetAuditEventsByUserId(ctx.Request().Context(), principal.UserID, before, limit)
+	if err != nil {
+		return httpError(err)
+	}
+
+	events, err := activityEvents(auditEvents)
+	if err != nil {
+		return httpError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, generated.ActivityResponse{Events: events})
+}
+
+func activityEvents(auditEvents []repository.AuditEvent) ([]generated.ActivityEvent, error) {
+	events := make([]generated.ActivityEvent, 0, len(auditEvents))
+	for _, auditEvent := range auditEvents {
+		event := generated.ActivityEvent{
+			Id:        auditEvent.ID,
+			Type:      auditEvent.EventType,
+			ActorId:   auditEvent.ActorID,
+			Reason:    auditEvent.Reason,
+			IpAddress: auditEvent.IPAddress,
+			UserAgent: auditEvent.UserAgent,
+			CreatedAt: auditEvent.CreatedAt,
+		}
+		if auditEvent.Changes != nil {
+			var changes map[string]generated.ActivityChange
+			if err := json.Unmarshal(auditEvent.Changes, &changes); err != nil {
+				return nil, err
+			}
+			event.Changes = &changes
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// DeleteAccount
+//
+//	@Summary		DeleteAccount
+//	@Description	Delete the account of the user and log out every session
+//	@ID				DeleteAccount
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Param			user	body	generated.DeleteAccountJSONRequestBody	true	"Delete Account JSON Body"
+//	@Success		200		{string}	string			"ok"
+//	@Router			/user [delete]
+func (s *Server) DeleteAccount(ctx echo.Context) error {
+	principal, ok := middleware.GetPrincipal(ctx)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	body, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Request Body").SetInternal(err)
+	}
+
+	var deleteAccount generated.DeleteAccountJSONRequestBody
+	json.Unmarshal(body, &deleteAccount)
+
+	if deleteAccount.Password == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Password Cannot Be Empty")
+	}
+
+	getUser, err := s.Repository.GetUserByUserId(ctx.Request().Context(), principal.UserID)
+	if err != nil {
+		return httpError(err)
+	}
+
+	// Like ChangePassword, guesses count towards the login lockout
+	currentTime := time.Now()
+	if err := s.reserveLoginAttempt(ctx, getUser, currentTime); err != nil {
+		return err
+	}
+
+	if err := s.passwordHasher().Verify(deleteAccount.Password, getUser.Password); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Password")
+	}
+
+	// The account keeps its phone number through the grace period, then the
+	// purge job removes it for good
+	validAfter := tokensValidAfter(currentTime)
+	err = s.Repository.DeleteUser(ctx.Request().Context(), repository.DeleteUserInput{
+		UserID:           getUser.UserID,
+		DeletedAt:        currentTime,
+		TokensValidAfter: validAfter,
+		PurgeAfter:       currentTime.Add(s.AccountDeletion.withDefaults().GracePeriod),
+	})
+	if err != nil {
+		return httpError(err)
+	}
+
+	if s.RevocationCache != nil {
+		s.RevocationCache.UserTokensRevoked(getUser.UserID, validAfter)
+	}
+
+	s.recordAuditEvent(ctx, audit.Event{
+		Type:      audit.EventAccountDeleted,
+		UserID:    getUser.UserID,
+		CreatedAt: currentTime,
+	})
+
+	response := map[string]string{
+		"message": "Account Successfully Deleted!",
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// ExportUserData
+//
+//	@Summary		ExportUserData
+//	@Description	Download the profile, sessions and activity log of the user
+//	@ID				ExportUserData
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Success		200		{string}	string			"ok"
+//	@Router			/user/export [get]
+func (s *Server) ExportUserData(ctx echo.Context) error {
+	principal, ok := middleware.GetPrincipal(ctx)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	getUser, err := s.Repository.GetUserByUserId(ctx.Request().Context(), principal.UserID)
+	if err != nil {
+		return httpError(err)
+	}
+
+	families, err := s.Repository.GetTokenFamiliesByUserId(ctx.Request().Context(), getUser.UserID)
+	if err != nil {
+		return httpError(err)
+	}
+	sessions := make([]generated.Session, 0, len(families))
+	for _, family := range families {
+		exported := session(family, principal.FamilyID)
+		exported.RevokedAt = family.RevokedAt
+		sessions = append(sessions, exported)
+	}
+
+	// The activity log is read a page at a time, like GetActivity does
+	var auditEvents []repository.AuditEvent
+	var before int64
+	for {
+		page, err := s.Repository.GetAuditEventsByUserId(ctx.Request().Context(), getUser.UserID, before, maxActivityLimit)
+		if err != nil {
+			return httpError(err)
+		}
+		auditEvents = append(auditEvents, page...)
+		if len(page) < maxActivityLimit {
+			break
+		}
+		before = page[len(page)-1].ID
+	}
+	events, err := activityEvents(auditEvents)
+	if err != nil {
+		return httpError(err)
+	}
+
+	export := generated.ExportResponse{
+		ExportedAt: time.Now(),
+		Profile: generated.ExportProfile{
+			UserId:             getUser.UserID,
+			FullName:           getUser.FullName,
+			PhoneNumber:        getUser.PhoneNumber,
+			PendingPhoneNumber: getUser.PendingPhoneNumber,
+			PhoneVerifiedAt:    getUser.PhoneVerifiedAt,
+			TwoFactorEnabled:   getUser.TOTPEnabledAt != nil,
+			LastLogin:          getUser.LastLogin,
+			CreatedAt:          getUser.CreatedAt,
+		},
+		Sessions: sessions,
+		Events:   events,
+	}
+
+	ctx.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="user-data.json"`)
+	return ctx.JSON(http.StatusOK, export)
+}
+
+// Page sizes of ListUsers and SearchUsers.
+const (
+	defaultUserListLimit = 50
+	maxUserListLimit     = 100
+)
+
+// userListLimit reads the limit query parameter of the user listings.
+func userListLimit(limit *int) (int, error) {
+	if limit == nil {
+		return defaultUserListLimit, nil
+	}
+	if *limit <= 0 || *limit > maxUserListLimit {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Limit Must Be Between 1 And "+strconv.Itoa(maxUserListLimit))
+	}
+	return *limit, nil
+}
+
+func adminUser(user repository.User) generated.AdminUser {
+	return generated.AdminUser{
+		UserId:              user.UserID,
+		FullName:            user.FullName,
+		PhoneNumber:         user.PhoneNumber,
+		PendingPhoneNumber:  user.PendingPhoneNumber,
+		Role:                generated.AdminUserRole(user.Role),
+		PhoneVerifiedAt:     user.PhoneVerifiedAt,
+		TwoFactorEnabled:    user.TOTPEnabledAt != nil,
+		FailedLoginAttempts: user.FailedLoginAttempts,
+		LockedUntil:         user.LockedUntil,
+		DisabledAt:          user.DisabledAt,
+		LastLogin:           user.LastLogin,
+		CreatedAt:           user.CreatedAt,
+	}
+}
+
+func adminUsers(users []repository.User) generated.AdminUsersResponse {
+	response := generated.AdminUsersResponse{Users: make([]generated.AdminUser, 0, len(users))}
+	for _, user := range users {
+		response.Users = append(response.Users, adminUser(user))
+	}
+	return response
+}
+
+// userListCursor is what the opaque cursors of ListUsers hold: the position
+// of a user and the ordering it is a position in.
+type userListCursor struct {
+	Sort       repository.UserSort `json:"s"`
+	Descending bool                `json:"d"`
+	Before     bool                `json:"b,omitempty"`
+	Value      string              `json:"v"`
+	ID         int                 `json:"i"`
+}
+
+func encodeUserListCursor(cursor userListCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeUserListCursor reads a cursor of ListUsers, refusing any that a
+// client edited into something the database can't compare.
+func decodeUserListCursor(encoded string) (userListCursor, error) {
+	var cursor userListCursor
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, err
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return cursor, err
+	}
+	switch cursor.Sort {
+	case repository.SortByFullName:
+	case repository.SortByLastLogin:
+		if cursor.Value == "-infinity" {
+			break
+		}
+		fallthrough
+	case repository.SortByCreatedAt:
+		if _, err := time.Parse(time.RFC3339Nano, cursor.Value); err != nil {
+			return cursor, err
+		}
+	default:
+		return cursor, errors.New("unknown sort")
+	}
+	return cursor, nil
+}
+
+// timeRange checks that a from and to query parameter make a range.
+func timeRange(from, to *time.Time, name string) error {
+	if from != nil && to != nil && !from.Before(*to) {
+		return echo.NewHTTPError(http.StatusBadRequest, name+" From Must Be Before "+name+" To")
+	}
+	return nil
+}
+
+// ListUsers
+//
+//	@Summary		ListUsers
+//	@Description	List and filter the users, newest first by default, for support staff
+//	@ID				ListUsers
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Param			limit			query	int		false	"Most users to return"
+//	@Param			cursor			query	string	false	"next_cursor or prev_cursor of the previous response"
+//	@Param			sort			query	string	false	"created_at, last_login or full_name"
+//	@Param			order			query	string	false	"asc or desc"
+//	@Param			created_from	query	string	false	"Only users created at or after this time"
+//	@Param			created_to		query	string	false	"Only users created before this time"
+//	@Param			last_login_from	query	string	false	"Only users who last logged in at or after this time"
+//	@Param			last_login_to	query	string	false	"Only users who last logged in before this time"
+//	@Param			name_prefix		query	string	false	"Only users whose name starts with this"
+//	@Param			phone_prefix	query	string	false	"Only users whose phone number starts with this"
+//	@Param			status			query	string	false	"active, disabled or locked"
+//	@Success		200		{string}	string			"ok"
+//	@Router			/admin/users [get]
+func (s *Server) ListUsers(ctx echo.Context, params generated.ListUsersParams) error {
+	if _, err := requireRole(ctx, utils.RoleSupport, utils.RoleAdmin); err != nil {
+		return err
+	}
+
+	limit, err := userListLimit(params.Limit)
+	if err != nil {
+		return err
+	}
+
+	input := repository.ListUsersInput{
+		Limit:         limit,
+		Sort:          repository.SortByCreatedAt,
+		CreatedFrom:   params.CreatedFrom,
+		CreatedTo:     params.CreatedTo,
+		LastLoginFrom: params.LastLoginFrom,
+		LastLoginTo:   params.LastLoginTo,
+		Now:           time.Now(),
+	}
+	if params.Sort != nil {
+		switch sort := repository.UserSort(*params.Sort); sort {
+		case repository.SortByCreatedAt, repository.SortByLastLogin, repository.SortByFullName:
+			input.Sort = sort
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, "Sort Must Be created_at, last_login Or full_name")
+		}
+	}
+	input.Descending = input.Sort != repository.SortByFullName
+	if params.Order != nil {
+		switch *params.Order {
+		case generated.Asc:
+			input.Descending = false
+		case generated.Desc:
+			input.Descending = true
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, "Order Must Be asc Or desc")
+		}
+	}
+
+	// The cursor keeps the ordering it was made for, so following it needs
+	// only the filters again
+	if params.Cursor != nil {
+		cursor, err := decodeUserListCursor(*params.Cursor)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid Cursor").SetInternal(err)
+		}
+		if (params.Sort != nil && cursor.Sort != input.Sort) || (params.Order != nil && cursor.Descending != input.Descending) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Cursor Does Not Match Sort And Order")
+		}
+		input.Sort, input.Descending = cursor.Sort, cursor.Descending
+		position := &repository.UserCursor{Value: cursor.Value, ID: cursor.ID}
+		if cursor.Before {
+			input.Before = position
+		} else {
+			input.After = position
+		}
+	}
+
+	if err := timeRange(params.CreatedFrom, params.CreatedTo, "Created"); err != nil {
+		return err
+	}
+	if err := timeRange(params.LastLoginFrom, params.LastLoginTo, "Last Login"); err != nil {
+		return err
+	}
+	if params.NamePrefix != nil {
+		input.NamePrefix = strings.TrimSpace(*params.NamePrefix)
+	}
+	if params.PhonePrefix != nil {
+		input.PhonePrefix = strings.TrimSpace(*params.PhonePrefix)
+	}
+	if params.Status != nil {
+		switch status := repository.UserStatus(*params.Status); status {
+		case repository.UserStatusActive, repository.UserStatusDisabled, repository.UserStatusLocked:
+			input.Status = status
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, "Status Must Be active, disabled Or locked")
+		}
+	}
+
+	page, err := s.Repository.ListUsers(ctx.Request().Context(), input)
+	if err != nil {
+		return httpError(err)
+	}
+
+	response := adminUsers(page.Users)
+	if len(page.Users) > 0 {
+		// Coming back from a later page there is always a next page, and
+		// HasMore tells about the earlier ones instead
+		hasNext, hasPrev := page.HasMore, input.After != nil
+		if input.Before != nil {
+			hasNext, hasPrev = true, page.HasMore
+		}
+		cursor := userListCursor{Sort: input.Sort, Descending: input.Descending}
+		if hasNext {
+			position := page.Users[len(page.Users)-1].Cursor(input.Sort)
+			cursor.Value, cursor.ID, cursor.Before = position.Value, position.ID, false
+			next := encodeUserListCursor(cursor)
+			response.NextCursor = &next
+		}
+		if hasPrev {
+			position := page.Users[0].Cursor(input.Sort)
+			cursor.Value, cursor.ID, cursor.Before = position.Value, position.ID, true
+			prev := encodeUserListCursor(cursor)
+			response.PrevCursor = &prev
+		}
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// SearchUsers
+//
+//	@Summary		SearchUsers
+//	@Description	Find users by name, even misspelled, or by the start of their phone number, best match first, for support staff
+//	@ID				SearchUsers
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Param			q		query	string	true	"A name, or the start of a phone number"
+//	@Param			limit	query	int		false	"Most users to return"
+//	@Success		200		{string}	string			"ok"
+//	@Router			/admin/users/search [get]
+func (s *Server) SearchUsers(ctx echo.Context, params generated.SearchUsersParams) error {
+	if _, err := requireRole(ctx, utils.RoleSupport, utils.RoleAdmin); err != nil {
+		return err
+	}
+
+	query := strings.TrimSpace(params.Q)
+	if query == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Query Cannot Be Empty")
+	}
+	limit, err := userListLimit(params.Limit)
+	if err != nil {
+		return err
+	}
+
+	input := repository.UserSearchInput{Query: query, Limit: limit}
+	if prefix, ok := phone.NormalizePrefix(query, s.PhoneRegion); ok {
+		input.PhonePrefix = prefix
+	}
+
+	matches, err := s.Repository.SearchUsers(ctx.Request().Context(), input)
+	if err != nil {
+		return httpError(err)
+	}
+
+	response := generated.UserSearchResponse{Results: make([]generated.UserSearchResult, 0, len(matches))}
+	for _, match := range matches {
+		result := generated.UserSearchResult{
+			User:       adminUser(match.User),
+			Rank:       match.Rank,
+			Highlights: []generated.Highlight{},
+		}
+		for _, span := range utils.HighlightMatches(match.FullName, query, repository.SearchSimilarityThreshold) {
+			result.Highlights = append(result.Highlights, generated.Highlight{Field: "full_name", Start: span.Start, End: span.End})
+		}
+		if input.PhonePrefix != "" && strings.HasPrefix(match.PhoneNumber, input.PhonePrefix) {
+			result.Highlights = append(result.Highlights, generated.Highlight{Field: "phone_number", Start: 0, End: len(input.PhonePrefix)})
+		}
+		response.Results = append(response.Results, result)
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// GetUser
+//
+//	@Summary		GetUser
+//	@Description	Look up a user, for support staff
+//	@ID				GetUser
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Param			id	path	string	true	"User ID"
+//	@Success		200		{string}	string			"ok"
+//	@Router			/admin/users/{id} [get]
+func (s *Server) GetUser(ctx echo.Context, id string) error {
+	if _, err := requireRole(ctx, utils.RoleSupport, utils.RoleAdmin); err != nil {
+		return err
+	}
+
+	user, err := s.Repository.GetUserByUserId(ctx.Request().Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "User Not Found")
+	}
+	if err != nil {
+		return httpError(err)
+	}
+	return ctx.JSON(http.StatusOK, adminUser(*user))
+}
+
+// requireRole returns the caller when they hold one of roles. The admin
+// endpoints check it themselves rather than trusting x-roles alone, so a route
+// missing from api.yml can't expose them.
+func requireRole(ctx echo.Context, roles ...string) (*middleware.Principal, error) {
+	principal, ok := middleware.GetPrincipal(ctx)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+	for _, role := range roles {
+		if principal.Role == role {
+			return principal, nil
+		}
+	}
+	return nil, echo.NewHTTPError(http.StatusForbidden, "Your Role Does Not Allow This Endpoint")
+}
+
+// managedUser loads the user with id for an endpoint that changes them.
+// Support staff only manage users without a role, so they can't lock out
+// each other or an administrator.
+func (s *Server) managedUser(ctx echo.Context, id string) (*repository.User, error) {
+	principal, err := requireRole(ctx, utils.RoleSupport, utils.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.Repository.GetUserByUserId(ctx.Request().Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "User Not Found")
+	}
+	if err != nil {
+		return nil, httpError(err)
+	}
+
+	if principal.Role != utils.RoleAdmin && user.Role != utils.RoleUser {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "Your Role Does Not Allow Managing This User")
+	}
+	return user, nil
+}
+
+// tokensValidAfter is the cut-off that revokes the tokens issued before at.
+// Token iat only has second precision, so it is rounded down to the second;
+// otherwise a token issued later in the same second, e.g. the fresh pair of
+// ChangePassword or the next login, would count as revoked too.
+func tokensValidAfter(at time.Time) time.Time {
+	return at.Truncate(time.Second)
+}
+
+// revokeUserTokens logs every session of the user out as of at, here and,
+// within REVOCATION_CACHE_TTL, on every other instance.
+func (s *Server) revokeUserTokens(ctx echo.Context, userID string, at time.Time) error {
+	validAfter := tokensValidAfter(at)
+	if err := s.Repository.RevokeAllUserTokens(ctx.Request().Context(), userID, validAfter); err != nil {
+		return err
+	}
+	if s.RevocationCache != nil {
+		s.RevocationCache.UserTokensRevoked(userID, validAfter)
+	}
+	return nil
+}
+
+// DisableUser
+//
+//	@Summary		DisableUser
+//	@Description	Log every session of a user out and keep them from logging in
+//	@ID				DisableUser
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Param			id	path	string	true	"User ID"
+//	@Success		200		{string}	string			"ok"
+//	@Router			/admin/users/{id}/disable [post]
+func (s *Server) DisableUser(ctx echo.Context, id string) error {
+	if principal, ok := middleware.GetPrincipal(ctx); ok && principal.UserID == id {
+		return echo.NewHTTPError(http.StatusConflict, "You Cannot Disable Yourself")
+	}
+
+	user, err := s.managedUser(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	currentTime := time.Now()
+	err = s.Repository.DisableUser(ctx.Request().Context(), user.UserID, currentTime)
+	if errors.Is(err, repository.ErrConflict) {
+		return echo.NewHTTPError(http.StatusConflict, "User Is Already Disabled")
+	}
+	if err != nil {
+		return httpError(err)
+	}
+
+	if err := s.revokeUserTokens(ctx, user.UserID, currentTime); err != nil {
+		return httpError(err)
+	}
+
+	s.recordAuditEvent(ctx, audit.Event{
+		Type:      audit.EventAccountDisabled,
+		UserID:    user.UserID,
+		CreatedAt: currentTime,
+	})
+
+	response := map[string]string{
+		"message": "User Successfully Disabled!",
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// EnableUser
+//
+//	@Summary		EnableUser
+//	@Description	Let a disabled user log in again
+//	@ID				EnableUser
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Param			id	path	string	true	"User ID"
+//	@Success		200		{string}	string			"ok"
+//	@Router			/admin/users/{id}/enable [post]
+func (s *Server) EnableUser(ctx echo.Context, id string) error {
+	user, err := s.managedUser(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	currentTime := time.Now()
+	err = s.Repository.EnableUser(ctx.Request().Context(), user.UserID, currentTime)
+	if errors.Is(err, repository.ErrConflict) {
+		return echo.NewHTTPError(http.StatusConflict, "User Is Not Disabled")
+	}
+	if err != nil {
+		return httpError(err)
+	}
+
+	s.recordAuditEvent(ctx, audit.Event{
+		Type:      audit.EventAccountEnabled,
+		UserID:    user.UserID,
+		CreatedAt: currentTime,
+	})
+
+	response := map[string]string{
+		"message": "User Successfully Enabled!",
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// UnlockUser
+//
+//	@Summary		UnlockUser
+//	@Description	Clear the failed login counter and lockout of a user
+//	@ID				UnlockUser
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Param			id	path	string	true	"User ID"
+//	@Success		200		{string}	string			"ok"
+//	@Router			/admin/users/{id}/unlock [post]
+func (s *Server) UnlockUser(ctx echo.Context, id string) error {
+	user, err := s.managedUser(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Repository.UnlockUser(ctx.Request().Context(), user.UserID); err != nil {
+		return httpError(err)
+	}
+
+	s.recordAuditEvent(ctx, audit.Event{
+		Type:      audit.EventAccountUnlocked,
+		UserID:    user.UserID,
+		CreatedAt: time.Now(),
+	})
+
+	response := map[string]string{
+		"message": "User Successfully Unlocked!",
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// LogoutUser
+//
+//	@Summary		LogoutUser
+//	@Description	Log every session of a user out
+//	@ID				LogoutUser
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Param			id	path	string	true	"User ID"
+//	@Success		200		{string}	string			"ok"
+//	@Router			/admin/users/{id}/logout [post]
+func (s *Server) LogoutUser(ctx echo.Context, id string) error {
+	user, err := s.managedUser(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	currentTime := time.Now()
+	if err := s.revokeUserTokens(ctx, user.UserID, currentTime); err != nil {
+		return httpError(err)
+	}
+
+	s.recordAuditEvent(ctx, audit.Event{
+		Type:      audit.EventTokenRevoked,
+		UserID:    user.UserID,
+		Reason:    "admin_logout",
+		CreatedAt: currentTime,
+	})
+
+	response := map[string]string{
+		"message": "User Successfully Logged Out!",
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// ChangeUserRole
+//
+//	@Summary		ChangeUserRole
+//	@Description	Change the role of a user and log every session of theirs out, for administrators
+//	@ID				ChangeUserRole
+//	@Accept			application/json
+//	@Security		ApiKeyAuth
+//	@Produce		json
+//	@Param			id		path	string									true	"User ID"
+//	@Param			role	body	generated.ChangeUserRoleJSONRequestBody	true	"Change User Role JSON Body"
+//	@Success		200		{string}	string			"ok"
+//	@Router			/admin/users/{id}/role [put]
+func (s *Server) ChangeUserRole(ctx echo.Context, id string) error {
+	if _, err := requireRole(ctx, utils.RoleAdmin); err != nil {
+		return err
+	}
+
+	body, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Request Body").SetInternal(err)
+	}
+
+	var changeUserRole generated.ChangeUserRoleJSONRequestBody
+	json.Unmarshal(body, &changeUserRole)
+
+	role := string(changeUserRole.Role)
+	if role != utils.RoleUser && role != utils.RoleSupport && role != utils.RoleAdmin {
+		return echo.NewHTTPError(http.StatusBadRequest, "Role Must Be user, support Or admin")
+	}
+
+	// An administrator demoting themselves could leave nobody to undo it
+	if principal, ok := middleware.GetPrincipal(ctx); ok && principal.UserID == id {
+		return echo.NewHTTPError(http.StatusConflict, "You Cannot Change Your Own Role")
+	}
+
+	user, err := s.managedUser(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	currentTime := time.Now()
+	if err := s.Repository.SetUserRole(ctx.Request().Context(), user.UserID, role, currentTime); err != nil {
+		return httpError(err)
+	}
+
+	// Tokens carry the role they were issued with, so the user logs in again
+	// to get the new one
+	if err := s.revokeUserTokens(ctx, user.UserID, currentTime); err != nil {
+		return httpError(err)
+	}
+
+	s.recordAuditEvent(ctx, audit.Event{
+		Type:      audit.EventRoleChanged,
+		UserID:    user.UserID,
+		Changes:   audit.Diff(map[string]interface{}{"role": user.Role}, map[string]interface{}{"role": role}),
+		CreatedAt: currentTime,
+	})
+
+	response := map[string]string{
+		"message": "User Role Successfully Changed!",
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
@@ -2,19 +2,48 @@ package handler
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SawitProRecruitment/UserService/audit"
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/middleware"
+	"github.com/SawitProRecruitment/UserService/migrations"
+	"github.com/SawitProRecruitment/UserService/notifier"
 	"github.com/SawitProRecruitment/UserService/repository"
 	"github.com/SawitProRecruitment/UserService/utils"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/golang/mock/gomock"
 	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
+func newTestKeyRing(t *testing.T) *utils.KeyRing {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	signingKey, err := utils.NewSigningKey("testKey", privateKey)
+	assert.NoError(t, err)
+	keys, err := utils.NewKeyRing("testKey", signingKey)
+	assert.NoError(t, err)
+	return keys
+}
+
 func TestRegisterTheUser(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -28,7 +57,6 @@ func TestRegisterTheUser(t *testing.T) {
 	tests := []struct {
 		name                string
 		requestBody         interface{}
-		mockOutput          int
 		expectedCode        int
 		expectedError       bool
 		isInputValidated    bool
@@ -41,7 +69,6 @@ func TestRegisterTheUser(t *testing.T) {
 				"phone_number": "+62234567890",
 				"Password":     "password",
 			},
-			mockOutput:          0,
 			expectedCode:        http.StatusCreated,
 			expectedError:       false,
 			isInputValidated:    true,
@@ -54,7 +81,6 @@ func TestRegisterTheUser(t *testing.T) {
 				"phone_number": "+62234567890",
 				"Password":     "password",
 			},
-			mockOutput:          0,
 			expectedCode:        http.StatusCreated,
 			expectedError:       true,
 			isInputValidated:    false,
@@ -67,7 +93,6 @@ func TestRegisterTheUser(t *testing.T) {
 				"phone_number": "",
 				"Password":     "password",
 			},
-			mockOutput:          0,
 			expectedCode:        http.StatusCreated,
 			expectedError:       true,
 			isInputValidated:    false,
@@ -80,7 +105,6 @@ func TestRegisterTheUser(t *testing.T) {
 				"phone_number": "+62234567890",
 				"Password":     "",
 			},
-			mockOutput:          0,
 			expectedCode:        http.StatusCreated,
 			expectedError:       true,
 			isInputValidated:    false,
@@ -93,7 +117,6 @@ func TestRegisterTheUser(t *testing.T) {
 				"phone_number": "2234567890",
 				"Password":     "password",
 			},
-			mockOutput:          0,
 			expectedCode:        http.StatusCreated,
 			expectedError:       true,
 			isInputValidated:    false,
@@ -106,7 +129,6 @@ func TestRegisterTheUser(t *testing.T) {
 				"phone_number": "+62234567890",
 				"Password":     "password",
 			},
-			mockOutput:          1,
 			expectedCode:        http.StatusCreated,
 			expectedError:       true,
 			isInputValidated:    true,
@@ -117,10 +139,12 @@ func TestRegisterTheUser(t *testing.T) {
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			if tc.isInputValidated {
-				mockRepository.EXPECT().CheckPhoneNumber(gomock.Any(), gomock.Any()).Return(int64(tc.mockOutput), nil)
-				if tc.isPhoneNumberUnique {
-					mockRepository.EXPECT().RegisterUser(gomock.Any()).Return(nil)
+				var mockError error
+				if !tc.isPhoneNumberUnique {
+					mockError = &repository.Error{Op: "RegisterUser", Kind: repository.ErrConflict,
+						Constraint: repository.ConstraintUsersPhoneNumber}
 				}
+				mockRepository.EXPECT().RegisterUser(gomock.Any(), gomock.Any()).Return(mockError)
 			}
 			reqBody, _ := json.Marshal(tc.requestBody)
 			req := httptest.NewRequest(http.MethodPost, "/register", bytes.NewReader(reqBody))
@@ -135,6 +159,11 @@ func TestRegisterTheUser(t *testing.T) {
 			if tc.expectedError {
 				assert.Error(t, err)
 				assert.NotEqual(t, tc.expectedCode, rec.Code)
+				if tc.isInputValidated {
+					httpErr, ok := err.(*echo.HTTPError)
+					assert.True(t, ok)
+					assert.Equal(t, http.StatusConflict, httpErr.Code)
+				}
 			} else {
 				assert.NoError(t, err)
 				assert.Equal(t, tc.expectedCode, rec.Code)
@@ -143,6 +172,240 @@ func TestRegisterTheUser(t *testing.T) {
 	}
 }
 
+func TestRegisterTheUserNormalizesPhoneNumber(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository:  mockRepository,
+		PhoneRegion: "ID",
+	}
+
+	mockRepository.EXPECT().RegisterUser(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, user repository.User) error {
+		assert.Equal(t, "+62812345678", user.PhoneNumber)
+		return nil
+	})
+
+	reqBody, _ := json.Marshal(map[string]interface{}{
+		"full_name":    "John Doe",
+		"phone_number": "0812-345-678",
+		"password":     "password",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/register", bytes.NewReader(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	assert.NoError(t, server.RegisterTheUser(c))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+}
+
+func TestRegisterTheUserConcurrently(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository: mockRepository,
+		// Keeps many hashes in parallel cheap
+		PasswordHasher: utils.NewPasswordHasher(utils.Argon2idParams{
+			Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32,
+		}),
+	}
+
+	// Behaves like the unique constraint on users.phone_number
+	var mu sync.Mutex
+	registered := map[string]string{}
+	mockRepository.EXPECT().RegisterUser(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, user repository.User) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if _, ok := registered[user.PhoneNumber]; ok {
+				return &repository.Error{Op: "RegisterUser", Kind: repository.ErrConflict,
+					Constraint: repository.ConstraintUsersPhoneNumber}
+			}
+			registered[user.PhoneNumber] = user.UserID
+			return nil
+		})
+
+	const registrations = 50
+	codes := make(chan int, registrations)
+	var wg sync.WaitGroup
+	for i := 0; i < registrations; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reqBody, _ := json.Marshal(map[string]interface{}{
+				"full_name":    "John Doe",
+				"phone_number": "+62234567890",
+				"password":     "password",
+			})
+			req := httptest.NewRequest(http.MethodPost, "/register", bytes.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+
+			err := server.RegisterTheUser(c)
+			if httpErr, ok := err.(*echo.HTTPError); ok {
+				codes <- httpErr.Code
+				return
+			}
+			assert.NoError(t, err)
+			codes <- rec.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, map[int]int{
+		http.StatusCreated:  1,
+		http.StatusConflict: registrations - 1,
+	}, counts)
+	assert.Len(t, registered, 1)
+}
+
+// registrationRecorder passes RegisterUser through to the database and
+// keeps every error it returned.
+type registrationRecorder struct {
+	repository.RepositoryInterface
+	errs chan error
+}
+
+func (r *registrationRecorder) RegisterUser(ctx context.Context, user repository.User) error {
+	err := r.RepositoryInterface.RegisterUser(ctx, user)
+	r.errs <- err
+	return err
+}
+
+// TestRegisterTheUserConcurrentlyOnPostgres registers one number many times
+// at once against the PostgreSQL at DATABASE_URL, migrated to the latest
+// version.
+func TestRegisterTheUserConcurrentlyOnPostgres(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+
+	repo := repository.NewRepository(repository.NewRepositoryOptions{Dsn: dsn})
+	defer repo.Db.Close()
+	migrator, err := migrations.NewMigrator(repo.Db, migrations.Files())
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = migrator.Up(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	phoneNumber := fmt.Sprintf("+628%08d", time.Now().UnixNano()%1e8)
+	defer repo.Db.Exec("DELETE FROM users WHERE phone_number = $1", phoneNumber)
+
+	const registrations = 50
+	recorder := &registrationRecorder{RepositoryInterface: repo, errs: make(chan error, registrations)}
+	server := &Server{
+		Repository: recorder,
+		// Keeps many hashes in parallel cheap
+		PasswordHasher: utils.NewPasswordHasher(utils.Argon2idParams{
+			Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32,
+		}),
+	}
+
+	codes := make(chan int, registrations)
+	var wg sync.WaitGroup
+	for i := 0; i < registrations; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reqBody, _ := json.Marshal(map[string]interface{}{
+				"full_name":    "John Doe",
+				"phone_number": phoneNumber,
+				"password":     "password",
+			})
+			req := httptest.NewRequest(http.MethodPost, "/register", bytes.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+
+			err := server.RegisterTheUser(c)
+			if httpErr, ok := err.(*echo.HTTPError); ok {
+				codes <- httpErr.Code
+				return
+			}
+			assert.NoError(t, err)
+			codes <- rec.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	close(recorder.errs)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, map[int]int{
+		http.StatusCreated:  1,
+		http.StatusConflict: registrations - 1,
+	}, counts)
+
+	// Every user_id is fresh, so only the phone number can conflict
+	for err := range recorder.errs {
+		if err != nil {
+			assert.True(t, repository.IsConflictOn(err, repository.ConstraintUsersPhoneNumber), err)
+		}
+	}
+
+	count, err := repo.CheckPhoneNumber(context.Background(), phoneNumber)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestRegisterTheUserPasswordPolicy(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository:     mockRepository,
+		PasswordPolicy: utils.DefaultPasswordPolicy,
+	}
+
+	reqBody, _ := json.Marshal(map[string]interface{}{
+		"full_name":    "John Doe",
+		"phone_number": "+62234567890",
+		"password":     "johndoe",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/register", bytes.NewReader(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	e := echo.New()
+	c := e.NewContext(req, rec)
+
+	err := server.RegisterTheUser(c)
+	e.HTTPErrorHandler(err, c)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	var response struct {
+		Message string
+		Errors  []utils.PasswordViolation
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, "Password Does Not Meet The Password Policy", response.Message)
+	var rules []string
+	for _, violation := range response.Errors {
+		rules = append(rules, violation.Rule)
+	}
+	assert.Equal(t, []string{
+		utils.PasswordRuleMinLength,
+		utils.PasswordRuleUppercase,
+		utils.PasswordRuleDigit,
+		utils.PasswordRulePersonalInfo,
+	}, rules)
+}
+
 func TestLoginUser(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -151,8 +414,12 @@ func TestLoginUser(t *testing.T) {
 
 	server := &Server{
 		Repository: mockRepository,
+		KeyRing:    newTestKeyRing(t),
 	}
 	hashedPassword, _ := utils.HashPassword("password")
+	legacyHash, _ := bcrypt.GenerateFromPassword([]byte("password"), 10)
+	lockedUntil := time.Now().Add(time.Minute)
+	verifiedAt := time.Now().Add(-time.Hour)
 	tests := []struct {
 		name            string
 		requestBody     interface{}
@@ -161,6 +428,11 @@ func TestLoginUser(t *testing.T) {
 		expectedError   bool
 		isInputValidate bool
 		isProceedLogin  bool
+		isAttemptFree   bool
+		isRehashed      bool
+		unverifiedLogin UnverifiedLoginPolicy
+		expectedScopes  []string
+		expectedErrCode int
 	}{
 		{
 			name: "Successful Login",
@@ -173,6 +445,7 @@ func TestLoginUser(t *testing.T) {
 				PhoneNumber:              "+62234567890",
 				Password:                 hashedPassword, // Assuming this is the hashed password stored in the repository
 				SuccessfullLoginAttempts: 0,
+				PhoneVerifiedAt:          &verifiedAt,
 				CreatedAt:                time.Now(),
 				UpdatedAt:                time.Now(),
 			},
@@ -192,6 +465,7 @@ func TestLoginUser(t *testing.T) {
 				PhoneNumber:              "+62234567890",
 				Password:                 hashedPassword, // Assuming this is the hashed password stored in the repository
 				SuccessfullLoginAttempts: 0,
+				PhoneVerifiedAt:          &verifiedAt,
 				CreatedAt:                time.Now(),
 				UpdatedAt:                time.Now(),
 			},
@@ -211,6 +485,7 @@ func TestLoginUser(t *testing.T) {
 				PhoneNumber:              "+62234567890",
 				Password:                 hashedPassword, // Assuming this is the hashed password stored in the repository
 				SuccessfullLoginAttempts: 0,
+				PhoneVerifiedAt:          &verifiedAt,
 				CreatedAt:                time.Now(),
 				UpdatedAt:                time.Now(),
 			},
@@ -230,6 +505,7 @@ func TestLoginUser(t *testing.T) {
 				PhoneNumber:              "+62234567890",
 				Password:                 hashedPassword, // Assuming this is the hashed password stored in the repository
 				SuccessfullLoginAttempts: 0,
+				PhoneVerifiedAt:          &verifiedAt,
 				CreatedAt:                time.Now(),
 				UpdatedAt:                time.Now(),
 			},
@@ -237,6 +513,98 @@ func TestLoginUser(t *testing.T) {
 			expectedError:   true,
 			isInputValidate: true,
 			isProceedLogin:  false,
+			isAttemptFree:   true,
+			expectedErrCode: http.StatusBadRequest,
+		},
+		{
+			name: "Account Locked",
+			requestBody: map[string]interface{}{
+				"phone_number": "+62234567890",
+				"Password":     "password",
+			},
+			mockOutput: &repository.User{
+				UserID:      "mockUserID",
+				PhoneNumber: "+62234567890",
+				Password:    hashedPassword,
+				LockedUntil: &lockedUntil,
+			},
+			expectedCode:    http.StatusOK,
+			expectedError:   true,
+			isInputValidate: true,
+			isProceedLogin:  false,
+			expectedErrCode: http.StatusLocked,
+		},
+		{
+			name: "Too Many Concurrent Attempts",
+			requestBody: map[string]interface{}{
+				"phone_number": "+62234567890",
+				"Password":     "password",
+			},
+			mockOutput: &repository.User{
+				UserID:      "mockUserID",
+				PhoneNumber: "+62234567890",
+				Password:    hashedPassword,
+			},
+			expectedCode:    http.StatusOK,
+			expectedError:   true,
+			isInputValidate: true,
+			isProceedLogin:  false,
+			isAttemptFree:   false,
+			expectedErrCode: http.StatusLocked,
+		},
+		{
+			name: "Successful Login Upgrades Bcrypt Hash",
+			requestBody: map[string]interface{}{
+				"phone_number": "+62234567890",
+				"Password":     "password",
+			},
+			mockOutput: &repository.User{
+				UserID:          "mockUserID",
+				PhoneNumber:     "+62234567890",
+				Password:        string(legacyHash),
+				PhoneVerifiedAt: &verifiedAt,
+			},
+			expectedCode:    http.StatusOK,
+			expectedError:   false,
+			isInputValidate: true,
+			isProceedLogin:  true,
+			isRehashed:      true,
+		},
+		{
+			name: "Unverified Phone Number Blocked",
+			requestBody: map[string]interface{}{
+				"phone_number": "+62234567890",
+				"Password":     "password",
+			},
+			mockOutput: &repository.User{
+				UserID:      "mockUserID",
+				PhoneNumber: "+62234567890",
+				Password:    hashedPassword,
+			},
+			expectedCode:    http.StatusOK,
+			expectedError:   true,
+			isInputValidate: true,
+			isAttemptFree:   true,
+			unverifiedLogin: UnverifiedLoginBlock,
+			expectedErrCode: http.StatusForbidden,
+		},
+		{
+			name: "Unverified Phone Number Limited",
+			requestBody: map[string]interface{}{
+				"phone_number": "+62234567890",
+				"Password":     "password",
+			},
+			mockOutput: &repository.User{
+				UserID:      "mockUserID",
+				PhoneNumber: "+62234567890",
+				Password:    hashedPassword,
+			},
+			expectedCode:    http.StatusOK,
+			expectedError:   false,
+			isInputValidate: true,
+			isProceedLogin:  true,
+			unverifiedLogin: UnverifiedLoginLimited,
+			expectedScopes:  []string{utils.ScopePhoneVerify},
 		},
 		// Add more test cases as needed
 	}
@@ -245,8 +613,30 @@ func TestLoginUser(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			if tc.isInputValidate {
 				mockRepository.EXPECT().CheckUser(gomock.Any(), gomock.Any()).Return(tc.mockOutput, nil)
+				if tc.mockOutput.LockedUntil == nil {
+					mockRepository.EXPECT().ReserveLoginAttempt(gomock.Any(), gomock.Any()).Return(tc.isProceedLogin || tc.isAttemptFree, nil)
+					if !tc.isProceedLogin && !tc.isAttemptFree {
+						// The concurrent attempt that got there first stored its own lock
+						mockRepository.EXPECT().GetUserByUserId(gomock.Any(), "mockUserID").Return(
+							&repository.User{UserID: "mockUserID", LockedUntil: &lockedUntil}, nil)
+					}
+				}
+				if tc.expectedErrCode == http.StatusForbidden {
+					// A blocked login is not a successful one
+					mockRepository.EXPECT().ResetFailedLoginAttempts(gomock.Any(), gomock.Any()).Return(nil)
+				}
 				if tc.isProceedLogin {
-					mockRepository.EXPECT().UpdateLoginUser(gomock.Any(), gomock.Any()).Return(nil)
+					mockRepository.EXPECT().RecordLoginSuccess(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+					mockRepository.EXPECT().CreateTokenFamily(gomock.Any(), gomock.Any()).Return(nil)
+					mockRepository.EXPECT().CreateRefreshToken(gomock.Any(), gomock.Any()).Return(nil)
+				}
+				if tc.isRehashed {
+					mockRepository.EXPECT().RehashPassword(gomock.Any(), "mockUserID", string(legacyHash), gomock.Any()).DoAndReturn(
+						func(_ context.Context, _ string, _ string, newHash string) error {
+							assert.True(t, strings.HasPrefix(newHash, "$argon2id$"))
+							assert.NoError(t, utils.CheckPassword("password", newHash))
+							return nil
+						})
 				}
 			}
 			reqBody, _ := json.Marshal(tc.requestBody)
@@ -258,11 +648,148 @@ func TestLoginUser(t *testing.T) {
 			e := echo.New()
 			c := e.NewContext(req, rec)
 
+			server.PhoneVerification.UnverifiedLogin = tc.unverifiedLogin
 			err := server.LoginUser(c)
 
 			if tc.expectedError {
 				assert.Error(t, err)
 				//assert.NotEqual(t, tc.expectedCode, rec.Code)
+				if tc.expectedErrCode != 0 {
+					assert.Equal(t, tc.expectedErrCode, err.(*echo.HTTPError).Code)
+				}
+				if tc.expectedErrCode == http.StatusLocked {
+					assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+
+				var tokens map[string]string
+				json.Unmarshal(rec.Body.Bytes(), &tokens)
+				claims, err := utils.ValidateJWTToken(tokens["access_token"], server.KeyRing, utils.DefaultJWTIssuer, utils.DefaultJWTAudience)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedScopes, claims.Scopes)
+			}
+		})
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	keys := newTestKeyRing(t)
+	server := &Server{
+		Repository: mockRepository,
+		KeyRing:    keys,
+	}
+	pair, _ := utils.GenerateTokenPair("mockUserID", "", utils.RoleUser, keys)
+	accessToken, refreshToken := pair.AccessToken, pair.RefreshToken
+	usedAt := time.Now().Add(-time.Minute)
+	tests := []struct {
+		name           string
+		refreshToken   string
+		mockOutput     *repository.RefreshToken
+		isLookedUp     bool
+		isMarkedUsed   bool
+		isReused       bool
+		expectedCode   int
+		expectedError  bool
+		expectedErrMsg string
+	}{
+		{
+			name:         "Successful Refresh",
+			refreshToken: refreshToken,
+			mockOutput: &repository.RefreshToken{
+				TokenID:  "mockTokenID",
+				FamilyID: "mockFamilyID",
+				UserID:   "mockUserID",
+			},
+			isLookedUp:    true,
+			isMarkedUsed:  true,
+			expectedCode:  http.StatusOK,
+			expectedError: false,
+		},
+		{
+			name:           "Empty Refresh Token",
+			refreshToken:   "",
+			expectedCode:   http.StatusBadRequest,
+			expectedError:  true,
+			expectedErrMsg: "Refresh Token Cannot Be Empty",
+		},
+		{
+			name:           "Access Token Instead Of Refresh Token",
+			refreshToken:   accessToken,
+			expectedCode:   http.StatusUnauthorized,
+			expectedError:  true,
+			expectedErrMsg: "Invalid Refresh Token",
+		},
+		{
+			name:         "Revoked Token Family",
+			refreshToken: refreshToken,
+			mockOutput: &repository.RefreshToken{
+				TokenID:         "mockTokenID",
+				FamilyID:        "mockFamilyID",
+				UserID:          "mockUserID",
+				FamilyRevokedAt: &usedAt,
+			},
+			isLookedUp:     true,
+			expectedCode:   http.StatusUnauthorized,
+			expectedError:  true,
+			expectedErrMsg: "Refresh Token Has Been Revoked",
+		},
+		{
+			name:         "Reused Refresh Token",
+			refreshToken: refreshToken,
+			mockOutput: &repository.RefreshToken{
+				TokenID:  "mockTokenID",
+				FamilyID: "mockFamilyID",
+				UserID:   "mockUserID",
+				UsedAt:   &usedAt,
+			},
+			isLookedUp:     true,
+			isReused:       true,
+			expectedCode:   http.StatusUnauthorized,
+			expectedError:  true,
+			expectedErrMsg: "Refresh Token Has Already Been Used",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.isLookedUp {
+				mockRepository.EXPECT().GetRefreshToken(gomock.Any(), gomock.Any()).Return(tc.mockOutput, nil)
+			}
+			if tc.isMarkedUsed {
+				mockRepository.EXPECT().MarkRefreshTokenUsed(gomock.Any(), tc.mockOutput.TokenID, gomock.Any()).Return(true, nil)
+				mockRepository.EXPECT().CreateRefreshToken(gomock.Any(), gomock.Any()).Return(nil)
+				mockRepository.EXPECT().TouchTokenFamily(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, seen repository.TokenFamilySeen) error {
+						assert.Equal(t, tc.mockOutput.FamilyID, seen.FamilyID)
+						assert.NotEmpty(t, seen.IPAddress)
+						return nil
+					})
+			}
+			if tc.isReused {
+				mockRepository.EXPECT().RevokeTokenFamily(gomock.Any(), tc.mockOutput.FamilyID, gomock.Any()).Return(nil)
+			}
+
+			reqBody, _ := json.Marshal(map[string]string{"refresh_token": tc.refreshToken})
+			req := httptest.NewRequest(http.MethodPost, "/token/refresh", bytes.NewReader(reqBody))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+
+			e := echo.New()
+			c := e.NewContext(req, rec)
+
+			err := server.RefreshToken(c)
+
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+				assert.Equal(t, tc.expectedErrMsg, httpErr.Message)
 			} else {
 				assert.NoError(t, err)
 				assert.Equal(t, tc.expectedCode, rec.Code)
@@ -271,19 +798,65 @@ func TestLoginUser(t *testing.T) {
 	}
 }
 
+func TestRefreshTokenReuseRevokesCachedFamily(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	keys := newTestKeyRing(t)
+	server := &Server{
+		Repository:      mockRepository,
+		KeyRing:         keys,
+		RevocationCache: middleware.NewRevocationCache(mockRepository, time.Minute),
+	}
+	pair, _ := utils.GenerateTokenPair("mockUserID", "mockFamilyID", utils.RoleUser, keys)
+	accessClaims, err := utils.ValidateJWTToken(pair.AccessToken, keys, utils.DefaultJWTIssuer, utils.DefaultJWTAudience)
+	assert.NoError(t, err)
+
+	// The access token of the family was checked, and found valid, before
+	// the refresh token was reused
+	mockRepository.EXPECT().GetTokensValidAfter(gomock.Any(), "mockUserID").Return(nil, nil)
+	mockRepository.EXPECT().IsTokenFamilyRevoked(gomock.Any(), "mockFamilyID").Return(false, nil)
+	mockRepository.EXPECT().IsTokenRevoked(gomock.Any(), accessClaims.ID).Return(false, nil)
+	revoked, err := server.RevocationCache.IsRevoked(context.Background(), accessClaims)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	usedAt := time.Now().Add(-time.Minute)
+	mockRepository.EXPECT().GetRefreshToken(gomock.Any(), gomock.Any()).Return(&repository.RefreshToken{
+		TokenID:  "mockTokenID",
+		FamilyID: "mockFamilyID",
+		UserID:   "mockUserID",
+		UsedAt:   &usedAt,
+	}, nil)
+	mockRepository.EXPECT().RevokeTokenFamily(gomock.Any(), "mockFamilyID", gomock.Any()).Return(nil)
+
+	reqBody, _ := json.Marshal(map[string]string{"refresh_token": pair.RefreshToken})
+	req := httptest.NewRequest(http.MethodPost, "/token/refresh", bytes.NewReader(reqBody))
+	rec := httptest.NewRecorder()
+	err = server.RefreshToken(echo.New().NewContext(req, rec))
+	httpErr, ok := err.(*echo.HTTPError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusUnauthorized, httpErr.Code)
+
+	// The cached "not revoked" answer is replaced without waiting for it to
+	// expire
+	revoked, err = server.RevocationCache.IsRevoked(context.Background(), accessClaims)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+}
+
 func TestGetProfile(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	mockRepository := repository.NewMockRepositoryInterface(ctrl)
-	os.Setenv("JWT_SECRET", "verysecret")
 	server := &Server{
 		Repository: mockRepository,
 	}
-	jwtToken, _, _ := utils.GenerateJWTToken("mockUserID", "verysecret")
 	tests := []struct {
 		name           string
-		authHeader     string
+		principal      *middleware.Principal
 		mockInput      string
 		mockOutput     *repository.User
 		expectedCode   int
@@ -291,9 +864,9 @@ func TestGetProfile(t *testing.T) {
 		expectedError  string
 	}{
 		{
-			name:       "Successful Profile Retrieval",
-			authHeader: "Bearer " + jwtToken,
-			mockInput:  "mockUserID",
+			name:      "Successful Profile Retrieval",
+			principal: &middleware.Principal{UserID: "mockUserID"},
+			mockInput: "mockUserID",
 			mockOutput: &repository.User{
 				UserID:      "mockUserID",
 				FullName:    "John Doe",
@@ -308,20 +881,30 @@ func TestGetProfile(t *testing.T) {
 			},
 			expectedError: "",
 		},
+		{
+			name:          "Missing Principal",
+			principal:     nil,
+			expectedCode:  http.StatusUnauthorized,
+			expectedError: "Unauthorized",
+		},
 		// Add more test cases as needed
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			mockRepository.EXPECT().GetUserByUserId(gomock.Any(), tc.mockInput).Return(tc.mockOutput, nil)
+			if tc.principal != nil {
+				mockRepository.EXPECT().GetUserByUserId(gomock.Any(), tc.mockInput).Return(tc.mockOutput, nil)
+			}
 
 			req := httptest.NewRequest(http.MethodGet, "/user", nil)
-			req.Header.Set("Authorization", tc.authHeader)
 			rec := httptest.NewRecorder()
 
 			// Create a new Echo instance and handle the request
 			e := echo.New()
 			c := e.NewContext(req, rec)
+			if tc.principal != nil {
+				middleware.SetPrincipal(c, tc.principal)
+			}
 
 			err := server.GetProfile(c)
 
@@ -337,18 +920,119 @@ func TestGetProfile(t *testing.T) {
 	}
 }
 
+func TestGetProfilePassesRequestContext(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository: mockRepository,
+	}
+	type contextKey struct{}
+	tests := []struct {
+		name         string
+		mockError    error
+		expectedCode int
+	}{
+		{
+			name: "User Not Found",
+			mockError: &repository.Error{Op: "GetUserByUserId", Kind: repository.ErrNotFound,
+				Err: errors.New("sql: no rows in result set")},
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name: "Database Down",
+			mockError: &repository.Error{Op: "GetUserByUserId", Kind: repository.ErrUnavailable,
+				Err: errors.New("dial tcp 10.0.0.5:5432: connect: connection refused")},
+			expectedCode: http.StatusServiceUnavailable,
+		},
+		{
+			name: "Query Timed Out",
+			mockError: &repository.Error{Op: "GetUserByUserId", Kind: repository.ErrUnavailable,
+				Err: context.DeadlineExceeded},
+			expectedCode: http.StatusGatewayTimeout,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepository.EXPECT().GetUserByUserId(gomock.Any(), "mockUserID").DoAndReturn(
+				func(ctx context.Context, _ string) (*repository.User, error) {
+					assert.Equal(t, "requestValue", ctx.Value(contextKey{}))
+					return nil, tc.mockError
+				})
+
+			req := httptest.NewRequest(http.MethodGet, "/user", nil)
+			req = req.WithContext(context.WithValue(req.Context(), contextKey{}, "requestValue"))
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			middleware.SetPrincipal(c, &middleware.Principal{UserID: "mockUserID"})
+
+			err := server.GetProfile(c)
+			httpErr, ok := err.(*echo.HTTPError)
+			assert.True(t, ok)
+			assert.Equal(t, tc.expectedCode, httpErr.Code)
+			// The cause is only kept for the logs
+			assert.NotContains(t, httpErr.Message, "sql")
+			assert.NotContains(t, httpErr.Message, "10.0.0.5")
+			assert.ErrorIs(t, httpErr, tc.mockError)
+		})
+	}
+}
+
+// slowDriver is a database driver whose queries only end when their context
+// does, failing the way lib/pq does: with query_canceled rather than the
+// context error.
+type slowDriver struct{}
+
+func (slowDriver) Open(string) (driver.Conn, error) { return slowConn{}, nil }
+
+type slowConn struct{}
+
+func (slowConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (slowConn) Close() error                        { return nil }
+func (slowConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (slowConn) QueryContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, &pq.Error{Code: "57014", Message: "canceling statement due to user request"}
+}
+
+func init() {
+	sql.Register("slow", slowDriver{})
+}
+
+func TestGetProfileQueryTimeout(t *testing.T) {
+	db, err := sql.Open("slow", "")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	server := &Server{
+		Repository: &repository.Repository{Db: db, QueryTimeout: 20 * time.Millisecond},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	middleware.SetPrincipal(c, &middleware.Principal{UserID: "mockUserID"})
+
+	err = server.GetProfile(c)
+	httpErr, ok := err.(*echo.HTTPError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusGatewayTimeout, httpErr.Code)
+	assert.ErrorIs(t, httpErr, context.DeadlineExceeded)
+}
+
 func TestUpdateProfile(t *testing.T) {
 	// Set up mock repository and server
 	// Initialize your server and mock repository
-	os.Setenv("JWT_SECRET", "verysecret")
-	jwtToken, _, _ := utils.GenerateJWTToken("mockUserID", "verysecret")
-
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 	mockRepository := repository.NewMockRepositoryInterface(ctrl)
 	server := &Server{
 		Repository: mockRepository,
 	}
+	pendingPhone := "+621234567890"
 	tests := []struct {
 		name                 string
 		requestBody          map[string]string
@@ -361,6 +1045,7 @@ func TestUpdateProfile(t *testing.T) {
 		expectedError        bool
 		isInputValidate      bool
 		isProceedUpdate      bool
+		expectedPending      *string // Phone number left waiting for verification
 	}{
 		{
 			name: "Successful Profile Update",
@@ -462,7 +1147,28 @@ func TestUpdateProfile(t *testing.T) {
 			isInputValidate:      true,
 			isProceedUpdate:      false,
 		},
-	}
+		{
+			name: "New Phone Number Stays Pending",
+			requestBody: map[string]string{
+				"full_name":    "John Doe",
+				"phone_number": "+621234567890",
+			},
+			userID:        "mockUserID",
+			existingPhone: "+621234567893",
+			mockOutput: &repository.User{
+				UserID:      "mockUserID",
+				FullName:    "Old Name",
+				PhoneNumber: "+621234567893",
+			},
+			mockCheckPhoneNumber: 0,
+			expectedCode:         http.StatusAccepted,
+			expectedMsg:          "User Profile Successfully Updated! Please Verify Your New Phone Number",
+			expectedError:        false,
+			isInputValidate:      true,
+			isProceedUpdate:      true,
+			expectedPending:      &pendingPhone,
+		},
+	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -470,7 +1176,11 @@ func TestUpdateProfile(t *testing.T) {
 				mockRepository.EXPECT().GetUserByUserId(gomock.Any(), tc.userID).Return(tc.mockOutput, nil)
 				mockRepository.EXPECT().CheckPhoneNumber(gomock.Any(), tc.requestBody["phone_number"]).Return(int64(tc.mockCheckPhoneNumber), nil)
 				if tc.isProceedUpdate {
-					mockRepository.EXPECT().UpdateUserProfile(gomock.Any(), gomock.Any()).Return(nil)
+					mockRepository.EXPECT().UpdateUserProfile(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, user repository.User) error {
+						assert.Equal(t, tc.existingPhone, user.PhoneNumber)
+						assert.Equal(t, tc.expectedPending, user.PendingPhoneNumber)
+						return nil
+					})
 				}
 			}
 			reqBody, err := json.Marshal(tc.requestBody)
@@ -479,17 +1189,18 @@ func TestUpdateProfile(t *testing.T) {
 			}
 
 			req := httptest.NewRequest(http.MethodPut, "/user", bytes.NewReader(reqBody))
-			req.Header.Set("Authorization", "Bearer "+jwtToken)
 			rec := httptest.NewRecorder()
 
 			e := echo.New()
 			c := e.NewContext(req, rec)
+			middleware.SetPrincipal(c, &middleware.Principal{UserID: tc.userID})
 
 			err = server.UpdateProfile(c)
 
 			if !tc.expectedError {
 				assert.NoError(t, err)
 				assert.Equal(t, tc.expectedCode, rec.Code)
+				assert.Contains(t, rec.Body.String(), tc.expectedMsg)
 
 			} else {
 				assert.Error(t, err)
@@ -498,3 +1209,2628 @@ func TestUpdateProfile(t *testing.T) {
 		})
 	}
 }
+
+func TestLogout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository: mockRepository,
+	}
+	tests := []struct {
+		name          string
+		principal     *middleware.Principal
+		isRevoked     bool
+		expectedCode  int
+		expectedError bool
+	}{
+		{
+			name: "Successful Logout",
+			principal: &middleware.Principal{
+				UserID:    "mockUserID",
+				TokenID:   "mockTokenID",
+				FamilyID:  "mockFamilyID",
+				ExpiresAt: time.Now().Add(time.Minute),
+			},
+			isRevoked:     true,
+			expectedCode:  http.StatusOK,
+			expectedError: false,
+		},
+		{
+			name:          "Missing Principal",
+			principal:     nil,
+			isRevoked:     false,
+			expectedCode:  http.StatusUnauthorized,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.isRevoked {
+				mockRepository.EXPECT().RevokeToken(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, revoked repository.RevokedToken) error {
+						assert.Equal(t, tc.principal.TokenID, revoked.TokenID)
+						assert.Equal(t, tc.principal.ExpiresAt, revoked.ExpiresAt)
+						return nil
+					})
+				mockRepository.EXPECT().RevokeTokenFamily(gomock.Any(), tc.principal.FamilyID, gomock.Any()).Return(nil)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+			rec := httptest.NewRecorder()
+			e := echo.New()
+			c := e.NewContext(req, rec)
+			if tc.principal != nil {
+				middleware.SetPrincipal(c, tc.principal)
+			}
+
+			err := server.Logout(c)
+
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+			}
+		})
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository:      mockRepository,
+		RevocationCache: middleware.NewRevocationCache(mockRepository, time.Minute),
+	}
+
+	var validAfter time.Time
+	mockRepository.EXPECT().RevokeAllUserTokens(gomock.Any(), "mockUserID", gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ string, at time.Time) error {
+			validAfter = at
+			return nil
+		})
+	mockRepository.EXPECT().IsTokenRevoked(gomock.Any(), "nextTokenID").Return(false, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/logout/all", nil)
+	rec := httptest.NewRecorder()
+	e := echo.New()
+	c := e.NewContext(req, rec)
+	middleware.SetPrincipal(c, &middleware.Principal{UserID: "mockUserID", TokenID: "mockTokenID"})
+
+	err := server.LogoutAll(c)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Tokens issued before the logout are rejected straight from the cache
+	revoked, err := server.RevocationCache.IsRevoked(context.Background(), &utils.JWTClaims{
+		UserID: "mockUserID",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       "mockTokenID",
+			IssuedAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		},
+	})
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	// A token issued in the same second as the logout, whose iat is rounded
+	// down to that second, stays valid
+	assert.Equal(t, validAfter.Truncate(time.Second), validAfter)
+	revoked, err = server.RevocationCache.IsRevoked(context.Background(), &utils.JWTClaims{
+		UserID: "mockUserID",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       "nextTokenID",
+			IssuedAt: jwt.NewNumericDate(validAfter),
+		},
+	})
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestGetJWKS(t *testing.T) {
+	server := &Server{
+		KeyRing: newTestKeyRing(t),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	rec := httptest.NewRecorder()
+	e := echo.New()
+	c := e.NewContext(req, rec)
+
+	err := server.GetJWKS(c)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var set utils.JWKSet
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &set))
+	assert.Len(t, set.Keys, 1)
+	assert.Equal(t, "testKey", set.Keys[0].Kid)
+	assert.Equal(t, "ES256", set.Keys[0].Alg)
+}
+
+func TestChangePassword(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository: mockRepository,
+		KeyRing:    newTestKeyRing(t),
+	}
+	hashedPassword, _ := utils.HashPassword("password")
+	lockedUntil := time.Now().Add(time.Minute)
+	tests := []struct {
+		name            string
+		requestBody     map[string]string
+		isUserLoaded    bool
+		lockedUntil     *time.Time
+		isAttemptDenied bool
+		isProceedChange bool
+		expectedCode    int
+		expectedError   bool
+	}{
+		{
+			name: "Successful Password Change",
+			requestBody: map[string]string{
+				"current_password": "password",
+				"new_password":     "newpassword",
+			},
+			isUserLoaded:    true,
+			isProceedChange: true,
+			expectedCode:    http.StatusOK,
+			expectedError:   false,
+		},
+		{
+			name: "Empty Current Password",
+			requestBody: map[string]string{
+				"current_password": "",
+				"new_password":     "newpassword",
+			},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name: "Empty New Password",
+			requestBody: map[string]string{
+				"current_password": "password",
+				"new_password":     "",
+			},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name: "Wrong Current Password",
+			requestBody: map[string]string{
+				"current_password": "wrongpassword",
+				"new_password":     "newpassword",
+			},
+			isUserLoaded:  true,
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name: "Account Locked",
+			requestBody: map[string]string{
+				"current_password": "password",
+				"new_password":     "newpassword",
+			},
+			isUserLoaded:  true,
+			lockedUntil:   &lockedUntil,
+			expectedCode:  http.StatusLocked,
+			expectedError: true,
+		},
+		{
+			name: "Guess That Reaches The Limit",
+			requestBody: map[string]string{
+				"current_password": "wrongpassword",
+				"new_password":     "newpassword",
+			},
+			isUserLoaded:    true,
+			isAttemptDenied: true,
+			expectedCode:    http.StatusLocked,
+			expectedError:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.isUserLoaded {
+				mockRepository.EXPECT().GetUserByUserId(gomock.Any(), "mockUserID").Return(&repository.User{
+					ID:          1,
+					UserID:      "mockUserID",
+					Password:    hashedPassword,
+					LockedUntil: tc.lockedUntil,
+				}, nil)
+				if tc.lockedUntil == nil {
+					mockRepository.EXPECT().ReserveLoginAttempt(gomock.Any(), gomock.Any()).DoAndReturn(
+						func(_ context.Context, input repository.LoginAttemptInput) (bool, error) {
+							assert.Equal(t, 1, input.ID)
+							return !tc.isAttemptDenied, nil
+						})
+					if tc.isAttemptDenied {
+						storedLock := time.Now().Add(time.Minute)
+						mockRepository.EXPECT().GetUserByUserId(gomock.Any(), "mockUserID").Return(&repository.User{
+							ID:          1,
+							UserID:      "mockUserID",
+							LockedUntil: &storedLock,
+						}, nil)
+					}
+				}
+			}
+			if tc.isProceedChange {
+				mockRepository.EXPECT().UnlockUser(gomock.Any(), "mockUserID").Return(nil)
+				mockRepository.EXPECT().UpdatePassword(gomock.Any(), "mockUserID", gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, _ string, newHash string, _ time.Time) error {
+						assert.NoError(t, utils.CheckPassword(tc.requestBody["new_password"], newHash))
+						return nil
+					})
+				mockRepository.EXPECT().RevokeAllUserTokens(gomock.Any(), "mockUserID", gomock.Any()).Return(nil)
+				mockRepository.EXPECT().CreateTokenFamily(gomock.Any(), gomock.Any()).Return(nil)
+				mockRepository.EXPECT().CreateRefreshToken(gomock.Any(), gomock.Any()).Return(nil)
+			}
+
+			reqBody, _ := json.Marshal(tc.requestBody)
+			req := httptest.NewRequest(http.MethodPut, "/user/password", bytes.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+			e := echo.New()
+			c := e.NewContext(req, rec)
+			middleware.SetPrincipal(c, &middleware.Principal{UserID: "mockUserID", FamilyID: "mockFamilyID"})
+
+			err := server.ChangePassword(c)
+
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+				assert.Contains(t, rec.Body.String(), "access_token")
+			}
+		})
+	}
+}
+
+func TestForgotPassword(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	var sent bytes.Buffer
+	codeHasher, _ := utils.NewCodeHasher(bytes.Repeat([]byte{1}, 32))
+	server := &Server{
+		Repository: mockRepository,
+		Notifier:   notifier.NewLogNotifier(&sent),
+		CodeHasher: codeHasher,
+	}
+	tests := []struct {
+		name          string
+		requestBody   map[string]string
+		isRegistered  bool
+		isCheckPhone  bool
+		createErr     error
+		sendErr       error
+		expectedCode  int
+		expectedError bool
+	}{
+		{
+			name:          "Registered Phone Number",
+			requestBody:   map[string]string{"phone_number": "+62812345678"},
+			isRegistered:  true,
+			isCheckPhone:  true,
+			expectedCode:  http.StatusAccepted,
+			expectedError: false,
+		},
+		{
+			name:          "Unregistered Phone Number",
+			requestBody:   map[string]string{"phone_number": "+62812345679"},
+			isCheckPhone:  true,
+			expectedCode:  http.StatusAccepted,
+			expectedError: false,
+		},
+		{
+			name:          "Queue Full",
+			requestBody:   map[string]string{"phone_number": "+62812345678"},
+			isRegistered:  true,
+			isCheckPhone:  true,
+			sendErr:       notifier.ErrQueueFull,
+			expectedCode:  http.StatusAccepted,
+			expectedError: false,
+		},
+		{
+			name:          "Code Not Stored",
+			requestBody:   map[string]string{"phone_number": "+62812345678"},
+			isRegistered:  true,
+			isCheckPhone:  true,
+			createErr:     &repository.Error{Op: "CreatePasswordResetCode", Kind: repository.ErrUnavailable},
+			expectedCode:  http.StatusAccepted,
+			expectedError: false,
+		},
+		{
+			name:          "Empty Phone Number",
+			requestBody:   map[string]string{"phone_number": ""},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name:          "Invalid Phone Number",
+			requestBody:   map[string]string{"phone_number": "0812345678"},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sent.Reset()
+			server.Notifier = notifier.NewLogNotifier(&sent)
+			if tc.sendErr != nil {
+				server.Notifier = notifierFunc(func(context.Context, notifier.Message) error { return tc.sendErr })
+			}
+			var storedHash string
+			if tc.isCheckPhone && !tc.isRegistered {
+				mockRepository.EXPECT().CheckUser(gomock.Any(), tc.requestBody["phone_number"]).Return(nil,
+					&repository.Error{Op: "CheckUser", Kind: repository.ErrNotFound})
+			}
+			if tc.isRegistered {
+				mockRepository.EXPECT().CheckUser(gomock.Any(), tc.requestBody["phone_number"]).Return(&repository.User{
+					UserID:      "mockUserID",
+					PhoneNumber: tc.requestBody["phone_number"],
+				}, nil)
+				mockRepository.EXPECT().CreatePasswordResetCode(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, code repository.PasswordResetCode) error {
+						assert.Equal(t, "mockUserID", code.UserID)
+						assert.Equal(t, tc.requestBody["phone_number"], code.PhoneNumber)
+						assert.True(t, code.ExpiresAt.After(time.Now()))
+						storedHash = code.CodeHash
+						return tc.createErr
+					})
+			}
+
+			reqBody, _ := json.Marshal(tc.requestBody)
+			req := httptest.NewRequest(http.MethodPost, "/password/forgot", bytes.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+
+			err := server.ForgotPassword(c)
+
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+				// Every number gets the same answer, whatever happened after
+				assert.Contains(t, rec.Body.String(), "If The Phone Number Is Registered")
+				if tc.isRegistered && tc.createErr == nil && tc.sendErr == nil {
+					assert.Contains(t, sent.String(), "to="+tc.requestBody["phone_number"])
+					// The code is stored as its keyed hash
+					code := regexp.MustCompile(`\d{6}`).FindString(strings.SplitN(sent.String(), "body=", 2)[1])
+					assert.Equal(t, codeHasher.Hash(code), storedHash)
+				} else {
+					assert.Empty(t, sent.String())
+				}
+			}
+		})
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository:     mockRepository,
+		PasswordPolicy: utils.PasswordPolicy{MinLength: 8},
+	}
+	codeHash := server.codeHasher().Hash("123456")
+	tests := []struct {
+		name           string
+		requestBody    map[string]string
+		isUserMissing  bool
+		isCodeFound    bool
+		isAttemptFree  bool
+		isWeakPassword bool
+		isCodeUnused   bool
+		isProceedReset bool
+		expectedCode   int
+		expectedError  bool
+		// Message of the error, checked when set
+		expectedMessage string
+	}{
+		{
+			name: "Successful Password Reset",
+			requestBody: map[string]string{
+				"phone_number": "+62812345678",
+				"code":         "123456",
+				"new_password": "newpassword",
+			},
+			isCodeFound:    true,
+			isAttemptFree:  true,
+			isCodeUnused:   true,
+			isProceedReset: true,
+			expectedCode:   http.StatusOK,
+			expectedError:  false,
+		},
+		{
+			name: "Empty Code",
+			requestBody: map[string]string{
+				"phone_number": "+62812345678",
+				"code":         "",
+				"new_password": "newpassword",
+			},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name: "No Active Code",
+			requestBody: map[string]string{
+				"phone_number": "+62812345678",
+				"code":         "123456",
+				"new_password": "newpassword",
+			},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name: "Wrong Code",
+			requestBody: map[string]string{
+				"phone_number": "+62812345678",
+				"code":         "654321",
+				"new_password": "newpassword",
+			},
+			isCodeFound:   true,
+			isAttemptFree: true,
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name: "Too Many Attempts",
+			requestBody: map[string]string{
+				"phone_number": "+62812345678",
+				"code":         "123456",
+				"new_password": "newpassword",
+			},
+			isCodeFound:   true,
+			isAttemptFree: false,
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name: "Unregistered Phone Number With Weak Password",
+			requestBody: map[string]string{
+				"phone_number": "+62812345678",
+				"code":         "123456",
+				"new_password": "abc",
+			},
+			isUserMissing:   true,
+			expectedCode:    http.StatusBadRequest,
+			expectedError:   true,
+			expectedMessage: "Invalid Or Expired Reset Code",
+		},
+		{
+			name: "Registered Phone Number Without Code With Weak Password",
+			requestBody: map[string]string{
+				"phone_number": "+62812345678",
+				"code":         "123456",
+				"new_password": "abc",
+			},
+			expectedCode:    http.StatusBadRequest,
+			expectedError:   true,
+			expectedMessage: "Invalid Or Expired Reset Code",
+		},
+		{
+			name: "Wrong Code With Weak Password",
+			requestBody: map[string]string{
+				"phone_number": "+62812345678",
+				"code":         "654321",
+				"new_password": "abc",
+			},
+			isCodeFound:     true,
+			isAttemptFree:   true,
+			expectedCode:    http.StatusBadRequest,
+			expectedError:   true,
+			expectedMessage: "Invalid Or Expired Reset Code",
+		},
+		{
+			name: "Weak Password With Valid Code",
+			requestBody: map[string]string{
+				"phone_number": "+62812345678",
+				"code":         "123456",
+				"new_password": "abc",
+			},
+			isCodeFound:    true,
+			isAttemptFree:  true,
+			isWeakPassword: true,
+			expectedCode:   http.StatusBadRequest,
+			expectedError:  true,
+		},
+		{
+			name: "Code Used Concurrently",
+			requestBody: map[string]string{
+				"phone_number": "+62812345678",
+				"code":         "123456",
+				"new_password": "newpassword",
+			},
+			isCodeFound:   true,
+			isAttemptFree: true,
+			isCodeUnused:  false,
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.isUserMissing {
+				mockRepository.EXPECT().CheckUser(gomock.Any(), tc.requestBody["phone_number"]).
+					Return(nil, &repository.Error{Op: "CheckUser", Kind: repository.ErrNotFound})
+			} else if tc.requestBody["code"] != "" {
+				mockRepository.EXPECT().CheckUser(gomock.Any(), tc.requestBody["phone_number"]).Return(&repository.User{
+					UserID: "mockUserID",
+				}, nil)
+				if tc.isCodeFound {
+					mockRepository.EXPECT().GetActivePasswordResetCode(gomock.Any(), "mockUserID", tc.requestBody["phone_number"], gomock.Any()).
+						Return(&repository.PasswordResetCode{ID: 1, UserID: "mockUserID", CodeHash: codeHash}, nil)
+					mockRepository.EXPECT().ReservePasswordResetAttempt(gomock.Any(), 1, DefaultPasswordResetOptions.MaxAttempts).
+						Return(tc.isAttemptFree, nil)
+				} else {
+					mockRepository.EXPECT().GetActivePasswordResetCode(gomock.Any(), "mockUserID", tc.requestBody["phone_number"], gomock.Any()).
+						Return(nil, &repository.Error{Op: "GetActivePasswordResetCode", Kind: repository.ErrNotFound})
+				}
+			}
+			if tc.isAttemptFree && tc.requestBody["code"] == "123456" && !tc.isWeakPassword {
+				mockRepository.EXPECT().UsePasswordResetCode(gomock.Any(), 1, gomock.Any()).Return(tc.isCodeUnused, nil)
+			}
+			if tc.isProceedReset {
+				mockRepository.EXPECT().UpdatePassword(gomock.Any(), "mockUserID", gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, _ string, newHash string, _ time.Time) error {
+						assert.NoError(t, utils.CheckPassword(tc.requestBody["new_password"], newHash))
+						return nil
+					})
+				mockRepository.EXPECT().RevokeAllUserTokens(gomock.Any(), "mockUserID", gomock.Any()).Return(nil)
+				mockRepository.EXPECT().UnlockUser(gomock.Any(), "mockUserID").Return(nil)
+			}
+
+			reqBody, _ := json.Marshal(tc.requestBody)
+			req := httptest.NewRequest(http.MethodPost, "/password/reset", bytes.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+
+			err := server.ResetPassword(c)
+
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+				if tc.expectedMessage != "" {
+					assert.Equal(t, tc.expectedMessage, httpErr.Message)
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+			}
+		})
+	}
+}
+
+func TestRequestPhoneVerification(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	var sent bytes.Buffer
+	codeHasher, _ := utils.NewCodeHasher(bytes.Repeat([]byte{1}, 32))
+	server := &Server{
+		Repository: mockRepository,
+		Notifier:   notifier.NewLogNotifier(&sent),
+		CodeHasher: codeHasher,
+	}
+	verifiedAt := time.Now().Add(-time.Hour)
+	pendingPhone := "+62812345679"
+	tests := []struct {
+		name          string
+		requestBody   map[string]string
+		principal     *middleware.Principal
+		user          *repository.User
+		sendErr       error
+		expectedPhone string // Number the code is sent to, empty if none
+		expectedCode  int
+		expectedError bool
+	}{
+		{
+			name:          "Unverified Account Without Token",
+			requestBody:   map[string]string{"phone_number": "+62812345678"},
+			user:          &repository.User{UserID: "mockUserID", PhoneNumber: "+62812345678"},
+			expectedPhone: "+62812345678",
+			expectedCode:  http.StatusAccepted,
+		},
+		{
+			name:          "Queue Full Without Token",
+			requestBody:   map[string]string{"phone_number": "+62812345678"},
+			user:          &repository.User{UserID: "mockUserID", PhoneNumber: "+62812345678"},
+			sendErr:       notifier.ErrQueueFull,
+			expectedPhone: "+62812345678",
+			expectedCode:  http.StatusAccepted,
+		},
+		{
+			name:         "Verified Account Without Token",
+			requestBody:  map[string]string{"phone_number": "+62812345678"},
+			user:         &repository.User{UserID: "mockUserID", PhoneNumber: "+62812345678", PhoneVerifiedAt: &verifiedAt},
+			expectedCode: http.StatusAccepted,
+		},
+		{
+			name:         "Unregistered Phone Number Without Token",
+			requestBody:  map[string]string{"phone_number": "+62812345678"},
+			expectedCode: http.StatusAccepted,
+		},
+		{
+			name:          "Empty Phone Number Without Token",
+			requestBody:   map[string]string{},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name:        "Pending Phone Number With Token",
+			requestBody: map[string]string{},
+			principal:   &middleware.Principal{UserID: "mockUserID"},
+			user: &repository.User{UserID: "mockUserID", PhoneNumber: "+62812345678", PhoneVerifiedAt: &verifiedAt,
+				PendingPhoneNumber: &pendingPhone},
+			expectedPhone: pendingPhone,
+			expectedCode:  http.StatusAccepted,
+		},
+		{
+			name:          "Nothing To Verify With Token",
+			requestBody:   map[string]string{},
+			principal:     &middleware.Principal{UserID: "mockUserID"},
+			user:          &repository.User{UserID: "mockUserID", PhoneNumber: "+62812345678", PhoneVerifiedAt: &verifiedAt},
+			expectedCode:  http.StatusConflict,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sent.Reset()
+			server.Notifier = notifier.NewLogNotifier(&sent)
+			if tc.sendErr != nil {
+				server.Notifier = notifierFunc(func(context.Context, notifier.Message) error { return tc.sendErr })
+			}
+			if tc.principal != nil {
+				mockRepository.EXPECT().GetUserByUserId(gomock.Any(), tc.principal.UserID).Return(tc.user, nil)
+			} else if tc.requestBody["phone_number"] != "" {
+				if tc.user == nil {
+					mockRepository.EXPECT().CheckUser(gomock.Any(), tc.requestBody["phone_number"]).Return(nil,
+						&repository.Error{Op: "CheckUser", Kind: repository.ErrNotFound})
+				} else {
+					mockRepository.EXPECT().CheckUser(gomock.Any(), tc.requestBody["phone_number"]).Return(tc.user, nil)
+				}
+			}
+			var storedHash string
+			if tc.expectedPhone != "" {
+				mockRepository.EXPECT().CreatePhoneVerificationCode(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, code repository.PhoneVerificationCode) error {
+						assert.Equal(t, "mockUserID", code.UserID)
+						assert.Equal(t, tc.expectedPhone, code.PhoneNumber)
+						assert.True(t, code.ExpiresAt.After(time.Now()))
+						storedHash = code.CodeHash
+						return nil
+					})
+			}
+
+			reqBody, _ := json.Marshal(tc.requestBody)
+			req := httptest.NewRequest(http.MethodPost, "/phone/verify/request", bytes.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			if tc.principal != nil {
+				middleware.SetPrincipal(c, tc.principal)
+			}
+
+			err := server.RequestPhoneVerification(c)
+
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+				if tc.sendErr != nil {
+					assert.Empty(t, sent.String())
+				} else if tc.expectedPhone != "" {
+					assert.Contains(t, sent.String(), "to="+tc.expectedPhone)
+					// The code is stored as its keyed hash
+					code := regexp.MustCompile(`\d{6}`).FindString(strings.SplitN(sent.String(), "body=", 2)[1])
+					assert.Equal(t, codeHasher.Hash(code), storedHash)
+				} else {
+					assert.Empty(t, sent.String())
+				}
+			}
+		})
+	}
+}
+
+// notifierFunc is a notifier.Notifier that calls itself.
+type notifierFunc func(context.Context, notifier.Message) error
+
+func (f notifierFunc) Send(ctx context.Context, msg notifier.Message) error {
+	return f(ctx, msg)
+}
+
+func TestRequestPhoneVerificationQueueFull(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository: mockRepository,
+		Notifier: notifierFunc(func(context.Context, notifier.Message) error {
+			return notifier.ErrQueueFull
+		}),
+	}
+
+	mockRepository.EXPECT().GetUserByUserId(gomock.Any(), "mockUserID").Return(
+		&repository.User{UserID: "mockUserID", PhoneNumber: "+62812345678"}, nil)
+	mockRepository.EXPECT().CreatePhoneVerificationCode(gomock.Any(), gomock.Any()).Return(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/phone/verify/request", strings.NewReader("{}"))
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	middleware.SetPrincipal(c, &middleware.Principal{UserID: "mockUserID"})
+
+	// A full queue is a passing shortage, so the client is told to retry
+	err := server.RequestPhoneVerification(c)
+	httpErr, ok := err.(*echo.HTTPError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusServiceUnavailable, httpErr.Code)
+	assert.ErrorIs(t, httpErr, notifier.ErrQueueFull)
+}
+
+func TestConfirmPhoneVerification(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository: mockRepository,
+	}
+	codeHash := server.codeHasher().Hash("123456")
+	verifiedAt := time.Now().Add(-time.Hour)
+	pendingPhone := "+62812345679"
+	tests := []struct {
+		name           string
+		requestBody    map[string]string
+		principal      *middleware.Principal
+		user           *repository.User
+		verifyPhone    string // Phone number the code is looked up for
+		isAttemptFree  bool
+		phoneTaken     bool
+		isProceedCheck bool
+		expectedCode   int
+		expectedError  bool
+	}{
+		{
+			name:           "Registered Phone Number Without Token",
+			requestBody:    map[string]string{"phone_number": "0812345678", "code": "123456"},
+			user:           &repository.User{UserID: "mockUserID", PhoneNumber: "+62812345678"},
+			verifyPhone:    "+62812345678",
+			isAttemptFree:  true,
+			isProceedCheck: true,
+			expectedCode:   http.StatusOK,
+		},
+		{
+			name: "Pending Phone Number With Token",
+			requestBody: map[string]string{
+				"code": "123456",
+			},
+			principal: &middleware.Principal{UserID: "mockUserID"},
+			user: &repository.User{UserID: "mockUserID", PhoneNumber: "+62812345678", PhoneVerifiedAt: &verifiedAt,
+				PendingPhoneNumber: &pendingPhone},
+			verifyPhone:    pendingPhone,
+			isAttemptFree:  true,
+			isProceedCheck: true,
+			expectedCode:   http.StatusOK,
+		},
+		{
+			name: "Pending Phone Number Taken Meanwhile",
+			requestBody: map[string]string{
+				"code": "123456",
+			},
+			principal: &middleware.Principal{UserID: "mockUserID"},
+			user: &repository.User{UserID: "mockUserID", PhoneNumber: "+62812345678", PhoneVerifiedAt: &verifiedAt,
+				PendingPhoneNumber: &pendingPhone},
+			verifyPhone:   pendingPhone,
+			isAttemptFree: true,
+			phoneTaken:    true,
+			expectedCode:  http.StatusConflict,
+			expectedError: true,
+		},
+		{
+			name:          "Wrong Code",
+			requestBody:   map[string]string{"phone_number": "+62812345678", "code": "654321"},
+			user:          &repository.User{UserID: "mockUserID", PhoneNumber: "+62812345678"},
+			verifyPhone:   "+62812345678",
+			isAttemptFree: true,
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name:          "Too Many Attempts",
+			requestBody:   map[string]string{"phone_number": "+62812345678", "code": "123456"},
+			user:          &repository.User{UserID: "mockUserID", PhoneNumber: "+62812345678"},
+			verifyPhone:   "+62812345678",
+			isAttemptFree: false,
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name:          "Already Verified Without Token",
+			requestBody:   map[string]string{"phone_number": "+62812345678", "code": "123456"},
+			user:          &repository.User{UserID: "mockUserID", PhoneNumber: "+62812345678", PhoneVerifiedAt: &verifiedAt},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name:          "Empty Code",
+			requestBody:   map[string]string{"phone_number": "+62812345678"},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server.PhoneRegion = "ID"
+			if tc.principal != nil {
+				mockRepository.EXPECT().GetUserByUserId(gomock.Any(), tc.principal.UserID).Return(tc.user, nil)
+			} else if tc.user != nil {
+				mockRepository.EXPECT().CheckUser(gomock.Any(), tc.user.PhoneNumber).Return(tc.user, nil)
+			}
+			if tc.verifyPhone != "" {
+				mockRepository.EXPECT().GetActivePhoneVerificationCode(gomock.Any(), "mockUserID", tc.verifyPhone, gomock.Any()).
+					Return(&repository.PhoneVerificationCode{ID: 1, UserID: "mockUserID", CodeHash: codeHash}, nil)
+				mockRepository.EXPECT().ReservePhoneVerificationAttempt(gomock.Any(), 1, DefaultPhoneVerificationOptions.MaxAttempts).
+					Return(tc.isAttemptFree, nil)
+			}
+			if tc.isAttemptFree && tc.requestBody["code"] == "123456" && tc.verifyPhone != tc.user.PhoneNumber {
+				taken := int64(0)
+				if tc.phoneTaken {
+					taken = 1
+				}
+				mockRepository.EXPECT().CheckPhoneNumber(gomock.Any(), tc.verifyPhone).Return(taken, nil)
+			}
+			if tc.isProceedCheck {
+				mockRepository.EXPECT().UsePhoneVerificationCode(gomock.Any(), 1, gomock.Any()).Return(true, nil)
+				mockRepository.EXPECT().VerifyPhoneNumber(gomock.Any(), "mockUserID", tc.verifyPhone, gomock.Any()).Return(nil)
+			}
+
+			reqBody, _ := json.Marshal(tc.requestBody)
+			req := httptest.NewRequest(http.MethodPost, "/phone/verify/confirm", bytes.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			if tc.principal != nil {
+				middleware.SetPrincipal(c, tc.principal)
+			}
+
+			err := server.ConfirmPhoneVerification(c)
+
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+				if tc.phoneTaken {
+					// The same message as /register and PUT /user
+					assert.Equal(t, "Phone number already existed", httpErr.Message)
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+			}
+		})
+	}
+}
+
+func newTestSecretBox(t *testing.T) *utils.SecretBox {
+	box, err := utils.NewSecretBox(bytes.Repeat([]byte{7}, 32))
+	assert.NoError(t, err)
+	return box
+}
+
+func TestLoginUserRequiresMFA(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository: mockRepository,
+		KeyRing:    newTestKeyRing(t),
+	}
+	hashedPassword, _ := utils.HashPassword("password")
+	enabledAt := time.Now().Add(-time.Hour)
+	user := &repository.User{
+		UserID:          "mockUserID",
+		PhoneNumber:     "+62234567890",
+		Password:        hashedPassword,
+		PhoneVerifiedAt: &enabledAt,
+		TOTPEnabledAt:   &enabledAt,
+	}
+
+	// Neither a successful login nor a reset of the failed attempts is stored
+	// before the second factor is checked, only the lock is lifted
+	mockRepository.EXPECT().CheckUser(gomock.Any(), "+62234567890").Return(user, nil)
+	mockRepository.EXPECT().ReserveLoginAttempt(gomock.Any(), gomock.Any()).Return(true, nil)
+	mockRepository.EXPECT().ClearLoginLock(gomock.Any(), user.ID).Return(nil)
+
+	reqBody, _ := json.Marshal(map[string]string{"phone_number": "+62234567890", "password": "password"})
+	req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(reqBody))
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	err := server.LoginUser(c)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var response map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	assert.Equal(t, true, response["mfa_required"])
+	assert.Nil(t, response["access_token"])
+	claims, err := utils.ValidateJWTToken(response["mfa_token"].(string), server.KeyRing, utils.DefaultJWTIssuer, utils.DefaultJWTAudience)
+	assert.NoError(t, err)
+	assert.Equal(t, utils.MFATokenType, claims.TokenType)
+	assert.Equal(t, "mockUserID", claims.UserID)
+}
+
+func TestLoginMFAAfterFailedAttempts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository:   mockRepository,
+		KeyRing:      newTestKeyRing(t),
+		SecretBox:    newTestSecretBox(t),
+		LoginLockout: LockoutOptions{MaxFailedAttempts: 5, Duration: 15 * time.Minute},
+	}
+	hashedPassword, _ := utils.HashPassword("password")
+	secret, _ := utils.GenerateTOTPSecret()
+	sealedSecret, _ := server.SecretBox.Seal(secret, "mockUserID")
+	enabledAt := time.Now().Add(-time.Hour)
+	user := &repository.User{
+		ID:              1,
+		UserID:          "mockUserID",
+		PhoneNumber:     "+62234567890",
+		Password:        hashedPassword,
+		PhoneVerifiedAt: &enabledAt,
+		TOTPSecret:      &sealedSecret,
+		TOTPEnabledAt:   &enabledAt,
+	}
+
+	// The lockout columns change the way the statements of the repository
+	// change them
+	stored := func(context.Context, string) (*repository.User, error) {
+		copied := *user
+		return &copied, nil
+	}
+	mockRepository.EXPECT().CheckUser(gomock.Any(), "+62234567890").DoAndReturn(stored).Times(5)
+	mockRepository.EXPECT().GetUserByUserId(gomock.Any(), "mockUserID").DoAndReturn(stored)
+	mockRepository.EXPECT().ReserveLoginAttempt(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, input repository.LoginAttemptInput) (bool, error) {
+			if user.LockedUntil != nil && user.LockedUntil.After(input.AttemptedAt) {
+				return false, nil
+			}
+			if user.LockedUntil != nil {
+				user.FailedLoginAttempts = 1
+			} else {
+				user.FailedLoginAttempts++
+			}
+			user.LockedUntil = nil
+			if user.FailedLoginAttempts >= int64(input.MaxFailedAttempts) {
+				user.LockedUntil = &input.LockedUntil
+			}
+			return true, nil
+		}).Times(6)
+	mockRepository.EXPECT().ClearLoginLock(gomock.Any(), 1).DoAndReturn(func(context.Context, int) error {
+		user.LockedUntil = nil
+		return nil
+	})
+	mockRepository.EXPECT().IsTokenRevoked(gomock.Any(), gomock.Any()).Return(false, nil)
+	mockRepository.EXPECT().UseTOTPStep(gomock.Any(), "mockUserID", gomock.Any()).Return(true, nil)
+	mockRepository.EXPECT().RevokeToken(gomock.Any(), gomock.Any()).Return(nil)
+	mockRepository.EXPECT().RecordLoginSuccess(gomock.Any(), 1, gomock.Any()).DoAndReturn(func(context.Context, int, time.Time) error {
+		user.FailedLoginAttempts = 0
+		user.LockedUntil = nil
+		return nil
+	})
+	mockRepository.EXPECT().CreateTokenFamily(gomock.Any(), gomock.Any()).Return(nil)
+	mockRepository.EXPECT().CreateRefreshToken(gomock.Any(), gomock.Any()).Return(nil)
+
+	login := func(password string) (*httptest.ResponseRecorder, error) {
+		reqBody, _ := json.Marshal(map[string]string{"phone_number": "+62234567890", "password": password})
+		req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(reqBody))
+		rec := httptest.NewRecorder()
+		return rec, server.LoginUser(echo.New().NewContext(req, rec))
+	}
+
+	for i := 0; i < 4; i++ {
+		_, err := login("wrong password")
+		httpErr, ok := err.(*echo.HTTPError)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusBadRequest, httpErr.Code)
+	}
+
+	// The right password on the attempt that reaches the limit still leaves
+	// the second factor one try
+	rec, err := login("password")
+	assert.NoError(t, err)
+	var response map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	mfaToken, _ := response["mfa_token"].(string)
+	assert.NotEmpty(t, mfaToken)
+
+	code, _ := utils.TOTPCode(secret, time.Now())
+	reqBody, _ := json.Marshal(map[string]string{"mfa_token": mfaToken, "code": code})
+	req := httptest.NewRequest(http.MethodPost, "/login/mfa", bytes.NewReader(reqBody))
+	rec = httptest.NewRecorder()
+	err = server.LoginMFA(echo.New().NewContext(req, rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Zero(t, user.FailedLoginAttempts)
+	assert.Nil(t, user.LockedUntil)
+}
+
+func TestLoginMFA(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository: mockRepository,
+		KeyRing:    newTestKeyRing(t),
+		SecretBox:  newTestSecretBox(t),
+	}
+	secret, _ := utils.GenerateTOTPSecret()
+	sealedSecret, _ := server.SecretBox.Seal(secret, "mockUserID")
+	code, _ := utils.TOTPCode(secret, time.Now())
+	mfaToken, _, _ := utils.GenerateMFAToken("mockUserID", server.KeyRing)
+	pair, _ := utils.GenerateTokenPair("mockUserID", "", utils.RoleUser, server.KeyRing)
+	accessToken := pair.AccessToken
+	enabledAt := time.Now().Add(-time.Hour)
+	lockedUntil := time.Now().Add(time.Minute)
+	tests := []struct {
+		name           string
+		requestBody    map[string]string
+		user           *repository.User
+		isTokenChecked bool
+		isTokenUsed    bool
+		isAttemptFree  bool
+		isCodeFresh    bool
+		isRecoveryCode bool
+		isProceedLogin bool
+		expectedCode   int
+		expectedError  bool
+	}{
+		{
+			name:           "TOTP Code",
+			requestBody:    map[string]string{"mfa_token": mfaToken, "code": code},
+			user:           &repository.User{UserID: "mockUserID", TOTPSecret: &sealedSecret, TOTPEnabledAt: &enabledAt},
+			isTokenChecked: true,
+			isAttemptFree:  true,
+			isCodeFresh:    true,
+			isProceedLogin: true,
+			expectedCode:   http.StatusOK,
+		},
+		{
+			name:           "Recovery Code",
+			requestBody:    map[string]string{"mfa_token": mfaToken, "recovery_code": "ABCDE-FGHJK"},
+			user:           &repository.User{UserID: "mockUserID", TOTPSecret: &sealedSecret, TOTPEnabledAt: &enabledAt},
+			isTokenChecked: true,
+			isAttemptFree:  true,
+			isCodeFresh:    true,
+			isRecoveryCode: true,
+			isProceedLogin: true,
+			expectedCode:   http.StatusOK,
+		},
+		{
+			name:           "Replayed TOTP Code",
+			requestBody:    map[string]string{"mfa_token": mfaToken, "code": code},
+			user:           &repository.User{UserID: "mockUserID", TOTPSecret: &sealedSecret, TOTPEnabledAt: &enabledAt},
+			isTokenChecked: true,
+			isAttemptFree:  true,
+			isCodeFresh:    false,
+			expectedCode:   http.StatusBadRequest,
+			expectedError:  true,
+		},
+		{
+			name:           "Wrong TOTP Code",
+			requestBody:    map[string]string{"mfa_token": mfaToken, "code": "abcdef"},
+			user:           &repository.User{UserID: "mockUserID", TOTPSecret: &sealedSecret, TOTPEnabledAt: &enabledAt},
+			isTokenChecked: true,
+			isAttemptFree:  true,
+			expectedCode:   http.StatusBadRequest,
+			expectedError:  true,
+		},
+		{
+			name:           "Account Locked",
+			requestBody:    map[string]string{"mfa_token": mfaToken, "code": code},
+			user:           &repository.User{UserID: "mockUserID", TOTPSecret: &sealedSecret, TOTPEnabledAt: &enabledAt, LockedUntil: &lockedUntil},
+			isTokenChecked: true,
+			expectedCode:   http.StatusLocked,
+			expectedError:  true,
+		},
+		{
+			name:           "MFA Token Already Used",
+			requestBody:    map[string]string{"mfa_token": mfaToken, "code": code},
+			isTokenChecked: true,
+			isTokenUsed:    true,
+			expectedCode:   http.StatusUnauthorized,
+			expectedError:  true,
+		},
+		{
+			name:          "Access Token Instead Of MFA Token",
+			requestBody:   map[string]string{"mfa_token": accessToken, "code": code},
+			expectedCode:  http.StatusUnauthorized,
+			expectedError: true,
+		},
+		{
+			name:          "No Code",
+			requestBody:   map[string]string{"mfa_token": mfaToken},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.isTokenChecked {
+				mockRepository.EXPECT().IsTokenRevoked(gomock.Any(), gomock.Any()).Return(tc.isTokenUsed, nil)
+			}
+			if tc.user != nil {
+				mockRepository.EXPECT().GetUserByUserId(gomock.Any(), "mockUserID").Return(tc.user, nil)
+			}
+			if tc.isAttemptFree {
+				mockRepository.EXPECT().ReserveLoginAttempt(gomock.Any(), gomock.Any()).Return(true, nil)
+			}
+			if tc.isRecoveryCode {
+				mockRepository.EXPECT().UseRecoveryCode(gomock.Any(), "mockUserID", utils.HashRecoveryCode("abcdefghjk"), gomock.Any()).
+					Return(tc.isCodeFresh, nil)
+			} else if tc.isAttemptFree && tc.requestBody["code"] == code {
+				mockRepository.EXPECT().UseTOTPStep(gomock.Any(), "mockUserID", gomock.Any()).Return(tc.isCodeFresh, nil)
+			}
+			if tc.isProceedLogin {
+				mockRepository.EXPECT().RevokeToken(gomock.Any(), gomock.Any()).Return(nil)
+				mockRepository.EXPECT().RecordLoginSuccess(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				mockRepository.EXPECT().CreateTokenFamily(gomock.Any(), gomock.Any()).Return(nil)
+				mockRepository.EXPECT().CreateRefreshToken(gomock.Any(), gomock.Any()).Return(nil)
+			}
+
+			reqBody, _ := json.Marshal(tc.requestBody)
+			req := httptest.NewRequest(http.MethodPost, "/login/mfa", bytes.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+
+			err := server.LoginMFA(c)
+
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+
+				var tokens map[string]string
+				json.Unmarshal(rec.Body.Bytes(), &tokens)
+				claims, err := utils.ValidateJWTToken(tokens["access_token"], server.KeyRing, utils.DefaultJWTIssuer, utils.DefaultJWTAudience)
+				assert.NoError(t, err)
+				assert.Equal(t, utils.AccessTokenType, claims.TokenType)
+			}
+		})
+	}
+}
+
+func TestEnrollTOTP(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	box := newTestSecretBox(t)
+	enabledAt := time.Now()
+	tests := []struct {
+		name          string
+		secretBox     *utils.SecretBox
+		user          *repository.User
+		expectedCode  int
+		expectedError bool
+	}{
+		{
+			name:         "Enrolled",
+			secretBox:    box,
+			user:         &repository.User{UserID: "mockUserID", PhoneNumber: "+62812345678"},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "Already Enabled",
+			secretBox:     box,
+			user:          &repository.User{UserID: "mockUserID", PhoneNumber: "+62812345678", TOTPEnabledAt: &enabledAt},
+			expectedCode:  http.StatusConflict,
+			expectedError: true,
+		},
+		{
+			name:          "Not Configured",
+			expectedCode:  http.StatusServiceUnavailable,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := &Server{
+				Repository: mockRepository,
+				SecretBox:  tc.secretBox,
+			}
+			var storedSecret string
+			if tc.user != nil {
+				mockRepository.EXPECT().GetUserByUserId(gomock.Any(), "mockUserID").Return(tc.user, nil)
+			}
+			if !tc.expectedError {
+				mockRepository.EXPECT().SetTOTPSecret(gomock.Any(), "mockUserID", gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, _ string, secret string, _ time.Time) error {
+						storedSecret = secret
+						return nil
+					})
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/user/mfa/totp", nil)
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			middleware.SetPrincipal(c, &middleware.Principal{UserID: "mockUserID"})
+
+			err := server.EnrollTOTP(c)
+
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+
+				var response map[string]string
+				json.Unmarshal(rec.Body.Bytes(), &response)
+				assert.NotEqual(t, response["secret"], storedSecret, "the secret is stored encrypted")
+				opened, err := box.Open(storedSecret, "mockUserID")
+				assert.NoError(t, err)
+				assert.Equal(t, response["secret"], opened)
+				assert.True(t, strings.HasPrefix(response["otpauth_uri"], "otpauth://totp/UserService%3A%2B62812345678?"))
+			}
+		})
+	}
+}
+
+func TestConfirmTOTP(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository: mockRepository,
+		SecretBox:  newTestSecretBox(t),
+	}
+	secret, _ := utils.GenerateTOTPSecret()
+	sealedSecret, _ := server.SecretBox.Seal(secret, "mockUserID")
+	code, _ := utils.TOTPCode(secret, time.Now())
+	enabledAt := time.Now()
+	tests := []struct {
+		name          string
+		code          string
+		user          *repository.User
+		expectedCode  int
+		expectedError bool
+	}{
+		{
+			name:         "Enabled",
+			code:         code,
+			user:         &repository.User{UserID: "mockUserID", TOTPSecret: &sealedSecret},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "Wrong Code",
+			code:          "abcdef",
+			user:          &repository.User{UserID: "mockUserID", TOTPSecret: &sealedSecret},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name:          "Not Enrolled",
+			code:          code,
+			user:          &repository.User{UserID: "mockUserID"},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name:          "Already Enabled",
+			code:          code,
+			user:          &repository.User{UserID: "mockUserID", TOTPSecret: &sealedSecret, TOTPEnabledAt: &enabledAt},
+			expectedCode:  http.StatusConflict,
+			expectedError: true,
+		},
+		{
+			name:          "Empty Code",
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var storedHashes []string
+			if tc.user != nil {
+				mockRepository.EXPECT().GetUserByUserId(gomock.Any(), "mockUserID").Return(tc.user, nil)
+			}
+			if !tc.expectedError {
+				mockRepository.EXPECT().EnableTOTP(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, input repository.EnableTOTPInput) error {
+						assert.Equal(t, "mockUserID", input.UserID)
+						assert.NotZero(t, input.Step)
+						storedHashes = input.RecoveryCodeHashes
+						return nil
+					})
+			}
+
+			reqBody, _ := json.Marshal(map[string]string{"code": tc.code})
+			req := httptest.NewRequest(http.MethodPost, "/user/mfa/totp/confirm", bytes.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			middleware.SetPrincipal(c, &middleware.Principal{UserID: "mockUserID"})
+
+			err := server.ConfirmTOTP(c)
+
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+
+				var response struct {
+					RecoveryCodes []string `json:"recovery_codes"`
+				}
+				json.Unmarshal(rec.Body.Bytes(), &response)
+				assert.Len(t, response.RecoveryCodes, DefaultTOTPOptions.RecoveryCodes)
+				for i, recoveryCode := range response.RecoveryCodes {
+					assert.Equal(t, utils.HashRecoveryCode(recoveryCode), storedHashes[i], "only hashes are stored")
+				}
+			}
+		})
+	}
+}
+
+func TestLoginUserUnknownPhoneNumber(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository: mockRepository,
+	}
+
+	mockRepository.EXPECT().CheckUser(gomock.Any(), "+62234567890").Return(nil, &repository.Error{
+		Op:   "CheckUser",
+		Kind: repository.ErrNotFound,
+		Err:  errors.New("sql: no rows in result set"),
+	})
+
+	reqBody, _ := json.Marshal(map[string]string{
+		"phone_number": "+62234567890",
+		"password":     "password",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(reqBody))
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	// Answered like a wrong password, so it can't be told apart
+	err := server.LoginUser(c)
+	httpErr, ok := err.(*echo.HTTPError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusBadRequest, httpErr.Code)
+	assert.Equal(t, "Invalid Password", httpErr.Message)
+}
+
+func TestLoginUserStartsSession(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository: mockRepository,
+		KeyRing:    newTestKeyRing(t),
+	}
+	hashedPassword, _ := utils.HashPassword("password")
+	verifiedAt := time.Now().Add(-time.Hour)
+	user := &repository.User{
+		UserID:          "mockUserID",
+		PhoneNumber:     "+62234567890",
+		Password:        hashedPassword,
+		PhoneVerifiedAt: &verifiedAt,
+	}
+
+	mockRepository.EXPECT().CheckUser(gomock.Any(), "+62234567890").Return(user, nil)
+	mockRepository.EXPECT().ReserveLoginAttempt(gomock.Any(), gomock.Any()).Return(true, nil)
+	mockRepository.EXPECT().RecordLoginSuccess(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	mockRepository.EXPECT().CreateTokenFamily(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, family repository.TokenFamily) error {
+			assert.Equal(t, "mockUserID", family.UserID)
+			assert.Equal(t, "Pixel 8", family.DeviceName)
+			assert.Equal(t, "TestAgent/1.0", family.UserAgent)
+			assert.Equal(t, "203.0.113.7", family.IPAddress)
+			assert.Equal(t, family.CreatedAt, family.LastSeenAt)
+			return nil
+		})
+	mockRepository.EXPECT().CreateRefreshToken(gomock.Any(), gomock.Any()).Return(nil)
+
+	reqBody, _ := json.Marshal(map[string]string{
+		"phone_number": "+62234567890",
+		"password":     "password",
+		"device_name":  "  Pixel 8 ",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(reqBody))
+	req.Header.Set("User-Agent", "TestAgent/1.0")
+	req.RemoteAddr = "203.0.113.7:54321"
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	err := server.LoginUser(c)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestGetSessions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository: mockRepository,
+	}
+	now := time.Now().Truncate(time.Second)
+	families := []repository.TokenFamily{
+		{FamilyID: "currentFamilyID", UserID: "mockUserID", DeviceName: "Pixel 8", UserAgent: "TestAgent/1.0",
+			IPAddress: "203.0.113.7", LastSeenAt: now, CreatedAt: now.Add(-time.Hour)},
+		{FamilyID: "otherFamilyID", UserID: "mockUserID", UserAgent: "OtherAgent/2.0",
+			IPAddress: "198.51.100.1", LastSeenAt: now.Add(-time.Hour), CreatedAt: now.Add(-24 * time.Hour)},
+	}
+
+	mockRepository.EXPECT().GetActiveTokenFamilies(gomock.Any(), "mockUserID", gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ string, activeSince time.Time) ([]repository.TokenFamily, error) {
+			assert.WithinDuration(t, time.Now().Add(-utils.RefreshTokenTTL), activeSince, time.Minute)
+			return families, nil
+		})
+
+	req := httptest.NewRequest(http.MethodGet, "/user/sessions", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	middleware.SetPrincipal(c, &middleware.Principal{UserID: "mockUserID", FamilyID: "currentFamilyID"})
+
+	err := server.GetSessions(c)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var response struct {
+		Sessions []struct {
+			ID         string    `json:"id"`
+			DeviceName string    `json:"device_name"`
+			IPAddress  string    `json:"ip_address"`
+			LastSeenAt time.Time `json:"last_seen_at"`
+			Current    bool      `json:"current"`
+		} `json:"sessions"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Len(t, response.Sessions, 2)
+	assert.Equal(t, "currentFamilyID", response.Sessions[0].ID)
+	assert.Equal(t, "Pixel 8", response.Sessions[0].DeviceName)
+	assert.True(t, response.Sessions[0].LastSeenAt.Equal(now))
+	assert.True(t, response.Sessions[0].Current)
+	assert.Equal(t, "otherFamilyID", response.Sessions[1].ID)
+	assert.Equal(t, "198.51.100.1", response.Sessions[1].IPAddress)
+	assert.False(t, response.Sessions[1].Current)
+}
+
+func TestRevokeSession(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	revocationCache := middleware.NewRevocationCache(mockRepository, time.Minute)
+	server := &Server{
+		Repository:      mockRepository,
+		RevocationCache: revocationCache,
+	}
+	tests := []struct {
+		name          string
+		sessionID     string
+		isRevoked     bool
+		expectedCode  int
+		expectedError bool
+	}{
+		{
+			name:         "Revoked",
+			sessionID:    "otherFamilyID",
+			isRevoked:    true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "Unknown Or Someone Else's Session",
+			sessionID:     "unknownFamilyID",
+			isRevoked:     false,
+			expectedCode:  http.StatusNotFound,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepository.EXPECT().RevokeUserTokenFamily(gomock.Any(), "mockUserID", tc.sessionID, gomock.Any()).
+				Return(tc.isRevoked, nil)
+
+			req := httptest.NewRequest(http.MethodDelete, "/user/sessions/"+tc.sessionID, nil)
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			middleware.SetPrincipal(c, &middleware.Principal{UserID: "mockUserID", FamilyID: "currentFamilyID"})
+
+			err := server.RevokeSession(c, tc.sessionID)
+
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+
+				// The access tokens of the session are rejected right away,
+				// without asking the database
+				mockRepository.EXPECT().GetTokensValidAfter(gomock.Any(), "mockUserID").Return(nil, nil)
+				claims := &utils.JWTClaims{UserID: "mockUserID", FamilyID: tc.sessionID}
+				revoked, err := revocationCache.IsRevoked(context.Background(), claims)
+				assert.NoError(t, err)
+				assert.True(t, revoked)
+			}
+		})
+	}
+}
+
+func TestLoginUserAuditEvents(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository:  mockRepository,
+		KeyRing:     newTestKeyRing(t),
+		AuditLogger: audit.NewDatabaseLogger(mockRepository),
+	}
+	hashedPassword, _ := utils.HashPassword("password")
+	verifiedAt := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name           string
+		password       string
+		expectedCode   int
+		expectedEvent  string
+		expectedReason string
+	}{
+		{
+			name:           "Successful Login",
+			password:       "password",
+			expectedCode:   http.StatusOK,
+			expectedEvent:  audit.EventLoginSucceeded,
+			expectedReason: "password",
+		},
+		{
+			name:           "Wrong Password",
+			password:       "wrongPassword",
+			expectedCode:   http.StatusBadRequest,
+			expectedEvent:  audit.EventLoginFailed,
+			expectedReason: "invalid_password",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			user := &repository.User{
+				UserID:          "mockUserID",
+				PhoneNumber:     "+62234567890",
+				Password:        hashedPassword,
+				PhoneVerifiedAt: &verifiedAt,
+			}
+			mockRepository.EXPECT().CheckUser(gomock.Any(), "+62234567890").Return(user, nil)
+			mockRepository.EXPECT().ReserveLoginAttempt(gomock.Any(), gomock.Any()).Return(true, nil)
+			if tc.expectedCode == http.StatusOK {
+				mockRepository.EXPECT().RecordLoginSuccess(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				mockRepository.EXPECT().CreateTokenFamily(gomock.Any(), gomock.Any()).Return(nil)
+				mockRepository.EXPECT().CreateRefreshToken(gomock.Any(), gomock.Any()).Return(nil)
+			}
+			mockRepository.EXPECT().CreateAuditEvent(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, event repository.AuditEvent) error {
+					assert.Equal(t, "mockUserID", event.UserID)
+					assert.Nil(t, event.ActorID)
+					assert.Equal(t, tc.expectedEvent, event.EventType)
+					assert.Equal(t, tc.expectedReason, event.Reason)
+					assert.Equal(t, "203.0.113.7", event.IPAddress)
+					assert.Equal(t, "TestAgent/1.0", event.UserAgent)
+					assert.Nil(t, event.Changes)
+					return nil
+				})
+
+			reqBody, _ := json.Marshal(map[string]string{
+				"phone_number": "+62234567890",
+				"password":     tc.password,
+			})
+			req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(reqBody))
+			req.Header.Set("User-Agent", "TestAgent/1.0")
+			req.RemoteAddr = "203.0.113.7:54321"
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+
+			err := server.LoginUser(c)
+			if tc.expectedCode == http.StatusOK {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+			} else {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			}
+		})
+	}
+}
+
+func TestUpdateProfileAuditEvent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository:  mockRepository,
+		AuditLogger: audit.NewDatabaseLogger(mockRepository),
+	}
+
+	mockRepository.EXPECT().GetUserByUserId(gomock.Any(), "mockUserID").Return(&repository.User{
+		UserID:      "mockUserID",
+		FullName:    "Old Name",
+		PhoneNumber: "+62234567890",
+	}, nil)
+	mockRepository.EXPECT().CheckPhoneNumber(gomock.Any(), "+62234567891").Return(int64(0), nil)
+	mockRepository.EXPECT().UpdateUserProfile(gomock.Any(), gomock.Any()).Return(nil)
+	mockRepository.EXPECT().CreateAuditEvent(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, event repository.AuditEvent) error {
+			assert.Equal(t, audit.EventProfileUpdated, event.EventType)
+			assert.Equal(t, "mockUserID", *event.ActorID)
+			assert.JSONEq(t, `{
+				"full_name": {"from": "Old Name", "to": "John Doe"},
+				"pending_phone_number": {"from": null, "to": "+62234567891"}
+			}`, string(event.Changes))
+			return nil
+		})
+
+	reqBody, _ := json.Marshal(map[string]string{
+		"full_name":    "John Doe",
+		"phone_number": "+62234567891",
+	})
+	req := httptest.NewRequest(http.MethodPut, "/user", bytes.NewReader(reqBody))
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	middleware.SetPrincipal(c, &middleware.Principal{UserID: "mockUserID"})
+
+	err := server.UpdateProfile(c)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+}
+
+func TestGetActivity(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository: mockRepository,
+	}
+	now := time.Now().Truncate(time.Second)
+	actorID := "mockUserID"
+	auditEvents := []repository.AuditEvent{
+		{ID: 12, UserID: "mockUserID", ActorID: &actorID, EventType: audit.EventProfileUpdated,
+			IPAddress: "203.0.113.7", Changes: []byte(`{"full_name": {"from": "Old Name", "to": "John Doe"}}`),
+			CreatedAt: now},
+		{ID: 11, UserID: "mockUserID", EventType: audit.EventLoginFailed, Reason: "invalid_password",
+			IPAddress: "198.51.100.1", CreatedAt: now.Add(-time.Hour)},
+	}
+	limit, largeLimit, before := 10, 1000, int64(13)
+	tests := []struct {
+		name           string
+		params         generated.GetActivityParams
+		limit          int
+		before         int64
+		isQuery        bool
+		expectedCode   int
+		expectedError  bool
+		expectedEvents int
+	}{
+		{
+			name:           "Default Page",
+			limit:          defaultActivityLimit,
+			isQuery:        true,
+			expectedCode:   http.StatusOK,
+			expectedEvents: 2,
+		},
+		{
+			name:           "Next Page",
+			params:         generated.GetActivityParams{Limit: &limit, Before: &before},
+			limit:          10,
+			before:         13,
+			isQuery:        true,
+			expectedCode:   http.StatusOK,
+			expectedEvents: 2,
+		},
+		{
+			name:          "Limit Too Large",
+			params:        generated.GetActivityParams{Limit: &largeLimit},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.isQuery {
+				mockRepository.EXPECT().GetAuditEventsByUserId(gomock.Any(), "mockUserID", tc.before, tc.limit).
+					Return(auditEvents, nil)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/user/activity", nil)
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			middleware.SetPrincipal(c, &middleware.Principal{UserID: "mockUserID"})
+
+			err := server.GetActivity(c, tc.params)
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedCode, rec.Code)
+
+			var response struct {
+				Events []struct {
+					ID      int64   `json:"id"`
+					Type    string  `json:"type"`
+					ActorID *string `json:"actor_id"`
+					Reason  string  `json:"reason"`
+					Changes map[string]struct {
+						From interface{} `json:"from"`
+						To   interface{} `json:"to"`
+					} `json:"changes"`
+				} `json:"events"`
+			}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+			assert.Len(t, response.Events, tc.expectedEvents)
+			assert.Equal(t, int64(12), response.Events[0].ID)
+			assert.Equal(t, "mockUserID", *response.Events[0].ActorID)
+			assert.Equal(t, "John Doe", response.Events[0].Changes["full_name"].To)
+			assert.Equal(t, audit.EventLoginFailed, response.Events[1].Type)
+			assert.Equal(t, "invalid_password", response.Events[1].Reason)
+			assert.Nil(t, response.Events[1].ActorID)
+			assert.Nil(t, response.Events[1].Changes)
+		})
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository:      mockRepository,
+		AccountDeletion: AccountDeletionOptions{GracePeriod: time.Hour},
+	}
+	hashedPassword, _ := utils.HashPassword("password")
+	tests := []struct {
+		name          string
+		requestBody   map[string]string
+		isUserLoaded  bool
+		isLocked      bool
+		isDeleted     bool
+		deleteErr     error
+		expectedCode  int
+		expectedError bool
+	}{
+		{
+			name:         "Successful Deletion",
+			requestBody:  map[string]string{"password": "password"},
+			isUserLoaded: true,
+			isDeleted:    true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "Empty Password",
+			requestBody:   map[string]string{"password": ""},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name:          "Wrong Password",
+			requestBody:   map[string]string{"password": "wrongpassword"},
+			isUserLoaded:  true,
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name:          "Too Many Attempts",
+			requestBody:   map[string]string{"password": "wrongpassword"},
+			isUserLoaded:  true,
+			isLocked:      true,
+			expectedCode:  http.StatusLocked,
+			expectedError: true,
+		},
+		{
+			name:          "Already Deleted",
+			requestBody:   map[string]string{"password": "password"},
+			isUserLoaded:  true,
+			isDeleted:     true,
+			deleteErr:     &repository.Error{Op: "DeleteUser", Kind: repository.ErrNotFound},
+			expectedCode:  http.StatusNotFound,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.isUserLoaded {
+				mockRepository.EXPECT().GetUserByUserId(gomock.Any(), "mockUserID").Return(&repository.User{
+					ID:       1,
+					UserID:   "mockUserID",
+					Password: hashedPassword,
+				}, nil)
+				mockRepository.EXPECT().ReserveLoginAttempt(gomock.Any(), gomock.Any()).Return(!tc.isLocked, nil)
+				if tc.isLocked {
+					storedLock := time.Now().Add(time.Minute)
+					mockRepository.EXPECT().GetUserByUserId(gomock.Any(), "mockUserID").Return(&repository.User{
+						ID:          1,
+						UserID:      "mockUserID",
+						LockedUntil: &storedLock,
+					}, nil)
+				}
+			}
+			if tc.isDeleted {
+				mockRepository.EXPECT().DeleteUser(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, input repository.DeleteUserInput) error {
+						assert.Equal(t, "mockUserID", input.UserID)
+						assert.Equal(t, input.DeletedAt.Add(time.Hour), input.PurgeAfter)
+						assert.Equal(t, input.DeletedAt.Truncate(time.Second), input.TokensValidAfter)
+						return tc.deleteErr
+					})
+			}
+
+			reqBody, _ := json.Marshal(tc.requestBody)
+			req := httptest.NewRequest(http.MethodDelete, "/user", bytes.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			middleware.SetPrincipal(c, &middleware.Principal{UserID: "mockUserID"})
+
+			err := server.DeleteAccount(c)
+
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+			}
+		})
+	}
+}
+
+func TestExportUserData(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{
+		Repository: mockRepository,
+	}
+	now := time.Now().Truncate(time.Second)
+	mockRepository.EXPECT().GetUserByUserId(gomock.Any(), "mockUserID").Return(&repository.User{
+		UserID:        "mockUserID",
+		FullName:      "John Doe",
+		PhoneNumber:   "+62812345678",
+		Password:      "mockHash",
+		TOTPEnabledAt: &now,
+		CreatedAt:     now,
+	}, nil)
+	mockRepository.EXPECT().GetTokenFamiliesByUserId(gomock.Any(), "mockUserID").Return([]repository.TokenFamily{
+		{FamilyID: "currentFamily", UserID: "mockUserID", LastSeenAt: now, CreatedAt: now},
+		{FamilyID: "revokedFamily", UserID: "mockUserID", RevokedAt: &now, LastSeenAt: now, CreatedAt: now},
+	}, nil)
+
+	// A full first page makes the handler ask for the next one
+	firstPage := make([]repository.AuditEvent, maxActivityLimit)
+	for i := range firstPage {
+		firstPage[i] = repository.AuditEvent{ID: int64(maxActivityLimit + 1 - i), UserID: "mockUserID",
+			EventType: audit.EventLoginSucceeded, CreatedAt: now}
+	}
+	gomock.InOrder(
+		mockRepository.EXPECT().GetAuditEventsByUserId(gomock.Any(), "mockUserID", int64(0), maxActivityLimit).
+			Return(firstPage, nil),
+		mockRepository.EXPECT().GetAuditEventsByUserId(gomock.Any(), "mockUserID", int64(2), maxActivityLimit).
+			Return([]repository.AuditEvent{{ID: 1, UserID: "mockUserID", EventType: audit.EventRegister, CreatedAt: now}}, nil),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/user/export", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	middleware.SetPrincipal(c, &middleware.Principal{UserID: "mockUserID", FamilyID: "currentFamily"})
+
+	err := server.ExportUserData(c)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get(echo.HeaderContentDisposition), "attachment")
+	assert.NotContains(t, rec.Body.String(), "mockHash")
+
+	var export generated.ExportResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &export))
+	assert.Equal(t, "John Doe", export.Profile.FullName)
+	assert.True(t, export.Profile.TwoFactorEnabled)
+	assert.Len(t, export.Sessions, 2)
+	assert.True(t, export.Sessions[0].Current)
+	assert.Nil(t, export.Sessions[0].RevokedAt)
+	assert.NotNil(t, export.Sessions[1].RevokedAt)
+	assert.Len(t, export.Events, maxActivityLimit+1)
+	assert.Equal(t, audit.EventRegister, export.Events[maxActivityLimit].Type)
+}
+
+func TestListUsers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{Repository: mockRepository}
+	limit := func(n int) *int { return &n }
+	createdAt := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	afterCursor := encodeUserListCursor(userListCursor{Sort: repository.SortByCreatedAt, Descending: true,
+		Value: createdAt.Format(time.RFC3339Nano), ID: 7})
+	beforeCursor := encodeUserListCursor(userListCursor{Sort: repository.SortByFullName, Before: true, Value: "Mock", ID: 7})
+	sortByName := generated.FullName
+	descending := generated.Desc
+	from, to := createdAt, createdAt.Add(-time.Hour)
+	invalidCursor := "not-a-cursor"
+	tamperedCursor := encodeUserListCursor(userListCursor{Sort: repository.SortByCreatedAt, Value: "yesterday"})
+	namePrefix, phonePrefix := " moc ", "+6281"
+	disabled, banned := generated.Disabled, generated.ListUsersParamsStatus("banned")
+	tests := []struct {
+		name          string
+		params        generated.ListUsersParams
+		expectedInput repository.ListUsersInput
+		hasMore       bool
+		isListed      bool
+		expectNext    bool
+		expectPrev    bool
+		expectedCode  int
+		expectedError bool
+	}{
+		{
+			name:          "First Page",
+			expectedInput: repository.ListUsersInput{Limit: defaultUserListLimit, Sort: repository.SortByCreatedAt, Descending: true},
+			hasMore:       true,
+			isListed:      true,
+			expectNext:    true,
+			expectedCode:  http.StatusOK,
+		},
+		{
+			name:          "Last Page",
+			expectedInput: repository.ListUsersInput{Limit: defaultUserListLimit, Sort: repository.SortByCreatedAt, Descending: true},
+			isListed:      true,
+			expectedCode:  http.StatusOK,
+		},
+		{
+			name:   "Next Page",
+			params: generated.ListUsersParams{Limit: limit(10), Cursor: &afterCursor},
+			expectedInput: repository.ListUsersInput{Limit: 10, Sort: repository.SortByCreatedAt, Descending: true,
+				After: &repository.UserCursor{Value: createdAt.Format(time.RFC3339Nano), ID: 7}},
+			hasMore:      true,
+			isListed:     true,
+			expectNext:   true,
+			expectPrev:   true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:   "Previous Page By Name",
+			params: generated.ListUsersParams{Cursor: &beforeCursor},
+			expectedInput: repository.ListUsersInput{Limit: defaultUserListLimit, Sort: repository.SortByFullName,
+				Before: &repository.UserCursor{Value: "Mock", ID: 7}},
+			isListed:     true,
+			expectNext:   true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name: "Filters",
+			params: generated.ListUsersParams{Sort: &sortByName, NamePrefix: &namePrefix, PhonePrefix: &phonePrefix,
+				Status: &disabled, CreatedFrom: &to, CreatedTo: &from},
+			expectedInput: repository.ListUsersInput{Limit: defaultUserListLimit, Sort: repository.SortByFullName,
+				CreatedFrom: &to, CreatedTo: &from, NamePrefix: "moc", PhonePrefix: "+6281", Status: repository.UserStatusDisabled},
+			isListed:     true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "Limit Too Large",
+			params:        generated.ListUsersParams{Limit: limit(maxUserListLimit + 1)},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name:          "Invalid Cursor",
+			params:        generated.ListUsersParams{Cursor: &invalidCursor},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name:          "Tampered Cursor",
+			params:        generated.ListUsersParams{Cursor: &tamperedCursor},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name:          "Cursor Of Another Order",
+			params:        generated.ListUsersParams{Cursor: &beforeCursor, Order: &descending},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name:          "Empty Range",
+			params:        generated.ListUsersParams{CreatedFrom: &from, CreatedTo: &to},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name:          "Unknown Status",
+			params:        generated.ListUsersParams{Status: &banned},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.isListed {
+				mockRepository.EXPECT().ListUsers(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, input repository.ListUsersInput) (*repository.UserPage, error) {
+						assert.False(t, input.Now.IsZero())
+						input.Now = time.Time{}
+						assert.Equal(t, tc.expectedInput, input)
+						return &repository.UserPage{
+							Users:   []repository.User{{ID: 7, UserID: "mockUserID", FullName: "Mock User", Role: utils.RoleUser, CreatedAt: createdAt}},
+							HasMore: tc.hasMore,
+						}, nil
+					})
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			middleware.SetPrincipal(c, &middleware.Principal{UserID: "supportUserID", Role: utils.RoleSupport})
+
+			err := server.ListUsers(c, tc.params)
+
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+				var response generated.AdminUsersResponse
+				assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+				assert.Len(t, response.Users, 1)
+				assert.Equal(t, tc.expectNext, response.NextCursor != nil)
+				assert.Equal(t, tc.expectPrev, response.PrevCursor != nil)
+				if response.NextCursor != nil {
+					cursor, err := decodeUserListCursor(*response.NextCursor)
+					assert.NoError(t, err)
+					assert.Equal(t, tc.expectedInput.Sort, cursor.Sort)
+					assert.False(t, cursor.Before)
+					assert.Equal(t, 7, cursor.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{Repository: mockRepository, PhoneRegion: "ID"}
+	tests := []struct {
+		name               string
+		query              string
+		expectedInput      repository.UserSearchInput
+		match              repository.User
+		expectedHighlights []generated.Highlight
+		expectedCode       int
+		expectedError      bool
+	}{
+		{
+			name:          "Misspelled Name",
+			query:         " santosa ",
+			expectedInput: repository.UserSearchInput{Query: "santosa", Limit: defaultUserListLimit},
+			match:         repository.User{UserID: "mockUserID", FullName: "Budi Santoso", PhoneNumber: "+62812345678"},
+			expectedHighlights: []generated.Highlight{
+				{Field: "full_name", Start: 5, End: 12},
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "National Phone Number",
+			query:         "0812",
+			expectedInput: repository.UserSearchInput{Query: "0812", PhonePrefix: "+62812", Limit: defaultUserListLimit},
+			match:         repository.User{UserID: "mockUserID", FullName: "Budi Santoso", PhoneNumber: "+62812345678"},
+			expectedHighlights: []generated.Highlight{
+				{Field: "phone_number", Start: 0, End: 6},
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "Empty Query",
+			query:         "  ",
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if !tc.expectedError {
+				mockRepository.EXPECT().SearchUsers(gomock.Any(), tc.expectedInput).Return([]repository.UserMatch{
+					{User: tc.match, Rank: 0.5},
+				}, nil)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/admin/users/search", nil)
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			middleware.SetPrincipal(c, &middleware.Principal{UserID: "supportUserID", Role: utils.RoleSupport})
+
+			err := server.SearchUsers(c, generated.SearchUsersParams{Q: tc.query})
+
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+				var response generated.UserSearchResponse
+				assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+				assert.Len(t, response.Results, 1)
+				assert.Equal(t, 0.5, response.Results[0].Rank)
+				assert.Equal(t, tc.expectedHighlights, response.Results[0].Highlights)
+			}
+		})
+	}
+}
+
+func TestDisableUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{Repository: mockRepository}
+	tests := []struct {
+		name          string
+		callerRole    string
+		targetID      string
+		targetRole    string
+		getErr        error
+		isDisabled    bool
+		disableErr    error
+		expectedCode  int
+		expectedError bool
+	}{
+		{
+			name:         "Support Disables User",
+			callerRole:   utils.RoleSupport,
+			targetID:     "mockUserID",
+			targetRole:   utils.RoleUser,
+			isDisabled:   true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Admin Disables Support",
+			callerRole:   utils.RoleAdmin,
+			targetID:     "mockUserID",
+			targetRole:   utils.RoleSupport,
+			isDisabled:   true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "Support Cannot Disable Admin",
+			callerRole:    utils.RoleSupport,
+			targetID:      "mockUserID",
+			targetRole:    utils.RoleAdmin,
+			expectedCode:  http.StatusForbidden,
+			expectedError: true,
+		},
+		{
+			name:          "Cannot Disable Yourself",
+			callerRole:    utils.RoleAdmin,
+			targetID:      "callerUserID",
+			expectedCode:  http.StatusConflict,
+			expectedError: true,
+		},
+		{
+			name:          "User Not Found",
+			callerRole:    utils.RoleSupport,
+			targetID:      "mockUserID",
+			getErr:        &repository.Error{Op: "GetUserByUserId", Kind: repository.ErrNotFound},
+			expectedCode:  http.StatusNotFound,
+			expectedError: true,
+		},
+		{
+			name:          "Already Disabled",
+			callerRole:    utils.RoleSupport,
+			targetID:      "mockUserID",
+			targetRole:    utils.RoleUser,
+			isDisabled:    true,
+			disableErr:    &repository.Error{Op: "DisableUser", Kind: repository.ErrConflict},
+			expectedCode:  http.StatusConflict,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.targetRole != "" || tc.getErr != nil {
+				var user *repository.User
+				if tc.getErr == nil {
+					user = &repository.User{UserID: tc.targetID, Role: tc.targetRole}
+				}
+				mockRepository.EXPECT().GetUserByUserId(gomock.Any(), tc.targetID).Return(user, tc.getErr)
+			}
+			if tc.isDisabled {
+				mockRepository.EXPECT().DisableUser(gomock.Any(), tc.targetID, gomock.Any()).Return(tc.disableErr)
+				if tc.disableErr == nil {
+					mockRepository.EXPECT().RevokeAllUserTokens(gomock.Any(), tc.targetID, gomock.Any()).Return(nil)
+				}
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/users/"+tc.targetID+"/disable", nil)
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			middleware.SetPrincipal(c, &middleware.Principal{UserID: "callerUserID", Role: tc.callerRole})
+
+			err := server.DisableUser(c, tc.targetID)
+
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+			}
+		})
+	}
+}
+
+func TestEnableUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{Repository: mockRepository}
+	tests := []struct {
+		name          string
+		callerRole    string
+		targetRole    string
+		getErr        error
+		isEnabled     bool
+		enableErr     error
+		expectedCode  int
+		expectedError bool
+	}{
+		{
+			name:         "Support Enables User",
+			callerRole:   utils.RoleSupport,
+			targetRole:   utils.RoleUser,
+			isEnabled:    true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Admin Enables Support",
+			callerRole:   utils.RoleAdmin,
+			targetRole:   utils.RoleSupport,
+			isEnabled:    true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "Support Cannot Enable Admin",
+			callerRole:    utils.RoleSupport,
+			targetRole:    utils.RoleAdmin,
+			expectedCode:  http.StatusForbidden,
+			expectedError: true,
+		},
+		{
+			name:          "User Not Found",
+			callerRole:    utils.RoleSupport,
+			getErr:        &repository.Error{Op: "GetUserByUserId", Kind: repository.ErrNotFound},
+			expectedCode:  http.StatusNotFound,
+			expectedError: true,
+		},
+		{
+			name:          "Not Disabled",
+			callerRole:    utils.RoleSupport,
+			targetRole:    utils.RoleUser,
+			isEnabled:     true,
+			enableErr:     &repository.Error{Op: "EnableUser", Kind: repository.ErrConflict},
+			expectedCode:  http.StatusConflict,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var user *repository.User
+			if tc.getErr == nil {
+				user = &repository.User{UserID: "mockUserID", Role: tc.targetRole}
+			}
+			mockRepository.EXPECT().GetUserByUserId(gomock.Any(), "mockUserID").Return(user, tc.getErr)
+			if tc.isEnabled {
+				mockRepository.EXPECT().EnableUser(gomock.Any(), "mockUserID", gomock.Any()).Return(tc.enableErr)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/users/mockUserID/enable", nil)
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			middleware.SetPrincipal(c, &middleware.Principal{UserID: "callerUserID", Role: tc.callerRole})
+
+			err := server.EnableUser(c, "mockUserID")
+
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+			}
+		})
+	}
+}
+
+func TestLogoutUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{Repository: mockRepository}
+	tests := []struct {
+		name          string
+		callerRole    string
+		targetRole    string
+		getErr        error
+		isRevoked     bool
+		revokeErr     error
+		expectedCode  int
+		expectedError bool
+	}{
+		{
+			name:         "Support Logs Out User",
+			callerRole:   utils.RoleSupport,
+			targetRole:   utils.RoleUser,
+			isRevoked:    true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Admin Logs Out Support",
+			callerRole:   utils.RoleAdmin,
+			targetRole:   utils.RoleSupport,
+			isRevoked:    true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "Support Cannot Log Out Admin",
+			callerRole:    utils.RoleSupport,
+			targetRole:    utils.RoleAdmin,
+			expectedCode:  http.StatusForbidden,
+			expectedError: true,
+		},
+		{
+			name:          "User Not Found",
+			callerRole:    utils.RoleSupport,
+			getErr:        &repository.Error{Op: "GetUserByUserId", Kind: repository.ErrNotFound},
+			expectedCode:  http.StatusNotFound,
+			expectedError: true,
+		},
+		{
+			name:          "Database Unavailable",
+			callerRole:    utils.RoleSupport,
+			targetRole:    utils.RoleUser,
+			isRevoked:     true,
+			revokeErr:     &repository.Error{Op: "RevokeAllUserTokens", Kind: repository.ErrUnavailable},
+			expectedCode:  http.StatusServiceUnavailable,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var user *repository.User
+			if tc.getErr == nil {
+				user = &repository.User{UserID: "mockUserID", Role: tc.targetRole}
+			}
+			mockRepository.EXPECT().GetUserByUserId(gomock.Any(), "mockUserID").Return(user, tc.getErr)
+			if tc.isRevoked {
+				mockRepository.EXPECT().RevokeAllUserTokens(gomock.Any(), "mockUserID", gomock.Any()).Return(tc.revokeErr)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/users/mockUserID/logout", nil)
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			middleware.SetPrincipal(c, &middleware.Principal{UserID: "callerUserID", Role: tc.callerRole})
+
+			err := server.LogoutUser(c, "mockUserID")
+
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+			}
+		})
+	}
+}
+
+func TestAdminEndpointsRequireRole(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// No repository call is expected: the caller is refused first
+	server := &Server{Repository: repository.NewMockRepositoryInterface(ctrl)}
+	endpoints := map[string]func(echo.Context) error{
+		"ListUsers":   func(c echo.Context) error { return server.ListUsers(c, generated.ListUsersParams{}) },
+		"SearchUsers": func(c echo.Context) error { return server.SearchUsers(c, generated.SearchUsersParams{Q: "mock"}) },
+		"GetUser":     func(c echo.Context) error { return server.GetUser(c, "mockUserID") },
+		"UnlockUser":  func(c echo.Context) error { return server.UnlockUser(c, "mockUserID") },
+		"DisableUser": func(c echo.Context) error { return server.DisableUser(c, "mockUserID") },
+		"EnableUser":  func(c echo.Context) error { return server.EnableUser(c, "mockUserID") },
+		"LogoutUser":  func(c echo.Context) error { return server.LogoutUser(c, "mockUserID") },
+	}
+	tests := []struct {
+		name         string
+		principal    *middleware.Principal
+		expectedCode int
+	}{
+		{
+			name:         "No Principal",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "User Role",
+			principal:    &middleware.Principal{UserID: "callerUserID", Role: utils.RoleUser},
+			expectedCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range tests {
+		for name, endpoint := range endpoints {
+			t.Run(tc.name+" "+name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+				rec := httptest.NewRecorder()
+				c := echo.New().NewContext(req, rec)
+				if tc.principal != nil {
+					middleware.SetPrincipal(c, tc.principal)
+				}
+
+				err := endpoint(c)
+
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			})
+		}
+	}
+}
+
+func TestChangeUserRole(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepository := repository.NewMockRepositoryInterface(ctrl)
+	server := &Server{Repository: mockRepository}
+	tests := []struct {
+		name          string
+		callerRole    string
+		targetID      string
+		requestBody   map[string]string
+		isChanged     bool
+		expectedCode  int
+		expectedError bool
+	}{
+		{
+			name:         "Promote To Support",
+			targetID:     "mockUserID",
+			requestBody:  map[string]string{"role": utils.RoleSupport},
+			isChanged:    true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "Unknown Role",
+			targetID:      "mockUserID",
+			requestBody:   map[string]string{"role": "root"},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: true,
+		},
+		{
+			name:          "Cannot Change Own Role",
+			targetID:      "callerUserID",
+			requestBody:   map[string]string{"role": utils.RoleUser},
+			expectedCode:  http.StatusConflict,
+			expectedError: true,
+		},
+		{
+			name:          "Support Cannot Change Roles",
+			callerRole:    utils.RoleSupport,
+			targetID:      "mockUserID",
+			requestBody:   map[string]string{"role": utils.RoleSupport},
+			expectedCode:  http.StatusForbidden,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.isChanged {
+				mockRepository.EXPECT().GetUserByUserId(gomock.Any(), tc.targetID).Return(&repository.User{
+					UserID: tc.targetID,
+					Role:   utils.RoleUser,
+				}, nil)
+				mockRepository.EXPECT().SetUserRole(gomock.Any(), tc.targetID, tc.requestBody["role"], gomock.Any()).Return(nil)
+				mockRepository.EXPECT().RevokeAllUserTokens(gomock.Any(), tc.targetID, gomock.Any()).Return(nil)
+			}
+
+			reqBody, _ := json.Marshal(tc.requestBody)
+			req := httptest.NewRequest(http.MethodPut, "/admin/users/"+tc.targetID+"/role", bytes.NewReader(reqBody))
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			callerRole := utils.RoleAdmin
+			if tc.callerRole != "" {
+				callerRole = tc.callerRole
+			}
+			middleware.SetPrincipal(c, &middleware.Principal{UserID: "callerUserID", Role: callerRole})
+
+			err := server.ChangeUserRole(c, tc.targetID)
+
+			if tc.expectedError {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, rec.Code)
+			}
+		})
+	}
+}
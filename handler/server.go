@@ -1,15 +1,217 @@
 package handler
 
-import "github.com/SawitProRecruitment/UserService/repository"
+import (
+	"github.com/SawitProRecruitment/UserService/audit"
+	"github.com/SawitProRecruitment/UserService/middleware"
+	"github.com/SawitProRecruitment/UserService/notifier"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/SawitProRecruitment/UserService/utils"
+	"sync"
+	"time"
+)
 
 type Server struct {
-	Repository repository.RepositoryInterface
+	Repository        repository.RepositoryInterface
+	RevocationCache   *middleware.RevocationCache
+	KeyRing           *utils.KeyRing
+	LoginLockout      LockoutOptions
+	Notifier          notifier.Notifier
+	PasswordReset     PasswordResetOptions
+	PasswordPolicy    utils.PasswordPolicy
+	PasswordHasher    *utils.PasswordHasher
+	CodeHasher        *utils.CodeHasher
+	PhoneRegion       string
+	PhoneVerification PhoneVerificationOptions
+	SecretBox         *utils.SecretBox
+	TOTP              TOTPOptions
+	AuditLogger       audit.AuditLogger
+	AccountDeletion   AccountDeletionOptions
 }
 
 type NewServerOptions struct {
-	Repository repository.RepositoryInterface
+	Repository        repository.RepositoryInterface
+	RevocationCache   *middleware.RevocationCache
+	KeyRing           *utils.KeyRing
+	LoginLockout      LockoutOptions
+	Notifier          notifier.Notifier
+	PasswordReset     PasswordResetOptions
+	PasswordPolicy    utils.PasswordPolicy
+	PasswordHasher    *utils.PasswordHasher
+	CodeHasher        *utils.CodeHasher
+	PhoneRegion       string
+	PhoneVerification PhoneVerificationOptions
+	SecretBox         *utils.SecretBox
+	TOTP              TOTPOptions
+	AuditLogger       audit.AuditLogger
+	AccountDeletion   AccountDeletionOptions
 }
 
 func NewServer(opts NewServerOptions) *Server {
-	return &Server{Repository: opts.Repository}
+	return &Server{
+		Repository:        opts.Repository,
+		RevocationCache:   opts.RevocationCache,
+		KeyRing:           opts.KeyRing,
+		LoginLockout:      opts.LoginLockout,
+		Notifier:          opts.Notifier,
+		PasswordReset:     opts.PasswordReset,
+		PasswordPolicy:    opts.PasswordPolicy,
+		PasswordHasher:    opts.PasswordHasher,
+		CodeHasher:        opts.CodeHasher,
+		PhoneRegion:       opts.PhoneRegion,
+		PhoneVerification: opts.PhoneVerification,
+		SecretBox:         opts.SecretBox,
+		TOTP:              opts.TOTP,
+		AuditLogger:       opts.AuditLogger,
+		AccountDeletion:   opts.AccountDeletion,
+	}
+}
+
+// passwordHasher returns the configured hasher, or one with
+// utils.DefaultArgon2idParams when none is set.
+func (s *Server) passwordHasher() *utils.PasswordHasher {
+	if s.PasswordHasher == nil {
+		return utils.NewPasswordHasher(utils.DefaultArgon2idParams)
+	}
+	return s.PasswordHasher
+}
+
+// fallbackCodeHasher hashes one-time codes when no CodeHasher is set. Its key
+// is random, so codes only verify on the instance that sent them.
+var fallbackCodeHasher = sync.OnceValue(func() *utils.CodeHasher {
+	hasher, err := utils.NewRandomCodeHasher()
+	if err != nil {
+		panic(err)
+	}
+	return hasher
+})
+
+// codeHasher returns the configured hasher of one-time codes, or
+// fallbackCodeHasher when none is set.
+func (s *Server) codeHasher() *utils.CodeHasher {
+	if s.CodeHasher == nil {
+		return fallbackCodeHasher()
+	}
+	return s.CodeHasher
+}
+
+// LockoutOptions controls how many consecutive bad passwords lock an account
+// and for how long.
+type LockoutOptions struct {
+	MaxFailedAttempts int
+	Duration          time.Duration
+}
+
+var DefaultLockoutOptions = LockoutOptions{
+	MaxFailedAttempts: 5,
+	Duration:          15 * time.Minute,
+}
+
+func (o LockoutOptions) withDefaults() LockoutOptions {
+	if o.MaxFailedAttempts <= 0 {
+		o.MaxFailedAttempts = DefaultLockoutOptions.MaxFailedAttempts
+	}
+	if o.Duration <= 0 {
+		o.Duration = DefaultLockoutOptions.Duration
+	}
+	return o
+}
+
+// PasswordResetOptions controls how long a password reset code is valid and
+// how many wrong guesses it survives.
+type PasswordResetOptions struct {
+	CodeTTL     time.Duration
+	MaxAttempts int
+}
+
+var DefaultPasswordResetOptions = PasswordResetOptions{
+	CodeTTL:     15 * time.Minute,
+	MaxAttempts: 5,
+}
+
+func (o PasswordResetOptions) withDefaults() PasswordResetOptions {
+	if o.CodeTTL <= 0 {
+		o.CodeTTL = DefaultPasswordResetOptions.CodeTTL
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = DefaultPasswordResetOptions.MaxAttempts
+	}
+	return o
+}
+
+// UnverifiedLoginPolicy decides what an account whose phone number is not
+// verified yet gets from LoginUser.
+type UnverifiedLoginPolicy string
+
+const (
+	// UnverifiedLoginBlock refuses to log the account in.
+	UnverifiedLoginBlock UnverifiedLoginPolicy = "block"
+	// UnverifiedLoginLimited logs the account in with tokens scoped to
+	// utils.ScopePhoneVerify, which only reach the routes listing that scope.
+	UnverifiedLoginLimited UnverifiedLoginPolicy = "limited"
+)
+
+// PhoneVerificationOptions controls the one-time codes proving a user owns
+// their phone number, and what an account may do before it has.
+type PhoneVerificationOptions struct {
+	CodeTTL         time.Duration
+	MaxAttempts     int
+	UnverifiedLogin UnverifiedLoginPolicy
+}
+
+var DefaultPhoneVerificationOptions = PhoneVerificationOptions{
+	CodeTTL:         10 * time.Minute,
+	MaxAttempts:     5,
+	UnverifiedLogin: UnverifiedLoginBlock,
+}
+
+func (o PhoneVerificationOptions) withDefaults() PhoneVerificationOptions {
+	if o.CodeTTL <= 0 {
+		o.CodeTTL = DefaultPhoneVerificationOptions.CodeTTL
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = DefaultPhoneVerificationOptions.MaxAttempts
+	}
+	if o.UnverifiedLogin != UnverifiedLoginLimited {
+		o.UnverifiedLogin = UnverifiedLoginBlock
+	}
+	return o
+}
+
+// TOTPOptions controls two-factor authentication with authenticator apps.
+// Issuer names this service in the app, next to the user's phone number.
+type TOTPOptions struct {
+	Issuer        string
+	RecoveryCodes int
+}
+
+var DefaultTOTPOptions = TOTPOptions{
+	Issuer:        "UserService",
+	RecoveryCodes: 10,
+}
+
+func (o TOTPOptions) withDefaults() TOTPOptions {
+	if o.Issuer == "" {
+		o.Issuer = DefaultTOTPOptions.Issuer
+	}
+	if o.RecoveryCodes <= 0 {
+		o.RecoveryCodes = DefaultTOTPOptions.RecoveryCodes
+	}
+	return o
+}
+
+// AccountDeletionOptions controls how long a deleted account, and with it its
+// phone number, is kept before it is purged for good.
+type AccountDeletionOptions struct {
+	GracePeriod time.Duration
+}
+
+var DefaultAccountDeletionOptions = AccountDeletionOptions{
+	GracePeriod: 30 * 24 * time.Hour,
+}
+
+func (o AccountDeletionOptions) withDefaults() AccountDeletionOptions {
+	if o.GracePeriod <= 0 {
+		o.GracePeriod = DefaultAccountDeletionOptions.GracePeriod
+	}
+	return o
 }
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+type AuthorizeConfig struct {
+	// Roles returns the roles allowed to use the route. A route without roles
+	// is open to every authenticated user.
+	Roles func(echo.Context) []string
+}
+
+// Authorize refuses requests to a route that requires a role the caller, as
+// put into the context by JWTWithConfig, doesn't have. It must run after the
+// JWT middleware.
+func Authorize(config AuthorizeConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Roles == nil {
+				return next(c)
+			}
+			roles := config.Roles(c)
+			if len(roles) == 0 {
+				return next(c)
+			}
+
+			principal, ok := GetPrincipal(c)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "missing token")
+			}
+			for _, role := range roles {
+				if principal.Role == role {
+					return next(c)
+				}
+			}
+			return echo.NewHTTPError(http.StatusForbidden, "role does not allow this endpoint")
+		}
+	}
+}
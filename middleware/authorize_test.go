@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"github.com/SawitProRecruitment/UserService/utils"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	swagger := &openapi3.T{
+		Paths: openapi3.Paths{
+			"/user": &openapi3.PathItem{
+				Get: &openapi3.Operation{
+					Security: &openapi3.SecurityRequirements{{"jwtAuth": []string{}}},
+				},
+			},
+			"/admin/users/{id}": &openapi3.PathItem{
+				Get: &openapi3.Operation{
+					Security:   &openapi3.SecurityRequirements{{"jwtAuth": []string{}}},
+					Extensions: map[string]interface{}{"x-roles": []interface{}{utils.RoleSupport, utils.RoleAdmin}},
+				},
+			},
+			"/admin/users/{id}/role": &openapi3.PathItem{
+				Put: &openapi3.Operation{
+					Security:   &openapi3.SecurityRequirements{{"jwtAuth": []string{}}},
+					Extensions: map[string]interface{}{"x-roles": []interface{}{utils.RoleAdmin}},
+				},
+			},
+		},
+	}
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		principal      *Principal
+		expectedStatus int
+	}{
+		{"User On Open Route", http.MethodGet, "/user", &Principal{Role: utils.RoleUser}, http.StatusOK},
+		{"User On Support Route", http.MethodGet, "/admin/users/:id", &Principal{Role: utils.RoleUser}, http.StatusForbidden},
+		{"Support On Support Route", http.MethodGet, "/admin/users/:id", &Principal{Role: utils.RoleSupport}, http.StatusOK},
+		{"Support On Admin Route", http.MethodPut, "/admin/users/:id/role", &Principal{Role: utils.RoleSupport}, http.StatusForbidden},
+		{"Admin On Admin Route", http.MethodPut, "/admin/users/:id/role", &Principal{Role: utils.RoleAdmin}, http.StatusOK},
+		{"No Principal On Support Route", http.MethodGet, "/admin/users/:id", nil, http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(tc.method, "/", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath(tc.path)
+			if tc.principal != nil {
+				SetPrincipal(c, tc.principal)
+			}
+
+			h := Authorize(AuthorizeConfig{Roles: RouteRoles(swagger)})(func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})
+			err := h(c)
+			if err != nil {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedStatus, httpErr.Code)
+			} else {
+				assert.Equal(t, tc.expectedStatus, rec.Code)
+			}
+		})
+	}
+}
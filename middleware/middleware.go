@@ -1,49 +1,220 @@
 package middleware
 
 import (
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/SawitProRecruitment/UserService/utils"
+	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/labstack/echo/v4"
 	"net/http"
-	"os"
+	"regexp"
 	"strings"
+	"time"
 )
 
-func JWTMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		tokenString := c.Request().Header.Get("Authorization")
-		if tokenString == "" {
-			return echo.NewHTTPError(http.StatusForbidden, "missing token")
+const principalContextKey = "principal"
+
+// Principal is the authenticated caller of a request, taken from a verified
+// access token.
+type Principal struct {
+	UserID    string
+	TokenID   string
+	FamilyID  string
+	Scopes    []string
+	Role      string
+	ExpiresAt time.Time
+}
+
+// SetPrincipal stores the authenticated caller in the echo context.
+func SetPrincipal(c echo.Context, principal *Principal) {
+	c.Set(principalContextKey, principal)
+}
+
+// GetPrincipal returns the authenticated caller stored by the JWT middleware.
+func GetPrincipal(c echo.Context) (*Principal, bool) {
+	principal, ok := c.Get(principalContextKey).(*Principal)
+	return principal, ok && principal != nil
+}
+
+type JWTConfig struct {
+	// Skipper decides whether a request bypasses authentication.
+	Skipper  func(echo.Context) bool
+	Keys     *utils.KeyRing
+	Issuer   string
+	Audience string
+	// Revocations, when set, rejects tokens revoked before they expired.
+	Revocations RevocationChecker
+	// Optional, when it returns true, lets a request without a token through
+	// unauthenticated. A token that is sent must still be valid.
+	Optional func(echo.Context) bool
+	// Scopes returns the scopes that let a restricted token use the route.
+	// Tokens without scopes may use every route.
+	Scopes func(echo.Context) []string
+}
+
+// JWTWithConfig verifies the bearer access token of a request and puts the
+// resulting Principal into the echo context.
+func JWTWithConfig(config JWTConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper != nil && config.Skipper(c) {
+				return next(c)
+			}
+
+			tokenString := c.Request().Header.Get("Authorization")
+			if tokenString == "" {
+				if config.Optional != nil && config.Optional(c) {
+					return next(c)
+				}
+				return echo.NewHTTPError(http.StatusUnauthorized, "missing token")
+			}
+
+			// Extract the token from the Authorization header
+			parts := strings.Split(tokenString, " ")
+			if len(parts) != 2 || parts[0] != "Bearer" || parts[1] == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid token format")
+			}
+			tokenString = parts[1]
+
+			claims, err := utils.ValidateJWTToken(tokenString, config.Keys, config.Issuer, config.Audience)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+			}
+
+			if claims.TokenType != utils.AccessTokenType {
+				return echo.NewHTTPError(http.StatusUnauthorized, "refresh token is not allowed for this endpoint")
+			}
+
+			if len(claims.Scopes) > 0 && !hasAnyScope(claims.Scopes, config.Scopes, c) {
+				return echo.NewHTTPError(http.StatusForbidden, "token scope does not allow this endpoint")
+			}
+
+			if config.Revocations != nil {
+				revoked, err := config.Revocations.IsRevoked(c.Request().Context(), claims)
+				if err != nil {
+					return echo.NewHTTPError(http.StatusInternalServerError, "cannot verify token")
+				}
+				if revoked {
+					return echo.NewHTTPError(http.StatusUnauthorized, "token has been revoked")
+				}
+			}
+
+			// Tokens issued before roles existed belong to plain users
+			role := claims.Role
+			if role == "" {
+				role = utils.RoleUser
+			}
+
+			SetPrincipal(c, &Principal{
+				UserID:    claims.UserID,
+				TokenID:   claims.ID,
+				FamilyID:  claims.FamilyID,
+				Scopes:    claims.Scopes,
+				Role:      role,
+				ExpiresAt: claims.ExpiresAt.Time,
+			})
+
+			return next(c)
 		}
+	}
+}
 
-		// Extract the token from the Authorization header
-		parts := strings.Split(tokenString, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			return echo.NewHTTPError(http.StatusForbidden, "invalid token format")
+func hasAnyScope(tokenScopes []string, routeScopes func(echo.Context) []string, c echo.Context) bool {
+	if routeScopes == nil {
+		return false
+	}
+	for _, allowed := range routeScopes(c) {
+		for _, scope := range tokenScopes {
+			if scope == allowed {
+				return true
+			}
 		}
-		tokenString = parts[1]
+	}
+	return false
+}
 
-		// Parse token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Set the signing key here
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
 
-		if err != nil || !token.Valid {
-			return echo.NewHTTPError(http.StatusForbidden, "invalid token")
+type routeSecurity struct {
+	secured  bool
+	optional bool
+	scopes   []string
+	roles    []string
+}
+
+// specSecurity reads the security requirements of every operation in the
+// OpenAPI spec, keyed by "<METHOD> <echo path>". An empty requirement ({})
+// next to jwtAuth makes the token optional, the x-token-scopes extension
+// lists the scopes that let a restricted token use the operation, and x-roles
+// the roles allowed to use it. (OpenAPI 3.0 only allows scopes in the
+// requirement itself for OAuth2 schemes.)
+func specSecurity(swagger *openapi3.T) map[string]routeSecurity {
+	routes := map[string]routeSecurity{}
+	for path, item := range swagger.Paths {
+		echoPath := pathParamPattern.ReplaceAllString(path, ":$1")
+		for method, operation := range item.Operations() {
+			requirements := swagger.Security
+			if operation.Security != nil {
+				requirements = *operation.Security
+			}
+			security := routeSecurity{secured: len(requirements) > 0}
+			for _, requirement := range requirements {
+				if len(requirement) == 0 {
+					security.optional = true
+				}
+			}
+			security.scopes = extensionStrings(operation, "x-token-scopes")
+			security.roles = extensionStrings(operation, "x-roles")
+			routes[method+" "+echoPath] = security
 		}
+	}
+	return routes
+}
 
-		// Check if it's a refresh token
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && claims["type"] == "refresh" {
-			// Handle refresh token logic here
-			// Example: check if the refresh token is valid and generate a new access token
-			// If refresh token is not valid or expired, return an error
-			return echo.NewHTTPError(http.StatusForbidden, "refresh token is not allowed for this endpoint")
+// extensionStrings reads the list of strings in the extension name of
+// operation.
+func extensionStrings(operation *openapi3.Operation, name string) []string {
+	var values []string
+	list, _ := operation.Extensions[name].([]interface{})
+	for _, value := range list {
+		if value, ok := value.(string); ok {
+			values = append(values, value)
 		}
+	}
+	return values
+}
 
-		// Set user context for access token
-		claims := token.Claims.(jwt.MapClaims)
-		c.Set("user", claims["user"])
+// SkipUnsecuredRoutes returns a Skipper that lets through every route which
+// has no security requirement in the OpenAPI spec, so only the operations
+// marked with jwtAuth in api.yml need a token.
+func SkipUnsecuredRoutes(swagger *openapi3.T) func(echo.Context) bool {
+	routes := specSecurity(swagger)
+	return func(c echo.Context) bool {
+		return !routes[c.Request().Method+" "+c.Path()].secured
+	}
+}
+
+// OptionalAuthRoutes returns a JWTConfig.Optional for the routes whose
+// security in the OpenAPI spec includes an empty requirement.
+func OptionalAuthRoutes(swagger *openapi3.T) func(echo.Context) bool {
+	routes := specSecurity(swagger)
+	return func(c echo.Context) bool {
+		return routes[c.Request().Method+" "+c.Path()].optional
+	}
+}
+
+// RouteScopes returns a JWTConfig.Scopes listing the x-token-scopes of each
+// route in the OpenAPI spec.
+func RouteScopes(swagger *openapi3.T) func(echo.Context) []string {
+	routes := specSecurity(swagger)
+	return func(c echo.Context) []string {
+		return routes[c.Request().Method+" "+c.Path()].scopes
+	}
+}
 
-		return next(c)
+// RouteRoles returns an AuthorizeConfig.Roles listing the x-roles of each
+// route in the OpenAPI spec.
+func RouteRoles(swagger *openapi3.T) func(echo.Context) []string {
+	routes := specSecurity(swagger)
+	return func(c echo.Context) []string {
+		return routes[c.Request().Method+" "+c.Path()].roles
 	}
 }
@@ -1,18 +1,33 @@
 package middleware
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"github.com/SawitProRecruitment/UserService/utils"
+	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"testing"
 )
 
+func newTestKeyRing(t *testing.T) *utils.KeyRing {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	signingKey, err := utils.NewSigningKey("testKey", privateKey)
+	assert.NoError(t, err)
+	keys, err := utils.NewKeyRing("testKey", signingKey)
+	assert.NoError(t, err)
+	return keys
+}
+
 func TestJWTMiddleware(t *testing.T) {
-	os.Setenv("JWT_SECRET", "verysecret")
-	jwtToken, _, _ := utils.GenerateJWTToken("mockUserID", "verysecret")
+	keys := newTestKeyRing(t)
+	pair, _ := utils.GenerateTokenPair("mockUserID", "", utils.RoleUser, keys)
+	forgedPair, _ := utils.GenerateTokenPair("mockUserID", "", utils.RoleUser, newTestKeyRing(t))
+	jwtToken, refreshToken, forgedToken := pair.AccessToken, pair.RefreshToken, forgedPair.AccessToken
 	tests := []struct {
 		name             string
 		token            string
@@ -21,23 +36,34 @@ func TestJWTMiddleware(t *testing.T) {
 	}{
 		{
 			name:             "Valid Token",
-			token:            jwtToken,
+			token:            "Bearer " + jwtToken,
 			expectedStatus:   http.StatusOK,
 			expectedResponse: "Authorized",
 		},
 		{
 			name:             "Missing Token",
 			token:            "",
-			expectedStatus:   http.StatusForbidden,
+			expectedStatus:   http.StatusUnauthorized,
 			expectedResponse: "missing token",
 		},
 		{
 			name:             "Invalid Token Format",
 			token:            "InvalidTokenFormat",
-			expectedStatus:   http.StatusForbidden,
+			expectedStatus:   http.StatusUnauthorized,
 			expectedResponse: "invalid token format",
 		},
-		// Add more test cases for Expired Token, Refresh Token, etc.
+		{
+			name:             "Forged Token",
+			token:            "Bearer " + forgedToken,
+			expectedStatus:   http.StatusUnauthorized,
+			expectedResponse: "invalid token",
+		},
+		{
+			name:             "Refresh Token",
+			token:            "Bearer " + refreshToken,
+			expectedStatus:   http.StatusUnauthorized,
+			expectedResponse: "refresh token is not allowed for this endpoint",
+		},
 	}
 
 	for _, tc := range tests {
@@ -47,18 +73,136 @@ func TestJWTMiddleware(t *testing.T) {
 			req := httptest.NewRequest(http.MethodGet, "/user", nil)
 			rec := httptest.NewRecorder()
 			c := e.NewContext(req, rec)
-			req.Header.Set("Authorization", "Bearer "+tc.token)
+			req.Header.Set("Authorization", tc.token)
 
 			// Invoke middleware
-			h := JWTMiddleware(func(c echo.Context) error {
+			h := JWTWithConfig(JWTConfig{
+				Keys:     keys,
+				Issuer:   utils.DefaultJWTIssuer,
+				Audience: utils.DefaultJWTAudience,
+			})(func(c echo.Context) error {
+				principal, ok := GetPrincipal(c)
+				assert.True(t, ok)
+				assert.Equal(t, "mockUserID", principal.UserID)
+				assert.NotEmpty(t, principal.TokenID)
 				return c.String(http.StatusOK, "Authorized")
 			})
 			err := h(c)
 			if err != nil {
-				assert.NotEqual(t, tc.expectedStatus, rec.Code)
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedStatus, httpErr.Code)
+				assert.Equal(t, tc.expectedResponse, httpErr.Message)
 			} else {
 				// Assertions
 				assert.Equal(t, tc.expectedStatus, rec.Code)
+				assert.Equal(t, tc.expectedResponse, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSkipUnsecuredRoutes(t *testing.T) {
+	swagger := &openapi3.T{
+		Paths: openapi3.Paths{
+			"/login": &openapi3.PathItem{
+				Post: &openapi3.Operation{},
+			},
+			"/user/{id}": &openapi3.PathItem{
+				Get: &openapi3.Operation{
+					Security: &openapi3.SecurityRequirements{{"jwtAuth": []string{}}},
+				},
+			},
+		},
+	}
+	skipper := SkipUnsecuredRoutes(swagger)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		expected bool
+	}{
+		{"Unsecured Route", http.MethodPost, "/login", true},
+		{"Secured Route", http.MethodGet, "/user/:id", false},
+		{"Unknown Route", http.MethodGet, "/swagger/*", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(tc.method, "/", nil)
+			c := e.NewContext(req, httptest.NewRecorder())
+			c.SetPath(tc.path)
+			assert.Equal(t, tc.expected, skipper(c))
+		})
+	}
+}
+
+func TestJWTMiddlewareScopesAndOptionalAuth(t *testing.T) {
+	keys := newTestKeyRing(t)
+	scopedTokens, _ := utils.GenerateTokenPair("mockUserID", "", utils.RoleUser, keys, utils.ScopePhoneVerify)
+	fullTokens, _ := utils.GenerateTokenPair("mockUserID", "", utils.RoleUser, keys)
+	swagger := &openapi3.T{
+		Paths: openapi3.Paths{
+			"/user": &openapi3.PathItem{
+				Get: &openapi3.Operation{
+					Security: &openapi3.SecurityRequirements{{"jwtAuth": []string{}}},
+				},
+			},
+			"/phone/verify/request": &openapi3.PathItem{
+				Post: &openapi3.Operation{
+					Security:   &openapi3.SecurityRequirements{{}, {"jwtAuth": []string{}}},
+					Extensions: map[string]interface{}{"x-token-scopes": []interface{}{utils.ScopePhoneVerify}},
+				},
+			},
+		},
+	}
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		token          string
+		expectedStatus int
+		expectedUser   bool
+	}{
+		{"Scoped Token On Scoped Route", http.MethodPost, "/phone/verify/request", scopedTokens.AccessToken, http.StatusOK, true},
+		{"Scoped Token On Other Route", http.MethodGet, "/user", scopedTokens.AccessToken, http.StatusForbidden, false},
+		{"Full Token On Scoped Route", http.MethodPost, "/phone/verify/request", fullTokens.AccessToken, http.StatusOK, true},
+		{"No Token On Optional Route", http.MethodPost, "/phone/verify/request", "", http.StatusOK, false},
+		{"No Token On Required Route", http.MethodGet, "/user", "", http.StatusUnauthorized, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath(tc.path)
+
+			h := JWTWithConfig(JWTConfig{
+				Skipper:  SkipUnsecuredRoutes(swagger),
+				Keys:     keys,
+				Issuer:   utils.DefaultJWTIssuer,
+				Audience: utils.DefaultJWTAudience,
+				Optional: OptionalAuthRoutes(swagger),
+				Scopes:   RouteScopes(swagger),
+			})(func(c echo.Context) error {
+				_, ok := GetPrincipal(c)
+				assert.Equal(t, tc.expectedUser, ok)
+				return c.NoContent(http.StatusOK)
+			})
+			err := h(c)
+			if err != nil {
+				httpErr, ok := err.(*echo.HTTPError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedStatus, httpErr.Code)
+			} else {
+				assert.Equal(t, tc.expectedStatus, rec.Code)
 			}
 		})
 	}
@@ -0,0 +1,214 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SawitProRecruitment/UserService/phone"
+	"github.com/labstack/echo/v4"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit allows Requests requests per Per, with bursts of up to Requests.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseRateLimit reads a limit written as "<requests>/<duration>", e.g. "5/1m".
+func ParseRateLimit(s string) (RateLimit, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return RateLimit{}, fmt.Errorf("rate limit %q is not <requests>/<duration>", s)
+	}
+	requests, err := strconv.Atoi(parts[0])
+	if err != nil || requests <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q has an invalid request count", s)
+	}
+	per, err := time.ParseDuration(parts[1])
+	if err != nil || per <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q has an invalid duration", s)
+	}
+	return RateLimit{Requests: requests, Per: per}, nil
+}
+
+// RateLimitStore holds the token buckets. MemoryRateLimitStore is enough for a
+// single instance; several replicas enforce one limit by sharing a store
+// backed by e.g. Redis that implements Take atomically.
+type RateLimitStore interface {
+	// Take removes a token from the bucket named key. When the bucket is
+	// empty it returns false and how long until the next token is added.
+	Take(ctx context.Context, key string, limit RateLimit) (bool, time.Duration, error)
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+// MemoryRateLimitStore keeps token buckets in process memory.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	nextSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+func (m *MemoryRateLimitStore) Take(_ context.Context, key string, limit RateLimit) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	capacity := float64(limit.Requests)
+	refillEvery := limit.Per / time.Duration(limit.Requests)
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updatedAt: now}
+		m.buckets[key] = b
+	}
+
+	b.tokens = math.Min(capacity, b.tokens+float64(now.Sub(b.updatedAt))/float64(refillEvery))
+	b.updatedAt = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(refillEvery)), nil
+	}
+	b.tokens--
+	b.fullAt = now.Add(time.Duration((capacity - b.tokens) * float64(refillEvery)))
+	return true, 0, nil
+}
+
+// sweep drops buckets that have refilled completely, since a fresh bucket
+// behaves the same. It runs at most once a minute.
+func (m *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Before(m.nextSweep) {
+		return
+	}
+	m.nextSweep = now.Add(time.Minute)
+	for key, b := range m.buckets {
+		if !now.Before(b.fullAt) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+type RateLimiterConfig struct {
+	// Skipper decides whether a request bypasses the limiter.
+	Skipper func(echo.Context) bool
+	Store   RateLimitStore
+	// IPLimit is applied per client IP and route.
+	IPLimit RateLimit
+	// PhoneLimit is applied per phone_number of the JSON body and route.
+	PhoneLimit RateLimit
+	// PhoneRegion is used to normalize phone numbers typed without a country
+	// calling code, so every way of writing a number shares one bucket.
+	PhoneRegion string
+	// MaxBodyBytes caps how much of the body is read to find the phone
+	// number. Larger bodies are answered with 413. Defaults to
+	// DefaultMaxBodyBytes.
+	MaxBodyBytes int64
+}
+
+// DefaultMaxBodyBytes is far more than any JSON body of the rate limited
+// routes needs.
+const DefaultMaxBodyBytes = 8 << 10
+
+// RateLimiter throttles requests with token buckets keyed by client IP and by
+// the phone number in the request body, answering 429 with Retry-After when
+// either bucket is empty.
+func RateLimiter(config RateLimiterConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper != nil && config.Skipper(c) {
+				return next(c)
+			}
+
+			route := c.Request().Method + " " + c.Path()
+			keys := []string{"ip:" + route + ":" + c.RealIP()}
+			limits := []RateLimit{config.IPLimit}
+
+			maxBodyBytes := config.MaxBodyBytes
+			if maxBodyBytes <= 0 {
+				maxBodyBytes = DefaultMaxBodyBytes
+			}
+			phoneNumber, err := peekPhoneNumber(c, config.PhoneRegion, maxBodyBytes)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Request Entity Too Large").SetInternal(err)
+			}
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid Request Body").SetInternal(err)
+			}
+			if phoneNumber != "" {
+				keys = append(keys, "phone:"+route+":"+phoneNumber)
+				limits = append(limits, config.PhoneLimit)
+			}
+
+			for i, key := range keys {
+				allowed, retryAfter, err := config.Store.Take(c.Request().Context(), key, limits[i])
+				if err != nil {
+					// Fail open: an unavailable store shouldn't take login down
+					c.Logger().Error(err)
+					continue
+				}
+				if !allowed {
+					seconds := int(math.Ceil(retryAfter.Seconds()))
+					c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+					return echo.NewHTTPError(http.StatusTooManyRequests, "Too Many Requests")
+				}
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// peekPhoneNumber reads phone_number from the JSON body, normalized to E.164
+// when possible, and puts the body back so the handler can still read it. It
+// reads at most maxBytes of the body.
+func peekPhoneNumber(c echo.Context, region string, maxBytes int64) (string, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, maxBytes))
+	if err != nil {
+		return "", err
+	}
+	c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+	var payload struct {
+		PhoneNumber string `json:"phone_number"`
+	}
+	// A malformed body is left for the handler to reject
+	json.Unmarshal(body, &payload)
+	if phoneNumber, err := phone.Normalize(payload.PhoneNumber, region); err == nil {
+		return phoneNumber, nil
+	}
+	return strings.TrimSpace(payload.PhoneNumber), nil
+}
+
+// SkipUnlessRoute returns a Skipper that only lets the given routes, written
+// as "<METHOD> <path>", through to the middleware.
+func SkipUnlessRoute(routes ...string) func(echo.Context) bool {
+	matched := map[string]bool{}
+	for _, route := range routes {
+		matched[route] = true
+	}
+	return func(c echo.Context) bool {
+		return !matched[c.Request().Method+" "+c.Path()]
+	}
+}
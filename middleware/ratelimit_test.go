@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expected    RateLimit
+		expectedErr bool
+	}{
+		{"Valid Limit", "5/1m", RateLimit{Requests: 5, Per: time.Minute}, false},
+		{"Missing Duration", "5", RateLimit{}, true},
+		{"Zero Requests", "0/1m", RateLimit{}, true},
+		{"Invalid Duration", "5/soon", RateLimit{}, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			limit, err := ParseRateLimit(tc.input)
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, limit)
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	limit := RateLimit{Requests: 2, Per: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		allowed, _, err := store.Take(context.Background(), "key", limit)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+	}
+
+	allowed, retryAfter, err := store.Take(context.Background(), "key", limit)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, 30*time.Second, retryAfter)
+
+	// Other keys have their own bucket
+	allowed, _, err = store.Take(context.Background(), "otherKey", limit)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	// One token is added back every 30 seconds
+	now = now.Add(30 * time.Second)
+	allowed, _, err = store.Take(context.Background(), "key", limit)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+}
+
+func TestRateLimiter(t *testing.T) {
+	e := echo.New()
+	e.IPExtractor = echo.ExtractIPDirect()
+	e.Use(RateLimiter(RateLimiterConfig{
+		Skipper:      SkipUnlessRoute("POST /login"),
+		Store:        NewMemoryRateLimitStore(),
+		IPLimit:      RateLimit{Requests: 3, Per: time.Minute},
+		PhoneLimit:   RateLimit{Requests: 2, Per: time.Minute},
+		PhoneRegion:  "ID",
+		MaxBodyBytes: 64,
+	}))
+	e.POST("/login", func(c echo.Context) error {
+		body, _ := io.ReadAll(c.Request().Body)
+		return c.String(http.StatusOK, string(body))
+	})
+	e.POST("/register", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	send := func(path, ip, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name           string
+		path           string
+		ip             string
+		body           string
+		expectedStatus int
+	}{
+		{"First Attempt For Phone", "/login", "10.0.0.1", `{"phone_number":"+62811100001"}`, http.StatusOK},
+		{"Second Attempt For Phone", "/login", "10.0.0.2", `{"phone_number":"0811-1000-01"}`, http.StatusOK},
+		{"Phone Limit Across IPs And Spellings", "/login", "10.0.0.3", `{"phone_number":"+62 811 1000 01"}`, http.StatusTooManyRequests},
+		{"Other Phone Same IP", "/login", "10.0.0.1", `{"phone_number":"+628222"}`, http.StatusOK},
+		{"Third Request From IP", "/login", "10.0.0.1", `{"phone_number":"+628333"}`, http.StatusOK},
+		{"IP Limit", "/login", "10.0.0.1", `{"phone_number":"+628444"}`, http.StatusTooManyRequests},
+		{"Unlimited Route", "/register", "10.0.0.1", `{"phone_number":"+628111"}`, http.StatusOK},
+		{"Body Too Large", "/login", "10.0.0.4", `{"phone_number":"+628555","password":"` + strings.Repeat("a", 64) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := send(tc.path, tc.ip, tc.body)
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			if tc.expectedStatus == http.StatusTooManyRequests {
+				assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+			} else if tc.expectedStatus == http.StatusOK && tc.path == "/login" {
+				// The handler still sees the whole body
+				assert.Equal(t, tc.body, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRateLimiterUnreadableBody(t *testing.T) {
+	limiter := RateLimiter(RateLimiterConfig{
+		Store:      NewMemoryRateLimitStore(),
+		IPLimit:    RateLimit{Requests: 3, Per: time.Minute},
+		PhoneLimit: RateLimit{Requests: 2, Per: time.Minute},
+	})
+	handler := limiter(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	readErr := errors.New("read tcp 10.0.0.5:1323: connection reset by peer")
+	req := httptest.NewRequest(http.MethodPost, "/login", iotest.ErrReader(readErr))
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+
+	err := handler(c)
+	httpErr, ok := err.(*echo.HTTPError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusBadRequest, httpErr.Code)
+	// The cause is only kept for the logs
+	assert.Equal(t, "Invalid Request Body", httpErr.Message)
+	assert.ErrorIs(t, httpErr, readErr)
+}
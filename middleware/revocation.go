@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/SawitProRecruitment/UserService/utils"
+	"sync"
+	"time"
+)
+
+// RevocationStore is the part of the repository that knows which tokens were
+// revoked before they expired.
+type RevocationStore interface {
+	IsTokenRevoked(context.Context, string) (bool, error)
+	IsTokenFamilyRevoked(context.Context, string) (bool, error)
+	GetTokensValidAfter(context.Context, string) (*time.Time, error)
+}
+
+// RevocationChecker reports whether a verified token has since been revoked.
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, claims *utils.JWTClaims) (bool, error)
+}
+
+type revokedTokenEntry struct {
+	revoked   bool
+	expiresAt time.Time
+}
+
+type validAfterEntry struct {
+	validAfter *time.Time
+	expiresAt  time.Time
+}
+
+// RevocationCache keeps revocation lookups off the database for most
+// requests. A revoked token stays cached until the token itself expires, while
+// "not revoked" answers are only trusted for ttl, which bounds how long a
+// revocation made on another replica can go unnoticed.
+type RevocationCache struct {
+	store RevocationStore
+	ttl   time.Duration
+
+	mu          sync.Mutex
+	tokens      map[string]revokedTokenEntry
+	families    map[string]revokedTokenEntry
+	validAfters map[string]validAfterEntry
+	nextSweep   time.Time
+	now         func() time.Time
+}
+
+func NewRevocationCache(store RevocationStore, ttl time.Duration) *RevocationCache {
+	return &RevocationCache{
+		store:       store,
+		ttl:         ttl,
+		tokens:      map[string]revokedTokenEntry{},
+		families:    map[string]revokedTokenEntry{},
+		validAfters: map[string]validAfterEntry{},
+		now:         time.Now,
+	}
+}
+
+func (c *RevocationCache) IsRevoked(ctx context.Context, claims *utils.JWTClaims) (bool, error) {
+	validAfter, err := c.tokensValidAfter(ctx, claims.UserID)
+	// A user that was deleted or purged has no valid tokens left
+	if errors.Is(err, repository.ErrNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if validAfter != nil && (claims.IssuedAt == nil || claims.IssuedAt.Time.Before(*validAfter)) {
+		return true, nil
+	}
+
+	// Revoking a session revokes its token family, which takes the access
+	// tokens issued to that session with it
+	if claims.FamilyID != "" {
+		revoked, err := c.revoked(ctx, c.families, claims.FamilyID, claims, c.store.IsTokenFamilyRevoked)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+
+	return c.revoked(ctx, c.tokens, claims.ID, claims, c.store.IsTokenRevoked)
+}
+
+// TokenRevoked records a revocation made by this instance so it takes effect
+// immediately instead of after the cache entry expires.
+func (c *RevocationCache) TokenRevoked(tokenID string, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens[tokenID] = revokedTokenEntry{revoked: true, expiresAt: expiresAt}
+}
+
+// TokenFamilyRevoked records that every token of the family is revoked. Its
+// access tokens are gone once the last one expires, so the entry is kept for
+// one access token lifetime.
+func (c *RevocationCache) TokenFamilyRevoked(familyID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.families[familyID] = revokedTokenEntry{revoked: true, expiresAt: c.now().Add(utils.AccessTokenTTL)}
+}
+
+// UserTokensRevoked records that every token of the user issued before
+// validAfter is revoked.
+func (c *RevocationCache) UserTokensRevoked(userID string, validAfter time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.validAfters[userID] = validAfterEntry{validAfter: &validAfter, expiresAt: c.now().Add(c.ttl)}
+}
+
+func (c *RevocationCache) tokensValidAfter(ctx context.Context, userID string) (*time.Time, error) {
+	c.mu.Lock()
+	entry, ok := c.validAfters[userID]
+	c.mu.Unlock()
+	if ok && c.now().Before(entry.expiresAt) {
+		return entry.validAfter, nil
+	}
+
+	validAfter, err := c.store.GetTokensValidAfter(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evictExpired()
+	c.validAfters[userID] = validAfterEntry{validAfter: validAfter, expiresAt: c.now().Add(c.ttl)}
+	return validAfter, nil
+}
+
+// revoked answers from entries when it can and asks lookup otherwise. A
+// revoked answer is kept until the token expires.
+func (c *RevocationCache) revoked(ctx context.Context, entries map[string]revokedTokenEntry, key string,
+	claims *utils.JWTClaims, lookup func(context.Context, string) (bool, error)) (bool, error) {
+	c.mu.Lock()
+	entry, ok := entries[key]
+	c.mu.Unlock()
+	if ok && c.now().Before(entry.expiresAt) {
+		return entry.revoked, nil
+	}
+
+	revoked, err := lookup(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	expiresAt := c.now().Add(c.ttl)
+	if revoked && claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evictExpired()
+	entries[key] = revokedTokenEntry{revoked: revoked, expiresAt: expiresAt}
+	return revoked, nil
+}
+
+// evictExpired drops stale entries at most once per ttl so the cache doesn't
+// grow with every token ever seen. Callers must hold c.mu.
+func (c *RevocationCache) evictExpired() {
+	now := c.now()
+	if now.Before(c.nextSweep) {
+		return
+	}
+	c.nextSweep = now.Add(c.ttl)
+	for tokenID, entry := range c.tokens {
+		if !now.Before(entry.expiresAt) {
+			delete(c.tokens, tokenID)
+		}
+	}
+	for familyID, entry := range c.families {
+		if !now.Before(entry.expiresAt) {
+			delete(c.families, familyID)
+		}
+	}
+	for userID, entry := range c.validAfters {
+		if !now.Before(entry.expiresAt) {
+			delete(c.validAfters, userID)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"context"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/SawitProRecruitment/UserService/utils"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeRevocationStore struct {
+	revokedTokens   map[string]bool
+	revokedFamilies map[string]bool
+	validAfter      map[string]time.Time
+	purgedUsers     map[string]bool
+	lookups         int
+}
+
+func (f *fakeRevocationStore) IsTokenRevoked(_ context.Context, tokenID string) (bool, error) {
+	f.lookups++
+	return f.revokedTokens[tokenID], nil
+}
+
+func (f *fakeRevocationStore) IsTokenFamilyRevoked(_ context.Context, familyID string) (bool, error) {
+	f.lookups++
+	return f.revokedFamilies[familyID], nil
+}
+
+func (f *fakeRevocationStore) GetTokensValidAfter(_ context.Context, userID string) (*time.Time, error) {
+	f.lookups++
+	if f.purgedUsers[userID] {
+		return nil, &repository.Error{Op: "GetTokensValidAfter", Kind: repository.ErrNotFound}
+	}
+	if validAfter, ok := f.validAfter[userID]; ok {
+		return &validAfter, nil
+	}
+	return nil, nil
+}
+
+func testClaims(userID, tokenID string, issuedAt time.Time) *utils.JWTClaims {
+	return &utils.JWTClaims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(utils.AccessTokenTTL)),
+		},
+	}
+}
+
+func testFamilyClaims(userID, tokenID, familyID string, issuedAt time.Time) *utils.JWTClaims {
+	claims := testClaims(userID, tokenID, issuedAt)
+	claims.FamilyID = familyID
+	return claims
+}
+
+func TestRevocationCache(t *testing.T) {
+	now := time.Now()
+	store := &fakeRevocationStore{
+		revokedTokens:   map[string]bool{"revokedToken": true},
+		revokedFamilies: map[string]bool{"revokedFamily": true},
+		validAfter:      map[string]time.Time{"loggedOutUser": now},
+		purgedUsers:     map[string]bool{"purgedUser": true},
+	}
+	cache := NewRevocationCache(store, time.Minute)
+
+	tests := []struct {
+		name     string
+		claims   *utils.JWTClaims
+		expected bool
+	}{
+		{"Active Token", testClaims("mockUserID", "activeToken", now), false},
+		{"Revoked Token", testClaims("mockUserID", "revokedToken", now), true},
+		{"Token Issued Before Logout All", testClaims("loggedOutUser", "oldToken", now.Add(-time.Hour)), true},
+		{"Token Issued After Logout All", testClaims("loggedOutUser", "newToken", now.Add(time.Hour)), false},
+		{"Token Of Active Session", testFamilyClaims("mockUserID", "sessionToken", "activeFamily", now), false},
+		{"Token Of Revoked Session", testFamilyClaims("mockUserID", "sessionToken", "revokedFamily", now), true},
+		{"Token Of Purged User", testClaims("purgedUser", "purgedToken", now), true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			revoked, err := cache.IsRevoked(context.Background(), tc.claims)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, revoked)
+		})
+	}
+
+	t.Run("Cached Lookups Skip The Store", func(t *testing.T) {
+		lookups := store.lookups
+		revoked, err := cache.IsRevoked(context.Background(), testClaims("mockUserID", "activeToken", now))
+		assert.NoError(t, err)
+		assert.False(t, revoked)
+		assert.Equal(t, lookups, store.lookups)
+	})
+
+	t.Run("Local Revocation Takes Effect Immediately", func(t *testing.T) {
+		cache.TokenRevoked("activeToken", now.Add(utils.AccessTokenTTL))
+		revoked, err := cache.IsRevoked(context.Background(), testClaims("mockUserID", "activeToken", now))
+		assert.NoError(t, err)
+		assert.True(t, revoked)
+	})
+
+	t.Run("Local Session Revocation Takes Effect Immediately", func(t *testing.T) {
+		cache.TokenFamilyRevoked("activeFamily")
+		revoked, err := cache.IsRevoked(context.Background(), testFamilyClaims("mockUserID", "otherSessionToken", "activeFamily", now))
+		assert.NoError(t, err)
+		assert.True(t, revoked)
+	})
+
+	t.Run("Expired Entries Are Looked Up Again", func(t *testing.T) {
+		cache.now = func() time.Time { return now.Add(2 * time.Minute) }
+		lookups := store.lookups
+		_, err := cache.IsRevoked(context.Background(), testClaims("otherUserID", "otherToken", now))
+		assert.NoError(t, err)
+		assert.Equal(t, lookups+2, store.lookups)
+	})
+}
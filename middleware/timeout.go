@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+// Timeout gives every request a deadline, which handlers pass on to the
+// repository so a slow query is canceled instead of outliving the request. A
+// request that runs out of time is answered with 504. Zero disables it.
+func Timeout(timeout time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if timeout <= 0 {
+				return next(c)
+			}
+
+			ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+			defer cancel()
+			c.SetRequest(c.Request().WithContext(ctx))
+
+			err := next(c)
+			// Whatever failed after the deadline most likely failed because of it
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && !c.Response().Committed {
+				return echo.NewHTTPError(http.StatusGatewayTimeout, "Request Timed Out").SetInternal(ctx.Err())
+			}
+			return err
+		}
+	}
+}
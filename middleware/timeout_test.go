@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"context"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		name         string
+		timeout      time.Duration
+		handler      echo.HandlerFunc
+		expectedCode int
+	}{
+		{
+			name:    "Finished In Time",
+			timeout: time.Second,
+			handler: func(c echo.Context) error {
+				_, ok := c.Request().Context().Deadline()
+				assert.True(t, ok)
+				return c.NoContent(http.StatusOK)
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:    "Ran Out Of Time",
+			timeout: 10 * time.Millisecond,
+			handler: func(c echo.Context) error {
+				<-c.Request().Context().Done()
+				return echo.NewHTTPError(http.StatusInternalServerError, c.Request().Context().Err().Error())
+			},
+			expectedCode: http.StatusGatewayTimeout,
+		},
+		{
+			name:    "Disabled",
+			timeout: 0,
+			handler: func(c echo.Context) error {
+				_, ok := c.Request().Context().Deadline()
+				assert.False(t, ok)
+				return c.NoContent(http.StatusOK)
+			},
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			e := echo.New()
+			e.Use(Timeout(tc.timeout))
+			e.GET("/user", tc.handler)
+
+			req := httptest.NewRequest(http.MethodGet, "/user", nil)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.expectedCode, rec.Code)
+		})
+	}
+}
+
+func TestTimeoutKeepsParentDeadline(t *testing.T) {
+	e := echo.New()
+	e.Use(Timeout(time.Hour))
+	e.GET("/user", func(c echo.Context) error {
+		deadline, ok := c.Request().Context().Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Second), deadline, time.Second)
+		return c.NoContent(http.StatusOK)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/user", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,19 @@
+create table token_families (
+   id serial PRIMARY KEY,
+   family_id text NOT NULL UNIQUE,
+   user_id text NOT NULL,
+   revoked_at timestamp null,
+   created_at timestamp not null
+);
+
+create table refresh_tokens (
+   id serial PRIMARY KEY,
+   token_id text NOT NULL UNIQUE,
+   family_id text NOT NULL REFERENCES token_families (family_id),
+   user_id text NOT NULL,
+   used_at timestamp null,
+   expires_at timestamp not null,
+   created_at timestamp not null
+);
+
+create index refresh_tokens_family_id_idx on refresh_tokens (family_id);
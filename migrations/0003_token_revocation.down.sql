@@ -0,0 +1,5 @@
+drop index token_families_user_id_idx;
+
+drop table revoked_tokens;
+
+alter table users drop column tokens_valid_after;
@@ -0,0 +1,11 @@
+alter table users add column tokens_valid_after timestamp null;
+
+create table revoked_tokens (
+   id serial PRIMARY KEY,
+   token_id text NOT NULL UNIQUE,
+   user_id text NOT NULL,
+   expires_at timestamp not null,
+   revoked_at timestamp not null
+);
+
+create index token_families_user_id_idx on token_families (user_id);
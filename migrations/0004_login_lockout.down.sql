@@ -0,0 +1,3 @@
+alter table users
+   drop column locked_until,
+   drop column failed_login_attempts;
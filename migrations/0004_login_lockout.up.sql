@@ -0,0 +1,3 @@
+alter table users
+   add column failed_login_attempts int not null default 0,
+   add column locked_until timestamp null;
@@ -0,0 +1 @@
+drop table password_reset_codes;
@@ -0,0 +1,12 @@
+create table password_reset_codes (
+   id serial PRIMARY KEY,
+   user_id text NOT NULL,
+   phone_number text NOT NULL,
+   code_hash text NOT NULL,
+   attempts int not null default 0,
+   expires_at timestamp not null,
+   used_at timestamp null,
+   created_at timestamp not null
+);
+
+create index password_reset_codes_user_id_idx on password_reset_codes (user_id);
@@ -0,0 +1 @@
+alter table users alter column phone_number type char(13);
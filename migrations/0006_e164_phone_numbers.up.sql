@@ -0,0 +1,51 @@
+-- E.164 numbers have up to 15 digits after the +. char(13) padded shorter
+-- numbers with spaces.
+alter table users alter column phone_number type varchar(16) using rtrim(phone_number);
+
+-- The countries of phone.Countries when this migration was written
+create temporary table e164_countries (
+   calling_code text NOT NULL,
+   trunk_prefix text NOT NULL,
+   min_length int NOT NULL,
+   max_length int NOT NULL
+) on commit drop;
+
+insert into e164_countries (calling_code, trunk_prefix, min_length, max_length) values
+   ('62', '0', 8, 12),
+   ('60', '0', 8, 10),
+   ('65', '', 8, 8),
+   ('63', '0', 8, 10),
+   ('66', '0', 8, 9),
+   ('84', '0', 9, 10),
+   ('61', '0', 9, 9),
+   ('91', '0', 10, 10),
+   ('86', '0', 10, 11),
+   ('44', '0', 9, 10),
+   ('1', '1', 10, 10);
+
+-- Numbers used to be stored as typed after the +, so a trunk prefix after the
+-- calling code, as in +620812345678, is dropped the way phone.Normalize does
+update users u set phone_number = '+' || c.calling_code
+      || substr(u.phone_number, 2 + length(c.calling_code) + length(c.trunk_prefix))
+   from e164_countries c
+   where c.trunk_prefix <> ''
+      and u.phone_number like '+' || c.calling_code || c.trunk_prefix || '%';
+
+-- Any other number would no longer be found at login, so it is fixed by hand
+-- before this migration is run again
+do $$
+declare
+   invalid text;
+begin
+   select string_agg(u.user_id || ' (' || u.phone_number || ')', ', ') into invalid
+      from users u
+      where not exists (
+         select 1 from e164_countries c
+            where u.phone_number ~ '^\+[0-9]+$'
+               and u.phone_number like '+' || c.calling_code || '%'
+               and length(u.phone_number) - 1 - length(c.calling_code) between c.min_length and c.max_length);
+   if invalid is not null then
+      raise exception 'phone numbers are not valid E.164, fix them and run the migration again: %', invalid;
+   end if;
+end
+$$;
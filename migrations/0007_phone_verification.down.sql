@@ -0,0 +1,5 @@
+drop table phone_verification_codes;
+
+alter table users
+   drop column pending_phone_number,
+   drop column phone_verified_at;
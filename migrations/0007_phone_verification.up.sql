@@ -0,0 +1,20 @@
+-- Existing accounts are unverified, see Phone Verification in the README
+alter table users
+   add column phone_verified_at timestamp null,
+   add column pending_phone_number varchar(16) null;
+
+-- The sample user of 0001_initial owns its number
+update users set phone_verified_at = created_at where user_id = 'd9982291-e467-4594-ab1c-18d1e2d7bbc1';
+
+create table phone_verification_codes (
+   id serial PRIMARY KEY,
+   user_id text NOT NULL,
+   phone_number text NOT NULL,
+   code_hash text NOT NULL,
+   attempts int not null default 0,
+   expires_at timestamp not null,
+   used_at timestamp null,
+   created_at timestamp not null
+);
+
+create index phone_verification_codes_user_id_idx on phone_verification_codes (user_id);
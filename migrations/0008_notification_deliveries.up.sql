@@ -0,0 +1,15 @@
+create table notification_deliveries (
+   id serial PRIMARY KEY,
+   message_id text NOT NULL UNIQUE,
+   phone_number text NOT NULL,
+   template text NOT NULL,
+   provider text NOT NULL,
+   status text NOT NULL,
+   attempts int not null default 0,
+   last_error text null,
+   sent_at timestamp null,
+   created_at timestamp not null,
+   updated_at timestamp not null
+);
+
+create index notification_deliveries_phone_number_idx on notification_deliveries (phone_number);
@@ -0,0 +1,6 @@
+drop table recovery_codes;
+
+alter table users
+   drop column totp_last_used_step,
+   drop column totp_enabled_at,
+   drop column totp_secret;
@@ -0,0 +1,13 @@
+alter table users
+   add column totp_secret text null,
+   add column totp_enabled_at timestamp null,
+   add column totp_last_used_step bigint not null default 0;
+
+create table recovery_codes (
+   id serial PRIMARY KEY,
+   user_id text NOT NULL,
+   code_hash text NOT NULL,
+   used_at timestamp null,
+   created_at timestamp not null,
+   UNIQUE (user_id, code_hash)
+);
@@ -0,0 +1,5 @@
+alter table token_families
+   drop column last_seen_at,
+   drop column ip_address,
+   drop column user_agent,
+   drop column device_name;
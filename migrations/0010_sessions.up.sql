@@ -0,0 +1,9 @@
+alter table token_families
+   add column device_name text NOT NULL default '',
+   add column user_agent text NOT NULL default '',
+   add column ip_address text NOT NULL default '',
+   add column last_seen_at timestamp null;
+
+-- Sessions started before they were tracked were last seen when they began
+update token_families set last_seen_at = created_at;
+alter table token_families alter column last_seen_at set not null;
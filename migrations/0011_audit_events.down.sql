@@ -0,0 +1,3 @@
+drop trigger audit_events_append_only on audit_events;
+drop function audit_events_append_only();
+drop table audit_events;
@@ -0,0 +1,23 @@
+create table audit_events (
+   id bigserial PRIMARY KEY,
+   user_id text NOT NULL,
+   actor_id text null,
+   event_type text NOT NULL,
+   reason text NOT NULL default '',
+   ip_address text NOT NULL default '',
+   user_agent text NOT NULL default '',
+   changes jsonb null,
+   created_at timestamp not null
+);
+
+create index audit_events_user_id_idx on audit_events (user_id, id);
+
+-- The audit log is append-only
+create function audit_events_append_only() returns trigger as $$
+begin
+   raise exception 'audit_events is append-only';
+end;
+$$ language plpgsql;
+
+create trigger audit_events_append_only before update or delete on audit_events
+   for each row execute function audit_events_append_only();
@@ -0,0 +1,3 @@
+alter table users
+   drop constraint users_phone_number_key,
+   drop constraint users_user_id_key;
@@ -0,0 +1,4 @@
+-- Fails while duplicates are stored, see Unique Phone Numbers in the README
+alter table users
+   add constraint users_user_id_key unique (user_id),
+   add constraint users_phone_number_key unique (phone_number);
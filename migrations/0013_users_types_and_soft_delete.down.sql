@@ -0,0 +1,64 @@
+-- The old schema can't hide deleted users, so they go for good
+delete from users where deleted_at is not null;
+
+drop index users_phone_number_key;
+alter table users add constraint users_phone_number_key unique (phone_number);
+
+alter table users drop column deleted_at;
+
+alter table token_families
+   alter column user_id type text,
+   alter column revoked_at type timestamp using revoked_at at time zone 'UTC',
+   alter column last_seen_at type timestamp using last_seen_at at time zone 'UTC',
+   alter column created_at type timestamp using created_at at time zone 'UTC';
+
+alter table refresh_tokens
+   alter column user_id type text,
+   alter column used_at type timestamp using used_at at time zone 'UTC',
+   alter column expires_at type timestamp using expires_at at time zone 'UTC',
+   alter column created_at type timestamp using created_at at time zone 'UTC';
+
+alter table revoked_tokens
+   alter column user_id type text,
+   alter column expires_at type timestamp using expires_at at time zone 'UTC',
+   alter column revoked_at type timestamp using revoked_at at time zone 'UTC';
+
+alter table password_reset_codes
+   alter column user_id type text,
+   alter column expires_at type timestamp using expires_at at time zone 'UTC',
+   alter column used_at type timestamp using used_at at time zone 'UTC',
+   alter column created_at type timestamp using created_at at time zone 'UTC';
+
+alter table phone_verification_codes
+   alter column user_id type text,
+   alter column expires_at type timestamp using expires_at at time zone 'UTC',
+   alter column used_at type timestamp using used_at at time zone 'UTC',
+   alter column created_at type timestamp using created_at at time zone 'UTC';
+
+alter table recovery_codes
+   alter column user_id type text,
+   alter column used_at type timestamp using used_at at time zone 'UTC',
+   alter column created_at type timestamp using created_at at time zone 'UTC';
+
+alter table audit_events
+   alter column user_id type text,
+   alter column actor_id type text,
+   alter column created_at type timestamp using created_at at time zone 'UTC';
+
+alter table notification_deliveries
+   alter column sent_at type timestamp using sent_at at time zone 'UTC',
+   alter column created_at type timestamp using created_at at time zone 'UTC',
+   alter column updated_at type timestamp using updated_at at time zone 'UTC';
+
+alter table users
+   alter column last_login type timestamp using last_login at time zone 'UTC',
+   alter column locked_until type timestamp using locked_until at time zone 'UTC',
+   alter column tokens_valid_after type timestamp using tokens_valid_after at time zone 'UTC',
+   alter column phone_verified_at type timestamp using phone_verified_at at time zone 'UTC',
+   alter column totp_enabled_at type timestamp using totp_enabled_at at time zone 'UTC',
+   alter column created_at type timestamp using created_at at time zone 'UTC',
+   alter column updated_at type timestamp using updated_at at time zone 'UTC';
+
+alter table users alter column user_id type text;
+
+alter table users alter column full_name type char(60);
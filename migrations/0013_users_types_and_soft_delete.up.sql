@@ -0,0 +1,67 @@
+-- char(60) padded every full name with spaces up to 60 characters
+alter table users alter column full_name type varchar(60) using rtrim(full_name);
+
+alter table users alter column user_id type uuid using user_id::uuid;
+
+-- Timestamps were written without a zone, in the UTC of the containers
+alter table users
+   alter column last_login type timestamptz using last_login at time zone 'UTC',
+   alter column locked_until type timestamptz using locked_until at time zone 'UTC',
+   alter column tokens_valid_after type timestamptz using tokens_valid_after at time zone 'UTC',
+   alter column phone_verified_at type timestamptz using phone_verified_at at time zone 'UTC',
+   alter column totp_enabled_at type timestamptz using totp_enabled_at at time zone 'UTC',
+   alter column created_at type timestamptz using created_at at time zone 'UTC',
+   alter column updated_at type timestamptz using updated_at at time zone 'UTC';
+
+-- The tables that hang off users follow the same types. Altering a column
+-- rewrites the table without firing the append-only trigger of audit_events.
+alter table token_families
+   alter column user_id type uuid using user_id::uuid,
+   alter column revoked_at type timestamptz using revoked_at at time zone 'UTC',
+   alter column last_seen_at type timestamptz using last_seen_at at time zone 'UTC',
+   alter column created_at type timestamptz using created_at at time zone 'UTC';
+
+alter table refresh_tokens
+   alter column user_id type uuid using user_id::uuid,
+   alter column used_at type timestamptz using used_at at time zone 'UTC',
+   alter column expires_at type timestamptz using expires_at at time zone 'UTC',
+   alter column created_at type timestamptz using created_at at time zone 'UTC';
+
+alter table revoked_tokens
+   alter column user_id type uuid using user_id::uuid,
+   alter column expires_at type timestamptz using expires_at at time zone 'UTC',
+   alter column revoked_at type timestamptz using revoked_at at time zone 'UTC';
+
+alter table password_reset_codes
+   alter column user_id type uuid using user_id::uuid,
+   alter column expires_at type timestamptz using expires_at at time zone 'UTC',
+   alter column used_at type timestamptz using used_at at time zone 'UTC',
+   alter column created_at type timestamptz using created_at at time zone 'UTC';
+
+alter table phone_verification_codes
+   alter column user_id type uuid using user_id::uuid,
+   alter column expires_at type timestamptz using expires_at at time zone 'UTC',
+   alter column used_at type timestamptz using used_at at time zone 'UTC',
+   alter column created_at type timestamptz using created_at at time zone 'UTC';
+
+alter table recovery_codes
+   alter column user_id type uuid using user_id::uuid,
+   alter column used_at type timestamptz using used_at at time zone 'UTC',
+   alter column created_at type timestamptz using created_at at time zone 'UTC';
+
+alter table audit_events
+   alter column user_id type uuid using user_id::uuid,
+   alter column actor_id type uuid using actor_id::uuid,
+   alter column created_at type timestamptz using created_at at time zone 'UTC';
+
+alter table notification_deliveries
+   alter column sent_at type timestamptz using sent_at at time zone 'UTC',
+   alter column created_at type timestamptz using created_at at time zone 'UTC',
+   alter column updated_at type timestamptz using updated_at at time zone 'UTC';
+
+-- Deleted users are kept, with deleted_at set, and hidden from every query
+alter table users add column deleted_at timestamptz null;
+
+-- The phone number of a deleted user can be registered again
+alter table users drop constraint users_phone_number_key;
+create unique index users_phone_number_key on users (phone_number) where deleted_at is null;
@@ -0,0 +1,6 @@
+drop index users_purge_after_idx;
+
+alter table users drop column purge_after;
+
+alter table users drop constraint users_phone_number_key;
+create unique index users_phone_number_key on users (phone_number) where deleted_at is null;
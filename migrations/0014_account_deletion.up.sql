@@ -0,0 +1,7 @@
+-- A deleted account keeps its phone number until it is purged for good
+drop index users_phone_number_key;
+alter table users add constraint users_phone_number_key unique (phone_number);
+
+alter table users add column purge_after timestamptz null;
+
+create index users_purge_after_idx on users (purge_after) where purge_after is not null;
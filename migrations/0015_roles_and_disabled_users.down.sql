@@ -0,0 +1,3 @@
+alter table users drop column disabled_at;
+
+alter table users drop column role;
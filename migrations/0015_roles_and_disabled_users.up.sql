@@ -0,0 +1,5 @@
+alter table users add column role text not null default 'user'
+   constraint users_role_check check (role in ('user', 'support', 'admin'));
+
+-- Disabled users can't log in until they are enabled again
+alter table users add column disabled_at timestamptz null;
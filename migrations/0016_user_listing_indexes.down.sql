@@ -0,0 +1,7 @@
+drop index users_locked_until_idx;
+drop index users_disabled_at_idx;
+drop index users_phone_number_prefix_idx;
+drop index users_full_name_prefix_idx;
+drop index users_full_name_idx;
+drop index users_last_login_idx;
+drop index users_created_at_idx;
@@ -0,0 +1,15 @@
+-- One index per sort of ListUsers, ending in id like its keyset does, so a
+-- page is a short index scan however deep it is. Each is read both ways for
+-- ascending and descending listings.
+create index users_created_at_idx on users (created_at, id) where deleted_at is null;
+create index users_last_login_idx on users ((coalesce(last_login, '-infinity'::timestamptz)), id) where deleted_at is null;
+create index users_full_name_idx on users (full_name, id) where deleted_at is null;
+
+-- Prefix filters, whatever the collation of the database
+create index users_full_name_prefix_idx on users (lower(full_name) text_pattern_ops) where deleted_at is null;
+create index users_phone_number_prefix_idx on users (phone_number varchar_pattern_ops) where deleted_at is null;
+
+-- Disabled and locked users are few, so the status filter finds them without
+-- a scan
+create index users_disabled_at_idx on users (disabled_at) where disabled_at is not null and deleted_at is null;
+create index users_locked_until_idx on users (locked_until) where locked_until is not null and deleted_at is null;
@@ -0,0 +1,4 @@
+drop index users_full_name_trgm_idx;
+
+-- pg_trgm is left in place: it is database-wide and may have been there
+-- before this migration, or be used by something else since
@@ -0,0 +1,7 @@
+-- Trigram matching for SearchUsers. pg_trgm is a trusted extension, so the
+-- owner of the database may create it.
+create extension if not exists pg_trgm;
+
+-- Serves both the fuzzy <% match and ILIKE '%...%' on names. Phone number
+-- prefixes use users_phone_number_prefix_idx.
+create index users_full_name_trgm_idx on users using gin (full_name gin_trgm_ops) where deleted_at is null;
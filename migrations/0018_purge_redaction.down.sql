@@ -0,0 +1,8 @@
+-- Redacted events stay redacted
+create or replace function audit_events_append_only() returns trigger as $$
+begin
+   raise exception 'audit_events is append-only';
+end;
+$$ language plpgsql;
+
+alter table audit_events drop column redacted_at;
@@ -0,0 +1,16 @@
+-- Purging a user redacts their audit events: the personal data of the event
+-- goes, the record that it happened stays. That is the only update the
+-- append-only trigger lets through.
+alter table audit_events add column redacted_at timestamptz null;
+
+create or replace function audit_events_append_only() returns trigger as $$
+begin
+   if tg_op = 'UPDATE' and old.redacted_at is null and new.redacted_at is not null
+      and new.ip_address = '' and new.user_agent = '' and new.changes is null
+      and (new.id, new.user_id, new.actor_id, new.event_type, new.reason, new.created_at)
+         is not distinct from (old.id, old.user_id, old.actor_id, old.event_type, old.reason, old.created_at) then
+      return new;
+   end if;
+   raise exception 'audit_events is append-only';
+end;
+$$ language plpgsql;
@@ -0,0 +1,109 @@
+package migrations
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    fstest.MapFS
+		expected []Migration
+		err      bool
+	}{
+		{
+			name: "Ordered By Version",
+			files: fstest.MapFS{
+				"0010_add_index.up.sql":   {Data: []byte("create index")},
+				"0010_add_index.down.sql": {Data: []byte("drop index")},
+				"0002_users.up.sql":       {Data: []byte("create table")},
+				"0002_users.down.sql":     {Data: []byte("drop table")},
+				"README.md":               {Data: []byte("not a migration")},
+			},
+			expected: []Migration{
+				{Version: 2, Name: "users", Up: "create table", Down: "drop table"},
+				{Version: 10, Name: "add_index", Up: "create index", Down: "drop index"},
+			},
+		},
+		{
+			name: "Missing Down File",
+			files: fstest.MapFS{
+				"0001_users.up.sql": {Data: []byte("create table")},
+			},
+			err: true,
+		},
+		{
+			name: "Duplicate Version",
+			files: fstest.MapFS{
+				"0001_users.up.sql":    {Data: []byte("create table")},
+				"0001_users.down.sql":  {Data: []byte("drop table")},
+				"0001_tokens.up.sql":   {Data: []byte("create table")},
+				"0001_tokens.down.sql": {Data: []byte("drop table")},
+			},
+			err: true,
+		},
+		{
+			name: "Badly Named File",
+			files: fstest.MapFS{
+				"users.sql": {Data: []byte("create table")},
+			},
+			err: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			migrations, err := Load(test.files)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, migrations)
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Load(Files())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, migrations)
+	// Versions are numbered without gaps, 0001_initial first
+	for i, migration := range migrations {
+		assert.Equal(t, int64(i+1), migration.Version)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+
+	up, down, err := Create(dir, "Add users")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "0001_add_users.up.sql"), up)
+	assert.Equal(t, filepath.Join(dir, "0001_add_users.down.sql"), down)
+
+	up, _, err = Create(dir, "add-user-index")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "0002_add_user_index.up.sql"), up)
+
+	migrations, err := Load(os.DirFS(dir))
+	assert.NoError(t, err)
+	assert.Len(t, migrations, 2)
+
+	_, _, err = Create(dir, "!!!")
+	assert.Error(t, err)
+}
+
+func TestBaselineUnknownVersion(t *testing.T) {
+	migrator, err := NewMigrator(nil, Files())
+	assert.NoError(t, err)
+
+	// Refused before the database is touched
+	_, err = migrator.Baseline(context.Background(), 999999)
+	assert.EqualError(t, err, "there is no migration with version 999999")
+}
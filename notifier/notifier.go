@@ -0,0 +1,69 @@
+// Package notifier delivers messages, such as one-time codes, to users.
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Message is a text message for the owner of PhoneNumber. Its text is
+// Template rendered with Data, or Body when Template is empty.
+type Message struct {
+	// ID identifies the message in the delivery log and lets a provider drop
+	// a retried message it already delivered. Queue sets it when empty.
+	ID          string
+	PhoneNumber string
+	Template    string
+	Data        map[string]any
+	Body        string
+}
+
+// Text returns the text to deliver.
+func (m Message) Text() (string, error) {
+	if m.Template == "" {
+		return m.Body, nil
+	}
+	return Render(m.Template, m.Data)
+}
+
+// Notifier sends a Message to a user. Implementations decide the channel,
+// e.g. SMS, or a log for local development.
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Provider is a Notifier that delivers messages itself, as opposed to Queue
+// which hands them to a Provider later. Name is recorded in the delivery log.
+type Provider interface {
+	Notifier
+	Name() string
+}
+
+// LogNotifier writes messages to w instead of delivering them, so codes can be
+// read from stdout or a file during local development.
+type LogNotifier struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewLogNotifier(w io.Writer) *LogNotifier {
+	return &LogNotifier{w: w}
+}
+
+func (n *LogNotifier) Name() string {
+	return "log"
+}
+
+func (n *LogNotifier) Send(_ context.Context, msg Message) error {
+	text, err := msg.Text()
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, err = fmt.Fprintf(n.w, "%s to=%s body=%q\n", time.Now().Format(time.RFC3339), msg.PhoneNumber, text)
+	return err
+}
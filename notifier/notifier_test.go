@@ -0,0 +1,17 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLogNotifier(t *testing.T) {
+	var buf bytes.Buffer
+	n := NewLogNotifier(&buf)
+
+	err := n.Send(context.Background(), Message{PhoneNumber: "+6281234567890", Body: "Your code is 123456"})
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `to=+6281234567890 body="Your code is 123456"`)
+}
@@ -0,0 +1,235 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/google/uuid"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueueFull   = errors.New("notification queue is full")
+	ErrQueueClosed = errors.New("notification queue is closed")
+)
+
+// Delivery statuses recorded in the delivery log.
+const (
+	DeliveryQueued   = "queued"
+	DeliveryRetrying = "retrying"
+	DeliverySent     = "sent"
+	DeliveryFailed   = "failed"
+)
+
+// DeliveryLog records every message the queue picks up and the outcome of
+// each attempt to deliver it. repository.RepositoryInterface implements it.
+type DeliveryLog interface {
+	CreateNotificationDelivery(context.Context, repository.NotificationDelivery) error
+	UpdateNotificationDelivery(context.Context, repository.NotificationDelivery) error
+}
+
+// QueueOptions controls how many messages may wait, how many are delivered at
+// once and how hard a failing message is retried. The wait before retry n is
+// Backoff * 2^(n-1).
+type QueueOptions struct {
+	Size           int
+	Workers        int
+	MaxAttempts    int
+	Backoff        time.Duration
+	AttemptTimeout time.Duration
+}
+
+var DefaultQueueOptions = QueueOptions{
+	Size:           1000,
+	Workers:        4,
+	MaxAttempts:    5,
+	Backoff:        2 * time.Second,
+	AttemptTimeout: 10 * time.Second,
+}
+
+func (o QueueOptions) withDefaults() QueueOptions {
+	if o.Size <= 0 {
+		o.Size = DefaultQueueOptions.Size
+	}
+	if o.Workers <= 0 {
+		o.Workers = DefaultQueueOptions.Workers
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = DefaultQueueOptions.MaxAttempts
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = DefaultQueueOptions.Backoff
+	}
+	if o.AttemptTimeout <= 0 {
+		o.AttemptTimeout = DefaultQueueOptions.AttemptTimeout
+	}
+	return o
+}
+
+type queuedMessage struct {
+	msg        Message
+	enqueuedAt time.Time
+}
+
+// Queue is a Notifier that returns as soon as a message is queued and
+// delivers it through a Provider in the background, retrying failed attempts
+// with exponential backoff. Messages still waiting when the process exits are
+// lost, so the delivery log only holds messages a worker has picked up.
+type Queue struct {
+	provider   Provider
+	deliveries DeliveryLog
+	opts       QueueOptions
+	messages   chan queuedMessage
+
+	mu     sync.RWMutex
+	closed bool
+
+	// ctx is cancelled when Close gives up waiting, to cut short retries
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	now    func() time.Time
+}
+
+// NewQueue starts the workers of a queue delivering through provider. The
+// delivery log is optional.
+func NewQueue(provider Provider, deliveries DeliveryLog, opts QueueOptions) *Queue {
+	opts = opts.withDefaults()
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		provider:   provider,
+		deliveries: deliveries,
+		opts:       opts,
+		messages:   make(chan queuedMessage, opts.Size),
+		ctx:        ctx,
+		cancel:     cancel,
+		now:        time.Now,
+	}
+	for i := 0; i < opts.Workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Send queues msg for delivery without waiting for it. It only fails when msg
+// can't be rendered or the queue is full or closed. ctx is not used for the
+// delivery, which outlives the request that sent the message.
+func (q *Queue) Send(_ context.Context, msg Message) error {
+	if _, err := msg.Text(); err != nil {
+		return err
+	}
+	if msg.ID == "" {
+		msg.ID = uuid.New().String()
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	select {
+	case q.messages <- queuedMessage{msg: msg, enqueuedAt: q.now()}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting messages and waits for the queued ones to be
+// delivered. When ctx ends first, pending retries are given up and the
+// messages are logged as failed.
+func (q *Queue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.messages)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for queued := range q.messages {
+		q.deliver(queued)
+	}
+}
+
+func (q *Queue) deliver(queued queuedMessage) {
+	delivery := repository.NotificationDelivery{
+		MessageID:   queued.msg.ID,
+		PhoneNumber: queued.msg.PhoneNumber,
+		Template:    queued.msg.Template,
+		Provider:    q.provider.Name(),
+		Status:      DeliveryQueued,
+		CreatedAt:   queued.enqueuedAt,
+		UpdatedAt:   queued.enqueuedAt,
+	}
+	q.record(delivery, true)
+
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(q.ctx, q.opts.AttemptTimeout)
+		err := q.provider.Send(ctx, queued.msg)
+		cancel()
+
+		now := q.now()
+		delivery.Attempts = attempt
+		delivery.UpdatedAt = now
+		if err == nil {
+			delivery.Status = DeliverySent
+			delivery.SentAt = &now
+			delivery.LastError = nil
+			q.record(delivery, false)
+			return
+		}
+
+		lastError := err.Error()
+		delivery.LastError = &lastError
+		if attempt >= q.opts.MaxAttempts || q.ctx.Err() != nil {
+			delivery.Status = DeliveryFailed
+			q.record(delivery, false)
+			return
+		}
+		delivery.Status = DeliveryRetrying
+		q.record(delivery, false)
+
+		select {
+		case <-time.After(q.opts.Backoff << (attempt - 1)):
+		case <-q.ctx.Done():
+		}
+	}
+}
+
+// record creates or updates the entry of delivery in the delivery log, if
+// there is one. A log that can't be written doesn't stop the message from
+// being delivered.
+func (q *Queue) record(delivery repository.NotificationDelivery, create bool) {
+	if q.deliveries == nil {
+		return
+	}
+	var err error
+	if create {
+		err = q.deliveries.CreateNotificationDelivery(context.Background(), delivery)
+	} else {
+		err = q.deliveries.UpdateNotificationDelivery(context.Background(), delivery)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,123 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyProvider fails the first failures sends, then delivers.
+type flakyProvider struct {
+	mu       sync.Mutex
+	failures int
+	sent     []Message
+}
+
+func (p *flakyProvider) Name() string {
+	return "flaky"
+}
+
+func (p *flakyProvider) Send(_ context.Context, msg Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("gateway unavailable")
+	}
+	p.sent = append(p.sent, msg)
+	return nil
+}
+
+// memoryDeliveryLog keeps every version of each delivery it is given.
+type memoryDeliveryLog struct {
+	mu      sync.Mutex
+	entries []repository.NotificationDelivery
+}
+
+func (l *memoryDeliveryLog) CreateNotificationDelivery(_ context.Context, d repository.NotificationDelivery) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, d)
+	return nil
+}
+
+func (l *memoryDeliveryLog) UpdateNotificationDelivery(_ context.Context, d repository.NotificationDelivery) error {
+	return l.CreateNotificationDelivery(context.Background(), d)
+}
+
+func TestQueue(t *testing.T) {
+	tests := []struct {
+		name             string
+		failures         int
+		expectedSent     bool
+		expectedStatuses []string
+	}{
+		{
+			name:             "Delivered First Time",
+			failures:         0,
+			expectedSent:     true,
+			expectedStatuses: []string{DeliveryQueued, DeliverySent},
+		},
+		{
+			name:             "Delivered After Retry",
+			failures:         2,
+			expectedSent:     true,
+			expectedStatuses: []string{DeliveryQueued, DeliveryRetrying, DeliveryRetrying, DeliverySent},
+		},
+		{
+			name:             "Failed After Max Attempts",
+			failures:         5,
+			expectedSent:     false,
+			expectedStatuses: []string{DeliveryQueued, DeliveryRetrying, DeliveryRetrying, DeliveryFailed},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := &flakyProvider{failures: tc.failures}
+			deliveries := &memoryDeliveryLog{}
+			q := NewQueue(provider, deliveries, QueueOptions{MaxAttempts: 3, Backoff: time.Millisecond})
+
+			err := q.Send(context.Background(), Message{
+				PhoneNumber: "+6281234567890",
+				Template:    TemplatePasswordReset,
+				Data:        map[string]any{"Code": "123456", "Minutes": 15},
+			})
+			assert.NoError(t, err)
+			assert.NoError(t, q.Close(context.Background()))
+
+			assert.Equal(t, tc.expectedSent, len(provider.sent) == 1)
+			var statuses []string
+			for _, entry := range deliveries.entries {
+				statuses = append(statuses, entry.Status)
+				assert.NotEmpty(t, entry.MessageID)
+				assert.Equal(t, "+6281234567890", entry.PhoneNumber)
+				assert.Equal(t, TemplatePasswordReset, entry.Template)
+				assert.Equal(t, "flaky", entry.Provider)
+			}
+			assert.Equal(t, tc.expectedStatuses, statuses)
+
+			last := deliveries.entries[len(deliveries.entries)-1]
+			assert.Equal(t, len(tc.expectedStatuses)-1, last.Attempts)
+			assert.Equal(t, tc.expectedSent, last.SentAt != nil)
+			assert.Equal(t, !tc.expectedSent, last.LastError != nil)
+		})
+	}
+}
+
+func TestQueueRejects(t *testing.T) {
+	provider := &flakyProvider{}
+	q := NewQueue(provider, nil, QueueOptions{})
+
+	err := q.Send(context.Background(), Message{PhoneNumber: "+6281234567890", Template: "welcome"})
+	assert.Error(t, err)
+
+	assert.NoError(t, q.Close(context.Background()))
+	err = q.Send(context.Background(), Message{PhoneNumber: "+6281234567890", Body: "Hello"})
+	assert.ErrorIs(t, err, ErrQueueClosed)
+	assert.Empty(t, provider.sent)
+}
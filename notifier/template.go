@@ -0,0 +1,32 @@
+package notifier
+
+import (
+	"strings"
+	"text/template"
+)
+
+// Names of the message templates, used as Message.Template.
+const (
+	TemplatePasswordReset     = "password_reset"
+	TemplatePhoneVerification = "phone_verification"
+)
+
+// templates holds the text of every message we send. Each one expects Code
+// and Minutes, how long the code is valid, in its data.
+var templates = template.Must(template.New("").Option("missingkey=error").Parse(`
+{{- define "password_reset" -}}
+Your password reset code is {{.Code}}. It expires in {{.Minutes}} minutes.
+{{- end -}}
+{{- define "phone_verification" -}}
+Your verification code is {{.Code}}. It expires in {{.Minutes}} minutes.
+{{- end -}}
+`))
+
+// Render returns the text of the template name filled in with data.
+func Render(name string, data map[string]any) (string, error) {
+	var text strings.Builder
+	if err := templates.ExecuteTemplate(&text, name, data); err != nil {
+		return "", err
+	}
+	return text.String(), nil
+}
@@ -0,0 +1,53 @@
+package notifier
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name          string
+		template      string
+		data          map[string]any
+		expectedText  string
+		expectedError bool
+	}{
+		{
+			name:         "Password Reset",
+			template:     TemplatePasswordReset,
+			data:         map[string]any{"Code": "123456", "Minutes": 15},
+			expectedText: "Your password reset code is 123456. It expires in 15 minutes.",
+		},
+		{
+			name:         "Phone Verification",
+			template:     TemplatePhoneVerification,
+			data:         map[string]any{"Code": "654321", "Minutes": 10},
+			expectedText: "Your verification code is 654321. It expires in 10 minutes.",
+		},
+		{
+			name:          "Missing Data",
+			template:      TemplatePasswordReset,
+			data:          map[string]any{"Code": "123456"},
+			expectedError: true,
+		},
+		{
+			name:          "Unknown Template",
+			template:      "welcome",
+			data:          map[string]any{},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			text, err := Render(tc.template, tc.data)
+			if tc.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedText, text)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// WebhookNotifier POSTs every message as JSON to URL, for SMS gateways that
+// take an HTTP request or for a local stub such as cmd/webhookstub:
+//
+//	{"id": "...", "phone_number": "+62812345678", "body": "..."}
+//
+// The message ID is also sent as the Idempotency-Key header, so the receiver
+// can drop a retried message it already delivered. Any status other than 2xx
+// is an error.
+type WebhookNotifier struct {
+	URL string
+	// Token, when set, is sent as a bearer token in the Authorization header.
+	Token  string
+	Client *http.Client
+}
+
+func NewWebhookNotifier(url string, token string) *WebhookNotifier {
+	return &WebhookNotifier{
+		URL:    url,
+		Token:  token,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (n *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+type webhookPayload struct {
+	ID          string `json:"id"`
+	PhoneNumber string `json:"phone_number"`
+	Body        string `json:"body"`
+}
+
+func (n *WebhookNotifier) Send(ctx context.Context, msg Message) error {
+	text, err := msg.Text()
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(webhookPayload{ID: msg.ID, PhoneNumber: msg.PhoneNumber, Body: text})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if msg.ID != "" {
+		req.Header.Set("Idempotency-Key", msg.ID)
+	}
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
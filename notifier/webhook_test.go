@@ -0,0 +1,60 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		token         string
+		expectedError bool
+	}{
+		{name: "Delivered", status: http.StatusNoContent},
+		{name: "Delivered With Token", status: http.StatusOK, token: "secret"},
+		{name: "Gateway Unavailable", status: http.StatusServiceUnavailable, expectedError: true},
+		{name: "Rejected", status: http.StatusBadRequest, expectedError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var payload webhookPayload
+			var header http.Header
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header
+				json.NewDecoder(r.Body).Decode(&payload)
+				w.WriteHeader(tc.status)
+			}))
+			defer server.Close()
+
+			n := NewWebhookNotifier(server.URL, tc.token)
+			err := n.Send(context.Background(), Message{
+				ID:          "mockMessageID",
+				PhoneNumber: "+6281234567890",
+				Template:    TemplatePhoneVerification,
+				Data:        map[string]any{"Code": "123456", "Minutes": 10},
+			})
+
+			if tc.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, "mockMessageID", payload.ID)
+			assert.Equal(t, "+6281234567890", payload.PhoneNumber)
+			assert.Equal(t, "Your verification code is 123456. It expires in 10 minutes.", payload.Body)
+			assert.Equal(t, "mockMessageID", header.Get("Idempotency-Key"))
+			if tc.token != "" {
+				assert.Equal(t, "Bearer "+tc.token, header.Get("Authorization"))
+			} else {
+				assert.Empty(t, header.Get("Authorization"))
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+// Package phone normalizes phone numbers to E.164, e.g. "+62812345678", so the
+// same number is stored and looked up the same way however it was typed.
+package phone
+
+import (
+	"errors"
+	"strings"
+)
+
+// MaxDigits is the most digits, calling code included, E.164 allows.
+const MaxDigits = 15
+
+var (
+	ErrEmpty              = errors.New("phone number is empty")
+	ErrInvalidCharacters  = errors.New("phone number may only contain digits after the leading +")
+	ErrMissingCountryCode = errors.New("phone number has no country calling code")
+	ErrUnknownCountryCode = errors.New("phone number has an unknown country calling code")
+	ErrInvalidLength      = errors.New("phone number has an invalid length for its country")
+)
+
+// Country holds the numbering rules of one country calling code. The lengths
+// count the national significant number, i.e. the digits after the calling
+// code without the trunk prefix.
+type Country struct {
+	Region      string
+	CallingCode string
+	TrunkPrefix string
+	MinLength   int
+	MaxLength   int
+}
+
+// Countries are the calling codes we accept. Numbers from anywhere else are
+// rejected with ErrUnknownCountryCode until their rules are added here.
+var Countries = []Country{
+	{Region: "ID", CallingCode: "62", TrunkPrefix: "0", MinLength: 8, MaxLength: 12},
+	{Region: "MY", CallingCode: "60", TrunkPrefix: "0", MinLength: 8, MaxLength: 10},
+	{Region: "SG", CallingCode: "65", MinLength: 8, MaxLength: 8},
+	{Region: "PH", CallingCode: "63", TrunkPrefix: "0", MinLength: 8, MaxLength: 10},
+	{Region: "TH", CallingCode: "66", TrunkPrefix: "0", MinLength: 8, MaxLength: 9},
+	{Region: "VN", CallingCode: "84", TrunkPrefix: "0", MinLength: 9, MaxLength: 10},
+	{Region: "AU", CallingCode: "61", TrunkPrefix: "0", MinLength: 9, MaxLength: 9},
+	{Region: "IN", CallingCode: "91", TrunkPrefix: "0", MinLength: 10, MaxLength: 10},
+	{Region: "CN", CallingCode: "86", TrunkPrefix: "0", MinLength: 10, MaxLength: 11},
+	{Region: "GB", CallingCode: "44", TrunkPrefix: "0", MinLength: 9, MaxLength: 10},
+	{Region: "US", CallingCode: "1", TrunkPrefix: "1", MinLength: 10, MaxLength: 10},
+}
+
+// CountryByRegion returns the rules for an ISO 3166 region code such as "ID".
+func CountryByRegion(region string) (Country, bool) {
+	for _, country := range Countries {
+		if country.Region == strings.ToUpper(region) {
+			return country, true
+		}
+	}
+	return Country{}, false
+}
+
+func countryByCallingCode(digits string) (Country, bool) {
+	// Calling codes are prefix-free, so at most one of them matches
+	for _, country := range Countries {
+		if strings.HasPrefix(digits, country.CallingCode) {
+			return country, true
+		}
+	}
+	return Country{}, false
+}
+
+// Normalize returns input in E.164. It accepts the international forms
+// "+62 812-345-678" and "0062812345678", and, when defaultRegion is set, the
+// national form "0812345678" of that region. Spaces, dashes, dots and
+// parentheses are ignored, as is a trunk prefix written after the calling
+// code, as in "+62 0812 345 678".
+func Normalize(input, defaultRegion string) (string, error) {
+	number := stripSeparators(input)
+	if number == "" {
+		return "", ErrEmpty
+	}
+
+	var digits string
+	switch {
+	case strings.HasPrefix(number, "+"):
+		digits = number[1:]
+	case strings.HasPrefix(number, "00"):
+		digits = number[2:]
+	default:
+		country, ok := CountryByRegion(defaultRegion)
+		if !ok {
+			return "", ErrMissingCountryCode
+		}
+		digits = country.CallingCode + number
+	}
+
+	if digits == "" || strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+		return "", ErrInvalidCharacters
+	}
+
+	country, ok := countryByCallingCode(digits)
+	if !ok {
+		return "", ErrUnknownCountryCode
+	}
+	// No national number starts with its trunk prefix, so one found there was
+	// typed out of habit
+	national := digits[len(country.CallingCode):]
+	if country.TrunkPrefix != "" {
+		national = strings.TrimPrefix(national, country.TrunkPrefix)
+	}
+	if len(national) < country.MinLength || len(national) > country.MaxLength ||
+		len(country.CallingCode)+len(national) > MaxDigits {
+		return "", ErrInvalidLength
+	}
+
+	return "+" + country.CallingCode + national, nil
+}
+
+// NormalizePrefix returns the start of the E.164 form of a phone number from
+// the start of that number typed in any form Normalize accepts, e.g. "+62812"
+// for "0812" with defaultRegion "ID", to find the numbers beginning with it.
+// It returns false when input can't be the start of a phone number.
+func NormalizePrefix(input, defaultRegion string) (string, bool) {
+	number := stripSeparators(input)
+
+	var digits string
+	switch {
+	case strings.HasPrefix(number, "+"):
+		digits = number[1:]
+	case strings.HasPrefix(number, "00"):
+		digits = number[2:]
+	default:
+		country, ok := CountryByRegion(defaultRegion)
+		if !ok {
+			return "", false
+		}
+		digits = country.CallingCode + number
+	}
+
+	if digits == "" || strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+		return "", false
+	}
+
+	if country, ok := countryByCallingCode(digits); ok && country.TrunkPrefix != "" {
+		national := strings.TrimPrefix(digits[len(country.CallingCode):], country.TrunkPrefix)
+		digits = country.CallingCode + national
+	}
+	return "+" + digits, true
+}
+
+func stripSeparators(input string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\u00a0', '-', '.', '(', ')':
+			return -1
+		}
+		return r
+	}, input)
+}
@@ -0,0 +1,80 @@
+package phone
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		defaultRegion string
+		expected      string
+		expectedError error
+	}{
+		{"E.164", "+62812345678", "", "+62812345678", nil},
+		{"With Separators", "+62 (812) 345-678", "", "+62812345678", nil},
+		{"International Prefix", "0062812345678", "", "+62812345678", nil},
+		{"Trunk Prefix After Calling Code", "+62 0812 345 678", "", "+62812345678", nil},
+		{"National Form With Default Region", "0812-345-678", "ID", "+62812345678", nil},
+		{"National Form Without Default Region", "0812345678", "", "", ErrMissingCountryCode},
+		{"Other Country", "+1 (202) 555-0123", "ID", "+12025550123", nil},
+		{"Empty", "  ", "", "", ErrEmpty},
+		{"Sign After Plus", "+-5", "", "", ErrUnknownCountryCode},
+		{"Letters", "+1234567890a", "", "", ErrInvalidCharacters},
+		{"Plus Only", "+", "", "", ErrInvalidCharacters},
+		{"Unknown Calling Code", "+999123456789", "", "", ErrUnknownCountryCode},
+		{"Too Short For Country", "+621234567", "", "", ErrInvalidLength},
+		{"Too Long For Country", "+628123456789012", "", "", ErrInvalidLength},
+		{"Would Overflow An Integer", "+62" + strings.Repeat("8", 30), "", "", ErrInvalidLength},
+		{"Too Short For NANP", "+1234567890", "", "", ErrInvalidLength},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := Normalize(tc.input, tc.defaultRegion)
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+}
+
+func TestNormalizePrefix(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		defaultRegion string
+		expected      string
+		expectedOK    bool
+	}{
+		{"E.164", "+62812", "", "+62812", true},
+		{"National Form With Default Region", "0812-3", "ID", "+628123", true},
+		{"National Form Without Default Region", "0812", "", "", false},
+		{"International Prefix", "00 62 812", "", "+62812", true},
+		{"Trunk Prefix After Calling Code", "+62 08", "", "+628", true},
+		{"Calling Code Not Finished", "+6", "", "+6", true},
+		{"Name", "budi", "ID", "", false},
+		{"Plus Only", "+", "", "", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result, ok := NormalizePrefix(tc.input, tc.defaultRegion)
+			assert.Equal(t, tc.expectedOK, ok)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+}
+
+func TestCountryCallingCodesArePrefixFree(t *testing.T) {
+	for _, a := range Countries {
+		for _, b := range Countries {
+			if a.Region != b.Region {
+				assert.False(t, strings.HasPrefix(a.CallingCode, b.CallingCode),
+					"%s calling code %s starts with %s's %s", a.Region, a.CallingCode, b.Region, b.CallingCode)
+			}
+		}
+	}
+}
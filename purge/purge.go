@@ -0,0 +1,89 @@
+// Package purge permanently removes deleted accounts once their grace period
+// is over.
+package purge
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Store removes deleted users whose grace period ended before now, at most
+// limit of them, and returns how many it removed.
+// repository.RepositoryInterface implements it.
+type Store interface {
+	PurgeDeletedUsers(ctx context.Context, now time.Time, limit int) (int, error)
+}
+
+// Options controls how often the purger looks for accounts to remove and how
+// many it removes in one transaction.
+type Options struct {
+	Interval  time.Duration
+	BatchSize int
+}
+
+var DefaultOptions = Options{
+	Interval:  time.Hour,
+	BatchSize: 100,
+}
+
+func (o Options) withDefaults() Options {
+	if o.Interval <= 0 {
+		o.Interval = DefaultOptions.Interval
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultOptions.BatchSize
+	}
+	return o
+}
+
+type Purger struct {
+	store Store
+	opts  Options
+	now   func() time.Time
+}
+
+func NewPurger(store Store, opts Options) *Purger {
+	return &Purger{
+		store: store,
+		opts:  opts.withDefaults(),
+		now:   time.Now,
+	}
+}
+
+// Run purges right away and then every Interval until ctx ends. Failures are
+// logged and retried on the next round.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := p.Purge(ctx)
+		if err != nil {
+			log.Println("purging deleted users:", err)
+		}
+		if purged > 0 {
+			log.Printf("purged %d deleted users", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge removes every account due for removal, BatchSize at a time, and
+// returns how many it removed.
+func (p *Purger) Purge(ctx context.Context) (int, error) {
+	now := p.now()
+	total := 0
+	for {
+		purged, err := p.store.PurgeDeletedUsers(ctx, now, p.opts.BatchSize)
+		total += purged
+		if err != nil || purged < p.opts.BatchSize {
+			return total, err
+		}
+	}
+}
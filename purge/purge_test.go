@@ -0,0 +1,209 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SawitProRecruitment/UserService/migrations"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	mu    sync.Mutex
+	due   int
+	err   error
+	calls []int
+	now   time.Time
+}
+
+func (f *fakeStore) PurgeDeletedUsers(_ context.Context, now time.Time, limit int) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, limit)
+	f.now = now
+	if f.err != nil {
+		return 0, f.err
+	}
+	purged := limit
+	if f.due < limit {
+		purged = f.due
+	}
+	f.due -= purged
+	return purged, nil
+}
+
+func (f *fakeStore) remaining() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.due
+}
+
+func TestPurge(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name          string
+		store         *fakeStore
+		expected      int
+		expectedCalls int
+		expectedErr   bool
+	}{
+		{
+			name:          "Nothing Due",
+			store:         &fakeStore{},
+			expected:      0,
+			expectedCalls: 1,
+		},
+		{
+			name:          "Fewer Than A Batch",
+			store:         &fakeStore{due: 3},
+			expected:      3,
+			expectedCalls: 1,
+		},
+		{
+			name:          "Several Batches",
+			store:         &fakeStore{due: 25},
+			expected:      25,
+			expectedCalls: 3,
+		},
+		{
+			name:          "Exactly One Batch",
+			store:         &fakeStore{due: 10},
+			expected:      10,
+			expectedCalls: 2,
+		},
+		{
+			name:          "Store Fails",
+			store:         &fakeStore{due: 5, err: errors.New("database is down")},
+			expected:      0,
+			expectedCalls: 1,
+			expectedErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			purger := NewPurger(test.store, Options{BatchSize: 10})
+			purger.now = func() time.Time { return now }
+
+			purged, err := purger.Purge(context.Background())
+			assert.Equal(t, test.expected, purged)
+			assert.Equal(t, test.expectedErr, err != nil)
+			assert.Len(t, test.store.calls, test.expectedCalls)
+			assert.Equal(t, now, test.store.now)
+		})
+	}
+}
+
+func TestRunStopsWithContext(t *testing.T) {
+	store := &fakeStore{due: 1}
+	purger := NewPurger(store, Options{Interval: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		purger.Run(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return store.remaining() == 0 }, time.Second, time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after its context ended")
+	}
+}
+
+// TestPurgeRedacts purges a user from the PostgreSQL at DATABASE_URL, migrated
+// to the latest version, and checks what is left of them.
+func TestPurgeRedacts(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	repo := repository.NewRepository(repository.NewRepositoryOptions{Dsn: dsn})
+	defer repo.Db.Close()
+	migrator, err := migrations.NewMigrator(repo.Db, migrations.Files())
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = migrator.Up(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	now := time.Now()
+	userID := uuid.New().String()
+	phoneNumber := fmt.Sprintf("+628%08d", now.UnixNano()%1e8)
+	messageID := uuid.New().String()
+	assert.NoError(t, repo.RegisterUser(ctx, repository.User{
+		UserID:      userID,
+		FullName:    "John Doe",
+		PhoneNumber: phoneNumber,
+		Password:    "hash",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}))
+	assert.NoError(t, repo.CreateAuditEvent(ctx, repository.AuditEvent{
+		UserID:    userID,
+		EventType: "register",
+		IPAddress: "192.0.2.1",
+		UserAgent: "curl/8.0",
+		Changes:   []byte(`{"phone_number":{"new":"` + phoneNumber + `"}}`),
+		CreatedAt: now,
+	}))
+	assert.NoError(t, repo.CreateNotificationDelivery(ctx, repository.NotificationDelivery{
+		MessageID:   messageID,
+		PhoneNumber: phoneNumber,
+		Template:    "phone_verification",
+		Provider:    "log",
+		Status:      "sent",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}))
+	assert.NoError(t, repo.DeleteUser(ctx, repository.DeleteUserInput{
+		UserID:           userID,
+		DeletedAt:        now.Add(-time.Hour),
+		TokensValidAfter: now.Add(-time.Hour),
+		PurgeAfter:       now.Add(-time.Minute),
+	}))
+
+	_, err = NewPurger(repo, Options{}).Purge(ctx)
+	assert.NoError(t, err)
+
+	count, err := repo.CheckPhoneNumber(ctx, phoneNumber)
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+
+	var eventID int64
+	var ipAddress, userAgent string
+	var changes []byte
+	var redactedAt *time.Time
+	err = repo.Db.QueryRowContext(ctx, "SELECT id, ip_address, user_agent, changes, redacted_at FROM audit_events"+
+		" WHERE user_id = $1", userID).Scan(&eventID, &ipAddress, &userAgent, &changes, &redactedAt)
+	assert.NoError(t, err)
+	assert.Empty(t, ipAddress)
+	assert.Empty(t, userAgent)
+	assert.Nil(t, changes)
+	assert.NotNil(t, redactedAt)
+
+	var deliveredTo string
+	err = repo.Db.QueryRowContext(ctx, "SELECT phone_number FROM notification_deliveries WHERE message_id = $1",
+		messageID).Scan(&deliveredTo)
+	assert.NoError(t, err)
+	assert.Empty(t, deliveredTo)
+
+	// Redaction is the only way to change the audit log
+	_, err = repo.Db.ExecContext(ctx, "UPDATE audit_events SET reason = 'edited' WHERE id = $1", eventID)
+	assert.ErrorContains(t, err, "audit_events is append-only")
+	_, err = repo.Db.ExecContext(ctx, "DELETE FROM audit_events WHERE id = $1", eventID)
+	assert.ErrorContains(t, err, "audit_events is append-only")
+}
@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"log"
+)
+
+// Kinds of repository failure. Every error returned by Repository matches one
+// of them with errors.Is.
+var (
+	// ErrNotFound means the row asked for does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the write would break a unique constraint, or the row
+	// is not in the state the write expects.
+	ErrConflict = errors.New("conflict")
+	// ErrUnavailable means the database could not answer, e.g. it is down or
+	// the query timed out.
+	ErrUnavailable = errors.New("unavailable")
+)
+
+// Unique constraints of the schema in migrations, as reported in Error.Constraint.
+const (
+	ConstraintUsersUserID      = "users_user_id_key"
+	ConstraintUsersPhoneNumber = "users_phone_number_key"
+)
+
+// PostgreSQL error codes dbError tells apart.
+const (
+	uniqueViolation = "23505"
+	// invalidTextRepresentation is returned for a user_id that is not a uuid.
+	invalidTextRepresentation = "22P02"
+	// queryCanceled is returned for a query lib/pq cancelled because its
+	// context was done.
+	queryCanceled = "57014"
+)
+
+// Error is a failed repository operation. Op names the method, Kind is one of
+// ErrNotFound, ErrConflict and ErrUnavailable, and Err is the cause, e.g. the
+// driver error, if any.
+type Error struct {
+	Op   string
+	Kind error
+	// Constraint is the constraint a conflicting write violated, if known.
+	Constraint string
+	Err        error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Op + ": " + e.Kind.Error()
+	}
+	return e.Op + ": " + e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// dbError classifies err, returned by the database during op. A malformed
+// uuid can't match any row, so it counts as not found. Failures other than a
+// missing row or a unique violation are logged, since they point at the
+// database rather than the caller.
+//
+// A query cut off by the deadline of ctx comes back from lib/pq as
+// query_canceled rather than as the context error, so the error of a done ctx
+// is wrapped in for callers to tell a timeout from an outage.
+func dbError(ctx context.Context, op string, err error) error {
+	var pqErr *pq.Error
+	if ctxErr := ctx.Err(); ctxErr != nil && (errors.Is(err, ctxErr) || errors.As(err, &pqErr) && pqErr.Code == queryCanceled) {
+		if !errors.Is(err, ctxErr) {
+			err = fmt.Errorf("%w: %w", ctxErr, err)
+		}
+		return &Error{Op: op, Kind: ErrUnavailable, Err: err}
+	}
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return &Error{Op: op, Kind: ErrNotFound, Err: err}
+	case errors.As(err, &pqErr) && pqErr.Code == invalidTextRepresentation:
+		return &Error{Op: op, Kind: ErrNotFound, Err: err}
+	case errors.As(err, &pqErr) && pqErr.Code == uniqueViolation:
+		return &Error{Op: op, Kind: ErrConflict, Constraint: pqErr.Constraint, Err: err}
+	}
+	log.Println(op, err)
+	return &Error{Op: op, Kind: ErrUnavailable, Err: err}
+}
+
+// IsConflictOn reports whether err is a write that broke the unique constraint
+// named constraint.
+func IsConflictOn(err error, constraint string) bool {
+	var repoErr *Error
+	return errors.As(err, &repoErr) && repoErr.Kind == ErrConflict && repoErr.Constraint == constraint
+}
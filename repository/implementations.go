@@ -2,83 +2,1158 @@ package repository
 
 import (
 	"context"
-	"errors"
-	"log"
+	"database/sql"
+	"github.com/lib/pq"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func (r *Repository) RegisterUser(input User) error {
-	_, err := r.Db.Exec("INSERT INTO users ("+
+func (r *Repository) RegisterUser(ctx context.Context, input User) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := r.Db.ExecContext(ctx, "INSERT INTO users ("+
 		"user_id, full_name, phone_number, password, successfull_login_attempts, last_login,"+
 		"created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)", input.UserID, input.FullName,
 		input.PhoneNumber, input.Password, input.SuccessfullLoginAttempts, input.LastLogin,
 		input.CreatedAt, input.UpdatedAt)
 	if err != nil {
-		log.Println(err)
-		return errors.New("cannot Register the user")
+		return dbError(ctx, "RegisterUser", err)
 	}
 	return err
 }
 
-// used for login
-func (r *Repository) CheckUser(ctx context.Context, phoneNumber string) (*User, error) {
+// userColumns are the columns of users that scanUser reads, in its order.
+const userColumns = "id, user_id, full_name, phone_number, password, successfull_login_attempts, last_login," +
+	" failed_login_attempts, locked_until, phone_verified_at, pending_phone_number, totp_secret, totp_enabled_at," +
+	" role, disabled_at, created_at"
+
+// rowScanner is a *sql.Row or *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser reads the userColumns of row, followed by any extra columns into
+// extra.
+func scanUser(row rowScanner, extra ...interface{}) (*User, error) {
 	output := User{}
-	err := r.Db.QueryRowContext(ctx, "SELECT id, user_id, full_name, phone_number, password,"+
-		" successfull_login_attempts, last_login FROM users WHERE phone_number = $1", phoneNumber).
-		Scan(&output.ID, &output.UserID, &output.FullName, &output.PhoneNumber, &output.Password, &output.SuccessfullLoginAttempts,
-			&output.LastLogin)
+	err := row.Scan(append([]interface{}{&output.ID, &output.UserID, &output.FullName, &output.PhoneNumber, &output.Password,
+		&output.SuccessfullLoginAttempts, &output.LastLogin, &output.FailedLoginAttempts, &output.LockedUntil,
+		&output.PhoneVerifiedAt, &output.PendingPhoneNumber, &output.TOTPSecret, &output.TOTPEnabledAt, &output.Role,
+		&output.DisabledAt, &output.CreatedAt}, extra...)...)
 	if err != nil {
-		log.Println(err)
-		return nil, errors.New("there is problem in our system when performing query. please wait")
+		return nil, err
 	}
 	return &output, nil
 }
 
-func (r *Repository) UpdateLoginUser(ctx context.Context, input User) error {
-	_, err := r.Db.ExecContext(ctx, "UPDATE users SET successfull_login_attempts = $1, last_login = $2"+
-		" WHERE id = $3", input.SuccessfullLoginAttempts, input.LastLogin, input.ID)
+// used for login
+func (r *Repository) CheckUser(ctx context.Context, phoneNumber string) (*User, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	output, err := scanUser(r.Db.QueryRowContext(ctx, "SELECT "+userColumns+
+		" FROM users WHERE phone_number = $1 AND deleted_at IS NULL", phoneNumber))
 	if err != nil {
-		log.Println(err)
-		return errors.New("there is problem in our system when performing login. please wait")
+		return nil, dbError(ctx, "CheckUser", err)
 	}
-	return err
+	return output, nil
+}
+
+// RecordLoginSuccess counts a successful login of the user with id and clears
+// their failed attempts. The counters are updated relative to what is stored,
+// so attempts reserved by concurrent logins aren't overwritten.
+func (r *Repository) RecordLoginSuccess(ctx context.Context, id int, loggedInAt time.Time) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := r.Db.ExecContext(ctx, "UPDATE users SET successfull_login_attempts = successfull_login_attempts + 1,"+
+		" last_login = $1, failed_login_attempts = 0, locked_until = NULL WHERE id = $2 AND deleted_at IS NULL",
+		loggedInAt, id)
+	if err != nil {
+		return dbError(ctx, "RecordLoginSuccess", err)
+	}
+	return nil
+}
+
+// ResetFailedLoginAttempts clears the failed attempts of the user with id
+// without counting a successful login, for a right password that still
+// doesn't log the user in.
+func (r *Repository) ResetFailedLoginAttempts(ctx context.Context, id int) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := r.Db.ExecContext(ctx, "UPDATE users SET failed_login_attempts = 0, locked_until = NULL"+
+		" WHERE id = $1 AND deleted_at IS NULL", id)
+	if err != nil {
+		return dbError(ctx, "ResetFailedLoginAttempts", err)
+	}
+	return nil
+}
+
+// ClearLoginLock lifts the lock of the user with id but keeps the count of
+// failed attempts, so the next attempt is let through and locks the account
+// again unless it succeeds.
+func (r *Repository) ClearLoginLock(ctx context.Context, id int) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := r.Db.ExecContext(ctx, "UPDATE users SET locked_until = NULL WHERE id = $1 AND deleted_at IS NULL", id)
+	if err != nil {
+		return dbError(ctx, "ClearLoginLock", err)
+	}
+	return nil
+}
+
+// ReserveLoginAttempt counts a login attempt in a single statement and reports
+// whether it may go ahead. It returns false while the account is locked. The
+// attempt that reaches MaxFailedAttempts locks the account; a successful login
+// clears the counter again through RecordLoginSuccess.
+func (r *Repository) ReserveLoginAttempt(ctx context.Context, input LoginAttemptInput) (bool, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE users SET"+
+		" failed_login_attempts = CASE WHEN locked_until IS NOT NULL THEN 1 ELSE failed_login_attempts + 1 END,"+
+		" locked_until = CASE WHEN (CASE WHEN locked_until IS NOT NULL THEN 1 ELSE failed_login_attempts + 1 END) >= $1"+
+		" THEN $2::timestamptz ELSE NULL END"+
+		" WHERE id = $3 AND deleted_at IS NULL AND (locked_until IS NULL OR locked_until <= $4)",
+		input.MaxFailedAttempts, input.LockedUntil, input.ID, input.AttemptedAt)
+	if err != nil {
+		return false, dbError(ctx, "ReserveLoginAttempt", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, dbError(ctx, "ReserveLoginAttempt", err)
+	}
+	return affected == 1, nil
+}
+
+func (r *Repository) UnlockUser(ctx context.Context, userID string) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE users SET failed_login_attempts = 0, locked_until = NULL"+
+		" WHERE user_id = $1 AND deleted_at IS NULL", userID)
+	if err != nil {
+		return dbError(ctx, "UnlockUser", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return dbError(ctx, "UnlockUser", err)
+	}
+	if affected == 0 {
+		return &Error{Op: "UnlockUser", Kind: ErrNotFound}
+	}
+	return nil
 }
 
 func (r *Repository) GetUserByUserId(ctx context.Context, userID string) (*User, error) {
-	output := User{}
-	err := r.Db.QueryRowContext(ctx, "SELECT id, user_id, full_name, phone_number, password,"+
-		" successfull_login_attempts, last_login FROM users WHERE user_id = $1", userID).
-		Scan(&output.ID, &output.UserID, &output.FullName, &output.PhoneNumber, &output.Password, &output.SuccessfullLoginAttempts,
-			&output.LastLogin)
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	output, err := scanUser(r.Db.QueryRowContext(ctx, "SELECT "+userColumns+
+		" FROM users WHERE user_id = $1 AND deleted_at IS NULL", userID))
 	if err != nil {
-		log.Println(err)
-		return nil, errors.New("there is problem in our system when performing query. please wait")
+		return nil, dbError(ctx, "GetUserByUserId", err)
+	}
+	return output, nil
+}
 
+// ListUsers returns a page of the users that are not deleted, with keyset
+// pagination: the page starts right after input.After, or ends right before
+// input.Before, so deep pages cost as much as the first one. Every sort has a
+// matching index, see migration 0016.
+func (r *Repository) ListUsers(ctx context.Context, input ListUsersInput) (*UserPage, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
 	}
-	return &output, nil
+
+	where := []string{"deleted_at IS NULL"}
+	if input.CreatedFrom != nil {
+		where = append(where, "created_at >= "+arg(*input.CreatedFrom))
+	}
+	if input.CreatedTo != nil {
+		where = append(where, "created_at < "+arg(*input.CreatedTo))
+	}
+	if input.LastLoginFrom != nil {
+		where = append(where, "last_login >= "+arg(*input.LastLoginFrom))
+	}
+	if input.LastLoginTo != nil {
+		where = append(where, "last_login < "+arg(*input.LastLoginTo))
+	}
+	if input.NamePrefix != "" {
+		where = append(where, "lower(full_name) LIKE lower("+arg(likeEscaper.Replace(input.NamePrefix))+") || '%'")
+	}
+	if input.PhonePrefix != "" {
+		where = append(where, "phone_number LIKE "+arg(likeEscaper.Replace(input.PhonePrefix))+" || '%'")
+	}
+	switch input.Status {
+	case UserStatusActive:
+		where = append(where, "disabled_at IS NULL AND (locked_until IS NULL OR locked_until <= "+arg(input.Now)+")")
+	case UserStatusDisabled:
+		where = append(where, "disabled_at IS NOT NULL")
+	case UserStatusLocked:
+		where = append(where, "locked_until > "+arg(input.Now))
+	}
+
+	// A page before a cursor is read backwards from it and turned around
+	sortExpr, sortType := userSort(input.Sort)
+	descending, cursor := input.Descending, input.After
+	if input.Before != nil {
+		descending, cursor = !descending, input.Before
+	}
+	direction, compare := " ASC", " > "
+	if descending {
+		direction, compare = " DESC", " < "
+	}
+	if cursor != nil {
+		where = append(where, "("+sortExpr+", id)"+compare+"("+arg(cursor.Value)+"::"+sortType+", "+arg(cursor.ID)+")")
+	}
+
+	// One extra row tells whether there is another page
+	rows, err := r.Db.QueryContext(ctx, "SELECT "+userColumns+" FROM users WHERE "+strings.Join(where, " AND ")+
+		" ORDER BY "+sortExpr+direction+", id"+direction+" LIMIT "+arg(input.Limit+1), args...)
+	if err != nil {
+		return nil, dbError(ctx, "ListUsers", err)
+	}
+	users, err := scanUsers(ctx, "ListUsers", rows)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &UserPage{Users: users}
+	if len(users) > input.Limit {
+		page.Users, page.HasMore = users[:input.Limit], true
+	}
+	if input.Before != nil {
+		for i, j := 0, len(page.Users)-1; i < j; i, j = i+1, j-1 {
+			page.Users[i], page.Users[j] = page.Users[j], page.Users[i]
+		}
+	}
+	return page, nil
+}
+
+// userSort returns the expression ListUsers orders by for sort, and the type
+// of its cursor values. Users who never logged in sort as -infinity, which
+// is also their User.Cursor value.
+func userSort(sort UserSort) (string, string) {
+	switch sort {
+	case SortByLastLogin:
+		return "coalesce(last_login, '-infinity'::timestamptz)", "timestamptz"
+	case SortByFullName:
+		return "full_name", "text"
+	default:
+		return "created_at", "timestamptz"
+	}
+}
+
+// SearchSimilarityThreshold is how alike, from 0 to 1, a word of a name has
+// to be to the query for SearchUsers to find it. pg_trgm's default of 0.6
+// misses most typos.
+const SearchSimilarityThreshold = 0.3
+
+// SearchUsers finds users by name with the trigram index of migration 0017,
+// which also serves names containing the query, and by phone number prefix,
+// best match first.
+func (r *Repository) SearchUsers(ctx context.Context, input UserSearchInput) ([]UserMatch, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.Db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, dbError(ctx, "SearchUsers", err)
+	}
+	defer tx.Rollback()
+
+	// The threshold of <%, for this transaction only
+	_, err = tx.ExecContext(ctx, "SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)",
+		strconv.FormatFloat(SearchSimilarityThreshold, 'f', -1, 64))
+	if err != nil {
+		return nil, dbError(ctx, "SearchUsers", err)
+	}
+
+	pattern, phonePrefix := likeEscaper.Replace(input.Query), likeEscaper.Replace(input.PhonePrefix)
+	rows, err := tx.QueryContext(ctx, "SELECT "+userColumns+", greatest("+
+		" word_similarity($1, full_name),"+
+		" CASE WHEN full_name ILIKE $2 || '%' THEN 1 WHEN full_name ILIKE '%' || $2 || '%' THEN 0.9 ELSE 0 END,"+
+		" CASE WHEN $3 <> '' AND phone_number LIKE $3 || '%' THEN 1 ELSE 0 END) AS rank"+
+		" FROM users WHERE deleted_at IS NULL"+
+		" AND ($1 <% full_name OR full_name ILIKE '%' || $2 || '%' OR ($3 <> '' AND phone_number LIKE $3 || '%'))"+
+		" ORDER BY rank DESC, full_name, id LIMIT $4", input.Query, pattern, phonePrefix, input.Limit)
+	if err != nil {
+		return nil, dbError(ctx, "SearchUsers", err)
+	}
+	defer rows.Close()
+
+	output := []UserMatch{}
+	for rows.Next() {
+		var match UserMatch
+		user, err := scanUser(rows, &match.Rank)
+		if err != nil {
+			return nil, dbError(ctx, "SearchUsers", err)
+		}
+		match.User = *user
+		output = append(output, match)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError(ctx, "SearchUsers", err)
+	}
+	return output, nil
+}
+
+// likeEscaper keeps the wildcards of LIKE in a search query literal.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func scanUsers(ctx context.Context, op string, rows *sql.Rows) ([]User, error) {
+	defer rows.Close()
+
+	output := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, dbError(ctx, op, err)
+		}
+		output = append(output, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError(ctx, op, err)
+	}
+	return output, nil
+}
+
+// DisableUser keeps the user from logging in until EnableUser is called. It
+// leaves their tokens alone, see RevokeAllUserTokens.
+func (r *Repository) DisableUser(ctx context.Context, userID string, disabledAt time.Time) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE users SET disabled_at = $1, updated_at = $1"+
+		" WHERE user_id = $2 AND deleted_at IS NULL AND disabled_at IS NULL", disabledAt, userID)
+	if err != nil {
+		return dbError(ctx, "DisableUser", err)
+	}
+	return r.expectUser(ctx, "DisableUser", res, userID)
+}
+
+func (r *Repository) EnableUser(ctx context.Context, userID string, enabledAt time.Time) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE users SET disabled_at = NULL, updated_at = $1"+
+		" WHERE user_id = $2 AND deleted_at IS NULL AND disabled_at IS NOT NULL", enabledAt, userID)
+	if err != nil {
+		return dbError(ctx, "EnableUser", err)
+	}
+	return r.expectUser(ctx, "EnableUser", res, userID)
+}
+
+func (r *Repository) SetUserRole(ctx context.Context, userID string, role string, updatedAt time.Time) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE users SET role = $1, updated_at = $2"+
+		" WHERE user_id = $3 AND deleted_at IS NULL", role, updatedAt, userID)
+	if err != nil {
+		return dbError(ctx, "SetUserRole", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return dbError(ctx, "SetUserRole", err)
+	}
+	if affected == 0 {
+		return &Error{Op: "SetUserRole", Kind: ErrNotFound}
+	}
+	return nil
+}
+
+// expectUser tells apart why an update of the user changed no row: the user
+// doesn't exist, or is not in the state the update expects.
+func (r *Repository) expectUser(ctx context.Context, op string, res sql.Result, userID string) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return dbError(ctx, op, err)
+	}
+	if affected == 1 {
+		return nil
+	}
+	count := 0
+	err = r.Db.QueryRowContext(ctx, "SELECT count(id) FROM users WHERE user_id = $1 AND deleted_at IS NULL", userID).
+		Scan(&count)
+	if err != nil {
+		return dbError(ctx, op, err)
+	}
+	if count == 0 {
+		return &Error{Op: op, Kind: ErrNotFound}
+	}
+	return &Error{Op: op, Kind: ErrConflict}
 }
 
 func (r *Repository) UpdateUserProfile(ctx context.Context, input User) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
 	_, err := r.Db.ExecContext(ctx, "UPDATE users SET "+
-		"phone_number = $1, full_name = $2"+
-		" WHERE id = $3", input.PhoneNumber, input.FullName, input.ID)
+		"phone_number = $1, full_name = $2, pending_phone_number = $3"+
+		" WHERE id = $4 AND deleted_at IS NULL", input.PhoneNumber, input.FullName, input.PendingPhoneNumber, input.ID)
+	if err != nil {
+		return dbError(ctx, "UpdateUserProfile", err)
+	}
+	return err
+}
+
+func (r *Repository) UpdatePassword(ctx context.Context, userID string, hashedPassword string, updatedAt time.Time) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := r.Db.ExecContext(ctx, "UPDATE users SET password = $1, updated_at = $2"+
+		" WHERE user_id = $3 AND deleted_at IS NULL", hashedPassword, updatedAt, userID)
 	if err != nil {
-		log.Println(err)
-		return errors.New("there is problem in our system when updating profile. please wait")
+		return dbError(ctx, "UpdatePassword", err)
 	}
 	return err
 }
 
+// RehashPassword swaps the stored hash for one made with the current hasher
+// settings. It only applies while the old hash is still stored, so it never
+// undoes a password change that happened in between.
+func (r *Repository) RehashPassword(ctx context.Context, userID string, oldHash string, newHash string) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := r.Db.ExecContext(ctx, "UPDATE users SET password = $1 WHERE user_id = $2 AND password = $3 AND deleted_at IS NULL",
+		newHash, userID, oldHash)
+	if err != nil {
+		return dbError(ctx, "RehashPassword", err)
+	}
+	return err
+}
+
+// CheckPhoneNumber counts the users, deleted ones aside, registered with
+// phoneNumber. A deleted user still holds the number through the unique
+// constraint until they are purged, but callers must not find them.
 func (r *Repository) CheckPhoneNumber(ctx context.Context, phoneNumber string) (int64, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
 	count := 0
-	err := r.Db.QueryRowContext(ctx, "SELECT count(id) FROM users WHERE phone_number = $1", phoneNumber).
+	err := r.Db.QueryRowContext(ctx, "SELECT count(id) FROM users WHERE phone_number = $1 AND deleted_at IS NULL",
+		phoneNumber).
 		Scan(&count)
 	if err != nil {
-		log.Println(err)
-		return 0, errors.New("there is problem in our system when performing query. please wait")
+		return 0, dbError(ctx, "CheckPhoneNumber", err)
 	}
 	return int64(count), nil
 }
 
+// DeleteUser soft deletes the user and revokes every token issued to them.
+func (r *Repository) DeleteUser(ctx context.Context, input DeleteUserInput) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(ctx, "DeleteUser", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE users SET deleted_at = $1, purge_after = $2, tokens_valid_after = $3,"+
+		" updated_at = $1 WHERE user_id = $4 AND deleted_at IS NULL", input.DeletedAt, input.PurgeAfter,
+		input.TokensValidAfter, input.UserID)
+	if err != nil {
+		return dbError(ctx, "DeleteUser", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return dbError(ctx, "DeleteUser", err)
+	}
+	if affected == 0 {
+		return &Error{Op: "DeleteUser", Kind: ErrNotFound}
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE token_families SET revoked_at = $1"+
+		" WHERE user_id = $2 AND revoked_at IS NULL", input.DeletedAt, input.UserID)
+	if err != nil {
+		return dbError(ctx, "DeleteUser", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dbError(ctx, "DeleteUser", err)
+	}
+	return nil
+}
+
+// PurgeDeletedUsers permanently removes up to limit deleted users whose
+// purge_after has passed, along with their tokens and codes, and returns how
+// many it removed. Their audit events are kept but redacted, and the phone
+// numbers they held are blanked out of notification_deliveries.
+func (r *Repository) PurgeDeletedUsers(ctx context.Context, now time.Time, limit int) (int, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, dbError(ctx, "PurgeDeletedUsers", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT user_id, phone_number, pending_phone_number FROM users"+
+		" WHERE purge_after <= $1 ORDER BY purge_after LIMIT $2 FOR UPDATE SKIP LOCKED", now, limit)
+	if err != nil {
+		return 0, dbError(ctx, "PurgeDeletedUsers", err)
+	}
+	userIDs := []string{}
+	phoneNumbers := []string{}
+	for rows.Next() {
+		var userID, phoneNumber string
+		var pendingPhoneNumber *string
+		if err := rows.Scan(&userID, &phoneNumber, &pendingPhoneNumber); err != nil {
+			rows.Close()
+			return 0, dbError(ctx, "PurgeDeletedUsers", err)
+		}
+		userIDs = append(userIDs, userID)
+		phoneNumbers = append(phoneNumbers, phoneNumber)
+		if pendingPhoneNumber != nil {
+			phoneNumbers = append(phoneNumbers, *pendingPhoneNumber)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, dbError(ctx, "PurgeDeletedUsers", err)
+	}
+	if len(userIDs) == 0 {
+		return 0, nil
+	}
+
+	// refresh_tokens references token_families, so it goes first
+	for _, table := range []string{"refresh_tokens", "token_families", "revoked_tokens", "password_reset_codes",
+		"phone_verification_codes", "recovery_codes"} {
+		_, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE user_id = ANY($1::uuid[])", pq.Array(userIDs))
+		if err != nil {
+			return 0, dbError(ctx, "PurgeDeletedUsers", err)
+		}
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM users WHERE user_id = ANY($1::uuid[])", pq.Array(userIDs))
+	if err != nil {
+		return 0, dbError(ctx, "PurgeDeletedUsers", err)
+	}
+
+	// The append-only trigger of audit_events lets exactly this update through
+	_, err = tx.ExecContext(ctx, "UPDATE audit_events SET ip_address = '', user_agent = '', changes = NULL,"+
+		" redacted_at = $1 WHERE user_id = ANY($2::uuid[]) AND redacted_at IS NULL", now, pq.Array(userIDs))
+	if err != nil {
+		return 0, dbError(ctx, "PurgeDeletedUsers", err)
+	}
+
+	// A pending number may belong to someone else by now, whose deliveries
+	// are left alone
+	_, err = tx.ExecContext(ctx, "UPDATE notification_deliveries d SET phone_number = '', updated_at = $1"+
+		" WHERE d.phone_number = ANY($2) AND NOT EXISTS (SELECT 1 FROM users u WHERE u.phone_number = d.phone_number)",
+		now, pq.Array(phoneNumbers))
+	if err != nil {
+		return 0, dbError(ctx, "PurgeDeletedUsers", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, dbError(ctx, "PurgeDeletedUsers", err)
+	}
+	return len(userIDs), nil
+}
+
+func (r *Repository) CreateTokenFamily(ctx context.Context, input TokenFamily) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := r.Db.ExecContext(ctx, "INSERT INTO token_families (family_id, user_id, device_name, user_agent,"+
+		" ip_address, last_seen_at, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)", input.FamilyID, input.UserID,
+		input.DeviceName, input.UserAgent, input.IPAddress, input.LastSeenAt, input.CreatedAt)
+	if err != nil {
+		return dbError(ctx, "CreateTokenFamily", err)
+	}
+	return err
+}
+
+func (r *Repository) RevokeTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := r.Db.ExecContext(ctx, "UPDATE token_families SET revoked_at = $1"+
+		" WHERE family_id = $2 AND revoked_at IS NULL", revokedAt, familyID)
+	if err != nil {
+		return dbError(ctx, "RevokeTokenFamily", err)
+	}
+	return err
+}
+
+// RevokeUserTokenFamily revokes the family only when it belongs to the user,
+// and reports whether there was such a family still active.
+func (r *Repository) RevokeUserTokenFamily(ctx context.Context, userID string, familyID string, revokedAt time.Time) (bool, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE token_families SET revoked_at = $1"+
+		" WHERE family_id = $2 AND user_id = $3 AND revoked_at IS NULL", revokedAt, familyID, userID)
+	if err != nil {
+		return false, dbError(ctx, "RevokeUserTokenFamily", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, dbError(ctx, "RevokeUserTokenFamily", err)
+	}
+	return affected == 1, nil
+}
+
+func (r *Repository) IsTokenFamilyRevoked(ctx context.Context, familyID string) (bool, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	count := 0
+	err := r.Db.QueryRowContext(ctx, "SELECT count(id) FROM token_families WHERE family_id = $1"+
+		" AND revoked_at IS NOT NULL", familyID).Scan(&count)
+	if err != nil {
+		return false, dbError(ctx, "IsTokenFamilyRevoked", err)
+	}
+	return count > 0, nil
+}
+
+func (r *Repository) TouchTokenFamily(ctx context.Context, input TokenFamilySeen) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := r.Db.ExecContext(ctx, "UPDATE token_families SET ip_address = $1, user_agent = $2, last_seen_at = $3"+
+		" WHERE family_id = $4", input.IPAddress, input.UserAgent, input.SeenAt, input.FamilyID)
+	if err != nil {
+		return dbError(ctx, "TouchTokenFamily", err)
+	}
+	return nil
+}
+
+// GetActiveTokenFamilies lists the user's families that are not revoked and
+// were seen after activeSince, most recently seen first.
+func (r *Repository) GetActiveTokenFamilies(ctx context.Context, userID string, activeSince time.Time) ([]TokenFamily, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.Db.QueryContext(ctx, "SELECT id, family_id, user_id, device_name, user_agent, ip_address,"+
+		" revoked_at, last_seen_at, created_at FROM token_families WHERE user_id = $1 AND revoked_at IS NULL"+
+		" AND last_seen_at > $2 ORDER BY last_seen_at DESC", userID, activeSince)
+	if err != nil {
+		return nil, dbError(ctx, "GetActiveTokenFamilies", err)
+	}
+	defer rows.Close()
+
+	output := []TokenFamily{}
+	for rows.Next() {
+		family := TokenFamily{}
+		err := rows.Scan(&family.ID, &family.FamilyID, &family.UserID, &family.DeviceName, &family.UserAgent,
+			&family.IPAddress, &family.RevokedAt, &family.LastSeenAt, &family.CreatedAt)
+		if err != nil {
+			return nil, dbError(ctx, "GetActiveTokenFamilies", err)
+		}
+		output = append(output, family)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError(ctx, "GetActiveTokenFamilies", err)
+	}
+	return output, nil
+}
+
+// GetTokenFamiliesByUserId lists every family of the user, revoked and
+// expired ones included, newest first.
+func (r *Repository) GetTokenFamiliesByUserId(ctx context.Context, userID string) ([]TokenFamily, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.Db.QueryContext(ctx, "SELECT id, family_id, user_id, device_name, user_agent, ip_address,"+
+		" revoked_at, last_seen_at, created_at FROM token_families WHERE user_id = $1 ORDER BY created_at DESC", userID)
+	if err != nil {
+		return nil, dbError(ctx, "GetTokenFamiliesByUserId", err)
+	}
+	defer rows.Close()
+
+	output := []TokenFamily{}
+	for rows.Next() {
+		family := TokenFamily{}
+		err := rows.Scan(&family.ID, &family.FamilyID, &family.UserID, &family.DeviceName, &family.UserAgent,
+			&family.IPAddress, &family.RevokedAt, &family.LastSeenAt, &family.CreatedAt)
+		if err != nil {
+			return nil, dbError(ctx, "GetTokenFamiliesByUserId", err)
+		}
+		output = append(output, family)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError(ctx, "GetTokenFamiliesByUserId", err)
+	}
+	return output, nil
+}
+
+func (r *Repository) CreateRefreshToken(ctx context.Context, input RefreshToken) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := r.Db.ExecContext(ctx, "INSERT INTO refresh_tokens (token_id, family_id, user_id, expires_at, created_at)"+
+		" VALUES ($1, $2, $3, $4, $5)", input.TokenID, input.FamilyID, input.UserID, input.ExpiresAt, input.CreatedAt)
+	if err != nil {
+		return dbError(ctx, "CreateRefreshToken", err)
+	}
+	return err
+}
+
+func (r *Repository) GetRefreshToken(ctx context.Context, tokenID string) (*RefreshToken, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	output := RefreshToken{}
+	err := r.Db.QueryRowContext(ctx, "SELECT rt.id, rt.token_id, rt.family_id, rt.user_id, rt.used_at,"+
+		" rt.expires_at, rt.created_at, tf.revoked_at FROM refresh_tokens rt"+
+		" JOIN token_families tf ON tf.family_id = rt.family_id WHERE rt.token_id = $1", tokenID).
+		Scan(&output.ID, &output.TokenID, &output.FamilyID, &output.UserID, &output.UsedAt, &output.ExpiresAt,
+			&output.CreatedAt, &output.FamilyRevokedAt)
+	if err != nil {
+		return nil, dbError(ctx, "GetRefreshToken", err)
+	}
+	return &output, nil
+}
+
+// MarkRefreshTokenUsed flags the token as used and reports whether this call
+// was the one that did it, so two concurrent refreshes can't both win.
+func (r *Repository) MarkRefreshTokenUsed(ctx context.Context, tokenID string, usedAt time.Time) (bool, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = $1"+
+		" WHERE token_id = $2 AND used_at IS NULL", usedAt, tokenID)
+	if err != nil {
+		return false, dbError(ctx, "MarkRefreshTokenUsed", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, dbError(ctx, "MarkRefreshTokenUsed", err)
+	}
+	return affected == 1, nil
+}
+
+func (r *Repository) RevokeToken(ctx context.Context, input RevokedToken) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := r.Db.ExecContext(ctx, "INSERT INTO revoked_tokens (token_id, user_id, expires_at, revoked_at)"+
+		" VALUES ($1, $2, $3, $4) ON CONFLICT (token_id) DO NOTHING", input.TokenID, input.UserID,
+		input.ExpiresAt, input.RevokedAt)
+	if err != nil {
+		return dbError(ctx, "RevokeToken", err)
+	}
+	return err
+}
+
+func (r *Repository) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	count := 0
+	err := r.Db.QueryRowContext(ctx, "SELECT count(id) FROM revoked_tokens WHERE token_id = $1", tokenID).
+		Scan(&count)
+	if err != nil {
+		return false, dbError(ctx, "IsTokenRevoked", err)
+	}
+	return count > 0, nil
+}
+
+// RevokeAllUserTokens invalidates every token issued to the user before
+// validAfter and revokes all of the user's refresh token families.
+func (r *Repository) RevokeAllUserTokens(ctx context.Context, userID string, validAfter time.Time) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(ctx, "RevokeAllUserTokens", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET tokens_valid_after = $1 WHERE user_id = $2 AND deleted_at IS NULL",
+		validAfter, userID)
+	if err != nil {
+		return dbError(ctx, "RevokeAllUserTokens", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE token_families SET revoked_at = $1"+
+		" WHERE user_id = $2 AND revoked_at IS NULL", validAfter, userID)
+	if err != nil {
+		return dbError(ctx, "RevokeAllUserTokens", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dbError(ctx, "RevokeAllUserTokens", err)
+	}
+	return nil
+}
+
+func (r *Repository) GetTokensValidAfter(ctx context.Context, userID string) (*time.Time, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	var validAfter *time.Time
+	err := r.Db.QueryRowContext(ctx,
+		"SELECT tokens_valid_after FROM users WHERE user_id = $1 AND deleted_at IS NULL", userID).
+		Scan(&validAfter)
+	if err != nil {
+		return nil, dbError(ctx, "GetTokensValidAfter", err)
+	}
+	return validAfter, nil
+}
+
+// CreatePasswordResetCode stores a new reset code and retires every code the
+// user was sent before, so only the latest one works.
+func (r *Repository) CreatePasswordResetCode(ctx context.Context, input PasswordResetCode) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(ctx, "CreatePasswordResetCode", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE password_reset_codes SET used_at = $1"+
+		" WHERE user_id = $2 AND used_at IS NULL", input.CreatedAt, input.UserID)
+	if err != nil {
+		return dbError(ctx, "CreatePasswordResetCode", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO password_reset_codes (user_id, phone_number, code_hash, attempts,"+
+		" expires_at, created_at) VALUES ($1, $2, $3, $4, $5, $6)", input.UserID, input.PhoneNumber, input.CodeHash,
+		input.Attempts, input.ExpiresAt, input.CreatedAt)
+	if err != nil {
+		return dbError(ctx, "CreatePasswordResetCode", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dbError(ctx, "CreatePasswordResetCode", err)
+	}
+	return nil
+}
+
+func (r *Repository) GetActivePasswordResetCode(ctx context.Context, userID string, phoneNumber string, now time.Time) (*PasswordResetCode, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	output := PasswordResetCode{}
+	err := r.Db.QueryRowContext(ctx, "SELECT id, user_id, phone_number, code_hash, attempts, expires_at, used_at,"+
+		" created_at FROM password_reset_codes WHERE user_id = $1 AND phone_number = $2 AND used_at IS NULL"+
+		" AND expires_at > $3 ORDER BY created_at DESC LIMIT 1", userID, phoneNumber, now).
+		Scan(&output.ID, &output.UserID, &output.PhoneNumber, &output.CodeHash, &output.Attempts, &output.ExpiresAt,
+			&output.UsedAt, &output.CreatedAt)
+	if err != nil {
+		return nil, dbError(ctx, "GetActivePasswordResetCode", err)
+	}
+	return &output, nil
+}
+
+// ReservePasswordResetAttempt counts a guess at the reset code before it is
+// checked and reports whether the code still has guesses left.
+func (r *Repository) ReservePasswordResetAttempt(ctx context.Context, id int, maxAttempts int) (bool, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE password_reset_codes SET attempts = attempts + 1"+
+		" WHERE id = $1 AND used_at IS NULL AND attempts < $2", id, maxAttempts)
+	if err != nil {
+		return false, dbError(ctx, "ReservePasswordResetAttempt", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, dbError(ctx, "ReservePasswordResetAttempt", err)
+	}
+	return affected == 1, nil
+}
+
+// UsePasswordResetCode marks the code as used and reports whether this call
+// was the one that did it.
+func (r *Repository) UsePasswordResetCode(ctx context.Context, id int, usedAt time.Time) (bool, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE password_reset_codes SET used_at = $1"+
+		" WHERE id = $2 AND used_at IS NULL", usedAt, id)
+	if err != nil {
+		return false, dbError(ctx, "UsePasswordResetCode", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, dbError(ctx, "UsePasswordResetCode", err)
+	}
+	return affected == 1, nil
+}
+
+// CreatePhoneVerificationCode stores a new verification code for the user and
+// retires any code sent to them before, so only the latest one works.
+func (r *Repository) CreatePhoneVerificationCode(ctx context.Context, input PhoneVerificationCode) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(ctx, "CreatePhoneVerificationCode", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE phone_verification_codes SET used_at = $1"+
+		" WHERE user_id = $2 AND used_at IS NULL", input.CreatedAt, input.UserID)
+	if err != nil {
+		return dbError(ctx, "CreatePhoneVerificationCode", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO phone_verification_codes (user_id, phone_number, code_hash, attempts,"+
+		" expires_at, created_at) VALUES ($1, $2, $3, $4, $5, $6)", input.UserID, input.PhoneNumber, input.CodeHash,
+		input.Attempts, input.ExpiresAt, input.CreatedAt)
+	if err != nil {
+		return dbError(ctx, "CreatePhoneVerificationCode", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dbError(ctx, "CreatePhoneVerificationCode", err)
+	}
+	return nil
+}
+
+func (r *Repository) GetActivePhoneVerificationCode(ctx context.Context, userID string, phoneNumber string, now time.Time) (*PhoneVerificationCode, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	output := PhoneVerificationCode{}
+	err := r.Db.QueryRowContext(ctx, "SELECT id, user_id, phone_number, code_hash, attempts, expires_at, used_at,"+
+		" created_at FROM phone_verification_codes WHERE user_id = $1 AND phone_number = $2 AND used_at IS NULL"+
+		" AND expires_at > $3 ORDER BY created_at DESC LIMIT 1", userID, phoneNumber, now).
+		Scan(&output.ID, &output.UserID, &output.PhoneNumber, &output.CodeHash, &output.Attempts, &output.ExpiresAt,
+			&output.UsedAt, &output.CreatedAt)
+	if err != nil {
+		return nil, dbError(ctx, "GetActivePhoneVerificationCode", err)
+	}
+	return &output, nil
+}
+
+// ReservePhoneVerificationAttempt counts a guess at the verification code
+// before it is checked and reports whether the code still has guesses left.
+func (r *Repository) ReservePhoneVerificationAttempt(ctx context.Context, id int, maxAttempts int) (bool, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE phone_verification_codes SET attempts = attempts + 1"+
+		" WHERE id = $1 AND used_at IS NULL AND attempts < $2", id, maxAttempts)
+	if err != nil {
+		return false, dbError(ctx, "ReservePhoneVerificationAttempt", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, dbError(ctx, "ReservePhoneVerificationAttempt", err)
+	}
+	return affected == 1, nil
+}
+
+// UsePhoneVerificationCode marks the code as used and reports whether this
+// call was the one that did it.
+func (r *Repository) UsePhoneVerificationCode(ctx context.Context, id int, usedAt time.Time) (bool, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE phone_verification_codes SET used_at = $1"+
+		" WHERE id = $2 AND used_at IS NULL", usedAt, id)
+	if err != nil {
+		return false, dbError(ctx, "UsePhoneVerificationCode", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, dbError(ctx, "UsePhoneVerificationCode", err)
+	}
+	return affected == 1, nil
+}
+
+// VerifyPhoneNumber makes phoneNumber the verified number of the user. When it
+// was a pending change, the change takes effect now.
+func (r *Repository) VerifyPhoneNumber(ctx context.Context, userID string, phoneNumber string, verifiedAt time.Time) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := r.Db.ExecContext(ctx, "UPDATE users SET phone_number = $1, pending_phone_number = NULL,"+
+		" phone_verified_at = $2, updated_at = $2 WHERE user_id = $3 AND deleted_at IS NULL", phoneNumber, verifiedAt, userID)
+	if err != nil {
+		return dbError(ctx, "VerifyPhoneNumber", err)
+	}
+	return nil
+}
+
+// SetTOTPSecret stores a new, not yet confirmed, encrypted TOTP secret. It
+// fails once two-factor authentication is enabled, so a stolen session can't
+// swap the secret of an account.
+func (r *Repository) SetTOTPSecret(ctx context.Context, userID string, secret string, updatedAt time.Time) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE users SET totp_secret = $1, updated_at = $2"+
+		" WHERE user_id = $3 AND deleted_at IS NULL AND totp_enabled_at IS NULL", secret, updatedAt, userID)
+	if err != nil {
+		return dbError(ctx, "SetTOTPSecret", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return dbError(ctx, "SetTOTPSecret", err)
+	}
+	if affected == 0 {
+		return &Error{Op: "SetTOTPSecret", Kind: ErrConflict}
+	}
+	return nil
+}
+
+func (r *Repository) EnableTOTP(ctx context.Context, input EnableTOTPInput) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(ctx, "EnableTOTP", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET totp_enabled_at = $1, totp_last_used_step = $2, updated_at = $1"+
+		" WHERE user_id = $3 AND deleted_at IS NULL", input.EnabledAt, input.Step, input.UserID)
+	if err != nil {
+		return dbError(ctx, "EnableTOTP", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", input.UserID)
+	if err != nil {
+		return dbError(ctx, "EnableTOTP", err)
+	}
+
+	for _, codeHash := range input.RecoveryCodeHashes {
+		_, err = tx.ExecContext(ctx, "INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, $3)",
+			input.UserID, codeHash, input.EnabledAt)
+		if err != nil {
+			return dbError(ctx, "EnableTOTP", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dbError(ctx, "EnableTOTP", err)
+	}
+	return nil
+}
+
+// UseTOTPStep records that the code of time step was used and reports whether
+// it is the first use, so every TOTP code only logs in once.
+func (r *Repository) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE users SET totp_last_used_step = $1"+
+		" WHERE user_id = $2 AND deleted_at IS NULL AND totp_last_used_step < $1", step, userID)
+	if err != nil {
+		return false, dbError(ctx, "UseTOTPStep", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, dbError(ctx, "UseTOTPStep", err)
+	}
+	return affected == 1, nil
+}
+
+// UseRecoveryCode marks the unused recovery code with codeHash as used and
+// reports whether there was one.
+func (r *Repository) UseRecoveryCode(ctx context.Context, userID string, codeHash string, usedAt time.Time) (bool, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE recovery_codes SET used_at = $1"+
+		" WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL", usedAt, userID, codeHash)
+	if err != nil {
+		return false, dbError(ctx, "UseRecoveryCode", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, dbError(ctx, "UseRecoveryCode", err)
+	}
+	return affected == 1, nil
+}
+
+func (r *Repository) CreateAuditEvent(ctx context.Context, input AuditEvent) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := r.Db.ExecContext(ctx, "INSERT INTO audit_events (user_id, actor_id, event_type, reason, ip_address,"+
+		" user_agent, changes, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)", input.UserID, input.ActorID,
+		input.EventType, input.Reason, input.IPAddress, input.UserAgent, input.Changes, input.CreatedAt)
+	if err != nil {
+		return dbError(ctx, "CreateAuditEvent", err)
+	}
+	return nil
+}
+
+// GetAuditEventsByUserId returns up to limit events of the user, newest first,
+// starting after the event with ID before when it is not 0.
+func (r *Repository) GetAuditEventsByUserId(ctx context.Context, userID string, before int64, limit int) ([]AuditEvent, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.Db.QueryContext(ctx, "SELECT id, user_id, actor_id, event_type, reason, ip_address, user_agent,"+
+		" changes, created_at FROM audit_events WHERE user_id = $1 AND ($2 = 0 OR id < $2)"+
+		" ORDER BY id DESC LIMIT $3", userID, before, limit)
+	if err != nil {
+		return nil, dbError(ctx, "GetAuditEventsByUserId", err)
+	}
+	defer rows.Close()
+
+	output := []AuditEvent{}
+	for rows.Next() {
+		event := AuditEvent{}
+		err := rows.Scan(&event.ID, &event.UserID, &event.ActorID, &event.EventType, &event.Reason, &event.IPAddress,
+			&event.UserAgent, &event.Changes, &event.CreatedAt)
+		if err != nil {
+			return nil, dbError(ctx, "GetAuditEventsByUserId", err)
+		}
+		output = append(output, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError(ctx, "GetAuditEventsByUserId", err)
+	}
+	return output, nil
+}
+
+func (r *Repository) CreateNotificationDelivery(ctx context.Context, input NotificationDelivery) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := r.Db.ExecContext(ctx, "INSERT INTO notification_deliveries (message_id, phone_number, template, provider,"+
+		" status, attempts, last_error, sent_at, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
+		input.MessageID, input.PhoneNumber, input.Template, input.Provider, input.Status, input.Attempts, input.LastError,
+		input.SentAt, input.CreatedAt, input.UpdatedAt)
+	if err != nil {
+		return dbError(ctx, "CreateNotificationDelivery", err)
+	}
+	return nil
+}
+
+// UpdateNotificationDelivery stores the outcome of the latest delivery attempt
+// of the message with MessageID.
+func (r *Repository) UpdateNotificationDelivery(ctx context.Context, input NotificationDelivery) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := r.Db.ExecContext(ctx, "UPDATE notification_deliveries SET status = $1, attempts = $2, last_error = $3,"+
+		" sent_at = $4, updated_at = $5 WHERE message_id = $6", input.Status, input.Attempts, input.LastError,
+		input.SentAt, input.UpdatedAt, input.MessageID)
+	if err != nil {
+		return dbError(ctx, "UpdateNotificationDelivery", err)
+	}
+	return nil
+}
+
 /*func (r *Repository) GetTestById(ctx context.Context, input GetTestByIdInput) (output GetTestByIdOutput, err error) {
 	err = r.Db.QueryRowContext(ctx, "SELECT name FROM test WHERE id = $1", input.Id).Scan(&output.Name)
 	if err != nil {
@@ -4,13 +4,60 @@
 // interfaces using mockgen. See the Makefile for more information.
 package repository
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type RepositoryInterface interface {
-	RegisterUser(User) error
+	RegisterUser(context.Context, User) error
 	CheckUser(context.Context, string) (*User, error)
-	UpdateLoginUser(context.Context, User) error
+	RecordLoginSuccess(context.Context, int, time.Time) error
+	ResetFailedLoginAttempts(context.Context, int) error
+	ReserveLoginAttempt(context.Context, LoginAttemptInput) (bool, error)
+	ClearLoginLock(context.Context, int) error
+	UnlockUser(context.Context, string) error
 	GetUserByUserId(context.Context, string) (*User, error)
+	ListUsers(context.Context, ListUsersInput) (*UserPage, error)
+	SearchUsers(context.Context, UserSearchInput) ([]UserMatch, error)
+	DisableUser(context.Context, string, time.Time) error
+	EnableUser(context.Context, string, time.Time) error
+	SetUserRole(context.Context, string, string, time.Time) error
 	UpdateUserProfile(context.Context, User) error
+	UpdatePassword(context.Context, string, string, time.Time) error
+	RehashPassword(context.Context, string, string, string) error
 	CheckPhoneNumber(context.Context, string) (int64, error)
+	DeleteUser(context.Context, DeleteUserInput) error
+	PurgeDeletedUsers(context.Context, time.Time, int) (int, error)
+	CreateTokenFamily(context.Context, TokenFamily) error
+	RevokeTokenFamily(context.Context, string, time.Time) error
+	RevokeUserTokenFamily(context.Context, string, string, time.Time) (bool, error)
+	IsTokenFamilyRevoked(context.Context, string) (bool, error)
+	TouchTokenFamily(context.Context, TokenFamilySeen) error
+	GetActiveTokenFamilies(context.Context, string, time.Time) ([]TokenFamily, error)
+	GetTokenFamiliesByUserId(context.Context, string) ([]TokenFamily, error)
+	CreateRefreshToken(context.Context, RefreshToken) error
+	GetRefreshToken(context.Context, string) (*RefreshToken, error)
+	MarkRefreshTokenUsed(context.Context, string, time.Time) (bool, error)
+	RevokeToken(context.Context, RevokedToken) error
+	IsTokenRevoked(context.Context, string) (bool, error)
+	RevokeAllUserTokens(context.Context, string, time.Time) error
+	GetTokensValidAfter(context.Context, string) (*time.Time, error)
+	CreatePasswordResetCode(context.Context, PasswordResetCode) error
+	GetActivePasswordResetCode(context.Context, string, string, time.Time) (*PasswordResetCode, error)
+	ReservePasswordResetAttempt(context.Context, int, int) (bool, error)
+	UsePasswordResetCode(context.Context, int, time.Time) (bool, error)
+	CreatePhoneVerificationCode(context.Context, PhoneVerificationCode) error
+	GetActivePhoneVerificationCode(context.Context, string, string, time.Time) (*PhoneVerificationCode, error)
+	ReservePhoneVerificationAttempt(context.Context, int, int) (bool, error)
+	UsePhoneVerificationCode(context.Context, int, time.Time) (bool, error)
+	VerifyPhoneNumber(context.Context, string, string, time.Time) error
+	SetTOTPSecret(context.Context, string, string, time.Time) error
+	EnableTOTP(context.Context, EnableTOTPInput) error
+	UseTOTPStep(context.Context, string, int64) (bool, error)
+	UseRecoveryCode(context.Context, string, string, time.Time) (bool, error)
+	CreateAuditEvent(context.Context, AuditEvent) error
+	GetAuditEventsByUserId(context.Context, string, int64, int) ([]AuditEvent, error)
+	CreateNotificationDelivery(context.Context, NotificationDelivery) error
+	UpdateNotificationDelivery(context.Context, NotificationDelivery) error
 }
@@ -7,6 +7,7 @@ package repository
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 )
@@ -64,6 +65,265 @@ func (mr *MockRepositoryInterfaceMockRecorder) CheckUser(arg0, arg1 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckUser", reflect.TypeOf((*MockRepositoryInterface)(nil).CheckUser), arg0, arg1)
 }
 
+// ClearLoginLock mocks base method.
+func (m *MockRepositoryInterface) ClearLoginLock(arg0 context.Context, arg1 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClearLoginLock", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ClearLoginLock indicates an expected call of ClearLoginLock.
+func (mr *MockRepositoryInterfaceMockRecorder) ClearLoginLock(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClearLoginLock", reflect.TypeOf((*MockRepositoryInterface)(nil).ClearLoginLock), arg0, arg1)
+}
+
+// CreateAuditEvent mocks base method.
+func (m *MockRepositoryInterface) CreateAuditEvent(arg0 context.Context, arg1 AuditEvent) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAuditEvent", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateAuditEvent indicates an expected call of CreateAuditEvent.
+func (mr *MockRepositoryInterfaceMockRecorder) CreateAuditEvent(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAuditEvent", reflect.TypeOf((*MockRepositoryInterface)(nil).CreateAuditEvent), arg0, arg1)
+}
+
+// CreateNotificationDelivery mocks base method.
+func (m *MockRepositoryInterface) CreateNotificationDelivery(arg0 context.Context, arg1 NotificationDelivery) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateNotificationDelivery", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateNotificationDelivery indicates an expected call of CreateNotificationDelivery.
+func (mr *MockRepositoryInterfaceMockRecorder) CreateNotificationDelivery(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateNotificationDelivery", reflect.TypeOf((*MockRepositoryInterface)(nil).CreateNotificationDelivery), arg0, arg1)
+}
+
+// CreatePasswordResetCode mocks base method.
+func (m *MockRepositoryInterface) CreatePasswordResetCode(arg0 context.Context, arg1 PasswordResetCode) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreatePasswordResetCode", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreatePasswordResetCode indicates an expected call of CreatePasswordResetCode.
+func (mr *MockRepositoryInterfaceMockRecorder) CreatePasswordResetCode(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreatePasswordResetCode", reflect.TypeOf((*MockRepositoryInterface)(nil).CreatePasswordResetCode), arg0, arg1)
+}
+
+// CreatePhoneVerificationCode mocks base method.
+func (m *MockRepositoryInterface) CreatePhoneVerificationCode(arg0 context.Context, arg1 PhoneVerificationCode) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreatePhoneVerificationCode", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreatePhoneVerificationCode indicates an expected call of CreatePhoneVerificationCode.
+func (mr *MockRepositoryInterfaceMockRecorder) CreatePhoneVerificationCode(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreatePhoneVerificationCode", reflect.TypeOf((*MockRepositoryInterface)(nil).CreatePhoneVerificationCode), arg0, arg1)
+}
+
+// CreateRefreshToken mocks base method.
+func (m *MockRepositoryInterface) CreateRefreshToken(arg0 context.Context, arg1 RefreshToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateRefreshToken", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateRefreshToken indicates an expected call of CreateRefreshToken.
+func (mr *MockRepositoryInterfaceMockRecorder) CreateRefreshToken(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRefreshToken", reflect.TypeOf((*MockRepositoryInterface)(nil).CreateRefreshToken), arg0, arg1)
+}
+
+// CreateTokenFamily mocks base method.
+func (m *MockRepositoryInterface) CreateTokenFamily(arg0 context.Context, arg1 TokenFamily) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateTokenFamily", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateTokenFamily indicates an expected call of CreateTokenFamily.
+func (mr *MockRepositoryInterfaceMockRecorder) CreateTokenFamily(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTokenFamily", reflect.TypeOf((*MockRepositoryInterface)(nil).CreateTokenFamily), arg0, arg1)
+}
+
+// DeleteUser mocks base method.
+func (m *MockRepositoryInterface) DeleteUser(arg0 context.Context, arg1 DeleteUserInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteUser", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteUser indicates an expected call of DeleteUser.
+func (mr *MockRepositoryInterfaceMockRecorder) DeleteUser(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUser", reflect.TypeOf((*MockRepositoryInterface)(nil).DeleteUser), arg0, arg1)
+}
+
+// DisableUser mocks base method.
+func (m *MockRepositoryInterface) DisableUser(arg0 context.Context, arg1 string, arg2 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DisableUser", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DisableUser indicates an expected call of DisableUser.
+func (mr *MockRepositoryInterfaceMockRecorder) DisableUser(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DisableUser", reflect.TypeOf((*MockRepositoryInterface)(nil).DisableUser), arg0, arg1, arg2)
+}
+
+// EnableTOTP mocks base method.
+func (m *MockRepositoryInterface) EnableTOTP(arg0 context.Context, arg1 EnableTOTPInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnableTOTP", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EnableTOTP indicates an expected call of EnableTOTP.
+func (mr *MockRepositoryInterfaceMockRecorder) EnableTOTP(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnableTOTP", reflect.TypeOf((*MockRepositoryInterface)(nil).EnableTOTP), arg0, arg1)
+}
+
+// EnableUser mocks base method.
+func (m *MockRepositoryInterface) EnableUser(arg0 context.Context, arg1 string, arg2 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnableUser", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EnableUser indicates an expected call of EnableUser.
+func (mr *MockRepositoryInterfaceMockRecorder) EnableUser(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnableUser", reflect.TypeOf((*MockRepositoryInterface)(nil).EnableUser), arg0, arg1, arg2)
+}
+
+// GetActivePasswordResetCode mocks base method.
+func (m *MockRepositoryInterface) GetActivePasswordResetCode(arg0 context.Context, arg1, arg2 string, arg3 time.Time) (*PasswordResetCode, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetActivePasswordResetCode", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(*PasswordResetCode)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetActivePasswordResetCode indicates an expected call of GetActivePasswordResetCode.
+func (mr *MockRepositoryInterfaceMockRecorder) GetActivePasswordResetCode(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetActivePasswordResetCode", reflect.TypeOf((*MockRepositoryInterface)(nil).GetActivePasswordResetCode), arg0, arg1, arg2, arg3)
+}
+
+// GetActivePhoneVerificationCode mocks base method.
+func (m *MockRepositoryInterface) GetActivePhoneVerificationCode(arg0 context.Context, arg1, arg2 string, arg3 time.Time) (*PhoneVerificationCode, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetActivePhoneVerificationCode", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(*PhoneVerificationCode)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetActivePhoneVerificationCode indicates an expected call of GetActivePhoneVerificationCode.
+func (mr *MockRepositoryInterfaceMockRecorder) GetActivePhoneVerificationCode(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetActivePhoneVerificationCode", reflect.TypeOf((*MockRepositoryInterface)(nil).GetActivePhoneVerificationCode), arg0, arg1, arg2, arg3)
+}
+
+// GetActiveTokenFamilies mocks base method.
+func (m *MockRepositoryInterface) GetActiveTokenFamilies(arg0 context.Context, arg1 string, arg2 time.Time) ([]TokenFamily, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetActiveTokenFamilies", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]TokenFamily)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetActiveTokenFamilies indicates an expected call of GetActiveTokenFamilies.
+func (mr *MockRepositoryInterfaceMockRecorder) GetActiveTokenFamilies(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetActiveTokenFamilies", reflect.TypeOf((*MockRepositoryInterface)(nil).GetActiveTokenFamilies), arg0, arg1, arg2)
+}
+
+// GetAuditEventsByUserId mocks base method.
+func (m *MockRepositoryInterface) GetAuditEventsByUserId(arg0 context.Context, arg1 string, arg2 int64, arg3 int) ([]AuditEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAuditEventsByUserId", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]AuditEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAuditEventsByUserId indicates an expected call of GetAuditEventsByUserId.
+func (mr *MockRepositoryInterfaceMockRecorder) GetAuditEventsByUserId(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAuditEventsByUserId", reflect.TypeOf((*MockRepositoryInterface)(nil).GetAuditEventsByUserId), arg0, arg1, arg2, arg3)
+}
+
+// GetRefreshToken mocks base method.
+func (m *MockRepositoryInterface) GetRefreshToken(arg0 context.Context, arg1 string) (*RefreshToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRefreshToken", arg0, arg1)
+	ret0, _ := ret[0].(*RefreshToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRefreshToken indicates an expected call of GetRefreshToken.
+func (mr *MockRepositoryInterfaceMockRecorder) GetRefreshToken(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRefreshToken", reflect.TypeOf((*MockRepositoryInterface)(nil).GetRefreshToken), arg0, arg1)
+}
+
+// GetTokenFamiliesByUserId mocks base method.
+func (m *MockRepositoryInterface) GetTokenFamiliesByUserId(arg0 context.Context, arg1 string) ([]TokenFamily, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTokenFamiliesByUserId", arg0, arg1)
+	ret0, _ := ret[0].([]TokenFamily)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTokenFamiliesByUserId indicates an expected call of GetTokenFamiliesByUserId.
+func (mr *MockRepositoryInterfaceMockRecorder) GetTokenFamiliesByUserId(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTokenFamiliesByUserId", reflect.TypeOf((*MockRepositoryInterface)(nil).GetTokenFamiliesByUserId), arg0, arg1)
+}
+
+// GetTokensValidAfter mocks base method.
+func (m *MockRepositoryInterface) GetTokensValidAfter(arg0 context.Context, arg1 string) (*time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTokensValidAfter", arg0, arg1)
+	ret0, _ := ret[0].(*time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTokensValidAfter indicates an expected call of GetTokensValidAfter.
+func (mr *MockRepositoryInterfaceMockRecorder) GetTokensValidAfter(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTokensValidAfter", reflect.TypeOf((*MockRepositoryInterface)(nil).GetTokensValidAfter), arg0, arg1)
+}
+
 // GetUserByUserId mocks base method.
 func (m *MockRepositoryInterface) GetUserByUserId(arg0 context.Context, arg1 string) (*User, error) {
 	m.ctrl.T.Helper()
@@ -79,32 +339,336 @@ func (mr *MockRepositoryInterfaceMockRecorder) GetUserByUserId(arg0, arg1 interf
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByUserId", reflect.TypeOf((*MockRepositoryInterface)(nil).GetUserByUserId), arg0, arg1)
 }
 
+// IsTokenFamilyRevoked mocks base method.
+func (m *MockRepositoryInterface) IsTokenFamilyRevoked(arg0 context.Context, arg1 string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsTokenFamilyRevoked", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsTokenFamilyRevoked indicates an expected call of IsTokenFamilyRevoked.
+func (mr *MockRepositoryInterfaceMockRecorder) IsTokenFamilyRevoked(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsTokenFamilyRevoked", reflect.TypeOf((*MockRepositoryInterface)(nil).IsTokenFamilyRevoked), arg0, arg1)
+}
+
+// IsTokenRevoked mocks base method.
+func (m *MockRepositoryInterface) IsTokenRevoked(arg0 context.Context, arg1 string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsTokenRevoked", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsTokenRevoked indicates an expected call of IsTokenRevoked.
+func (mr *MockRepositoryInterfaceMockRecorder) IsTokenRevoked(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsTokenRevoked", reflect.TypeOf((*MockRepositoryInterface)(nil).IsTokenRevoked), arg0, arg1)
+}
+
+// ListUsers mocks base method.
+func (m *MockRepositoryInterface) ListUsers(arg0 context.Context, arg1 ListUsersInput) (*UserPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListUsers", arg0, arg1)
+	ret0, _ := ret[0].(*UserPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListUsers indicates an expected call of ListUsers.
+func (mr *MockRepositoryInterfaceMockRecorder) ListUsers(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUsers", reflect.TypeOf((*MockRepositoryInterface)(nil).ListUsers), arg0, arg1)
+}
+
+// MarkRefreshTokenUsed mocks base method.
+func (m *MockRepositoryInterface) MarkRefreshTokenUsed(arg0 context.Context, arg1 string, arg2 time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkRefreshTokenUsed", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MarkRefreshTokenUsed indicates an expected call of MarkRefreshTokenUsed.
+func (mr *MockRepositoryInterfaceMockRecorder) MarkRefreshTokenUsed(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkRefreshTokenUsed", reflect.TypeOf((*MockRepositoryInterface)(nil).MarkRefreshTokenUsed), arg0, arg1, arg2)
+}
+
+// PurgeDeletedUsers mocks base method.
+func (m *MockRepositoryInterface) PurgeDeletedUsers(arg0 context.Context, arg1 time.Time, arg2 int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeDeletedUsers", arg0, arg1, arg2)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeDeletedUsers indicates an expected call of PurgeDeletedUsers.
+func (mr *MockRepositoryInterfaceMockRecorder) PurgeDeletedUsers(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeDeletedUsers", reflect.TypeOf((*MockRepositoryInterface)(nil).PurgeDeletedUsers), arg0, arg1, arg2)
+}
+
+// RecordLoginSuccess mocks base method.
+func (m *MockRepositoryInterface) RecordLoginSuccess(arg0 context.Context, arg1 int, arg2 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecordLoginSuccess", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecordLoginSuccess indicates an expected call of RecordLoginSuccess.
+func (mr *MockRepositoryInterfaceMockRecorder) RecordLoginSuccess(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordLoginSuccess", reflect.TypeOf((*MockRepositoryInterface)(nil).RecordLoginSuccess), arg0, arg1, arg2)
+}
+
 // RegisterUser mocks base method.
-func (m *MockRepositoryInterface) RegisterUser(arg0 User) error {
+func (m *MockRepositoryInterface) RegisterUser(arg0 context.Context, arg1 User) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "RegisterUser", arg0)
+	ret := m.ctrl.Call(m, "RegisterUser", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // RegisterUser indicates an expected call of RegisterUser.
-func (mr *MockRepositoryInterfaceMockRecorder) RegisterUser(arg0 interface{}) *gomock.Call {
+func (mr *MockRepositoryInterfaceMockRecorder) RegisterUser(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterUser", reflect.TypeOf((*MockRepositoryInterface)(nil).RegisterUser), arg0, arg1)
+}
+
+// RehashPassword mocks base method.
+func (m *MockRepositoryInterface) RehashPassword(arg0 context.Context, arg1, arg2, arg3 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RehashPassword", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RehashPassword indicates an expected call of RehashPassword.
+func (mr *MockRepositoryInterfaceMockRecorder) RehashPassword(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RehashPassword", reflect.TypeOf((*MockRepositoryInterface)(nil).RehashPassword), arg0, arg1, arg2, arg3)
+}
+
+// ReserveLoginAttempt mocks base method.
+func (m *MockRepositoryInterface) ReserveLoginAttempt(arg0 context.Context, arg1 LoginAttemptInput) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReserveLoginAttempt", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReserveLoginAttempt indicates an expected call of ReserveLoginAttempt.
+func (mr *MockRepositoryInterfaceMockRecorder) ReserveLoginAttempt(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReserveLoginAttempt", reflect.TypeOf((*MockRepositoryInterface)(nil).ReserveLoginAttempt), arg0, arg1)
+}
+
+// ReservePasswordResetAttempt mocks base method.
+func (m *MockRepositoryInterface) ReservePasswordResetAttempt(arg0 context.Context, arg1, arg2 int) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReservePasswordResetAttempt", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReservePasswordResetAttempt indicates an expected call of ReservePasswordResetAttempt.
+func (mr *MockRepositoryInterfaceMockRecorder) ReservePasswordResetAttempt(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReservePasswordResetAttempt", reflect.TypeOf((*MockRepositoryInterface)(nil).ReservePasswordResetAttempt), arg0, arg1, arg2)
+}
+
+// ReservePhoneVerificationAttempt mocks base method.
+func (m *MockRepositoryInterface) ReservePhoneVerificationAttempt(arg0 context.Context, arg1, arg2 int) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReservePhoneVerificationAttempt", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReservePhoneVerificationAttempt indicates an expected call of ReservePhoneVerificationAttempt.
+func (mr *MockRepositoryInterfaceMockRecorder) ReservePhoneVerificationAttempt(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReservePhoneVerificationAttempt", reflect.TypeOf((*MockRepositoryInterface)(nil).ReservePhoneVerificationAttempt), arg0, arg1, arg2)
+}
+
+// ResetFailedLoginAttempts mocks base method.
+func (m *MockRepositoryInterface) ResetFailedLoginAttempts(arg0 context.Context, arg1 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetFailedLoginAttempts", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetFailedLoginAttempts indicates an expected call of ResetFailedLoginAttempts.
+func (mr *MockRepositoryInterfaceMockRecorder) ResetFailedLoginAttempts(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetFailedLoginAttempts", reflect.TypeOf((*MockRepositoryInterface)(nil).ResetFailedLoginAttempts), arg0, arg1)
+}
+
+// RevokeAllUserTokens mocks base method.
+func (m *MockRepositoryInterface) RevokeAllUserTokens(arg0 context.Context, arg1 string, arg2 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeAllUserTokens", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeAllUserTokens indicates an expected call of RevokeAllUserTokens.
+func (mr *MockRepositoryInterfaceMockRecorder) RevokeAllUserTokens(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeAllUserTokens", reflect.TypeOf((*MockRepositoryInterface)(nil).RevokeAllUserTokens), arg0, arg1, arg2)
+}
+
+// RevokeToken mocks base method.
+func (m *MockRepositoryInterface) RevokeToken(arg0 context.Context, arg1 RevokedToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeToken", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeToken indicates an expected call of RevokeToken.
+func (mr *MockRepositoryInterfaceMockRecorder) RevokeToken(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeToken", reflect.TypeOf((*MockRepositoryInterface)(nil).RevokeToken), arg0, arg1)
+}
+
+// RevokeTokenFamily mocks base method.
+func (m *MockRepositoryInterface) RevokeTokenFamily(arg0 context.Context, arg1 string, arg2 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeTokenFamily", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeTokenFamily indicates an expected call of RevokeTokenFamily.
+func (mr *MockRepositoryInterfaceMockRecorder) RevokeTokenFamily(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeTokenFamily", reflect.TypeOf((*MockRepositoryInterface)(nil).RevokeTokenFamily), arg0, arg1, arg2)
+}
+
+// RevokeUserTokenFamily mocks base method.
+func (m *MockRepositoryInterface) RevokeUserTokenFamily(arg0 context.Context, arg1, arg2 string, arg3 time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeUserTokenFamily", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RevokeUserTokenFamily indicates an expected call of RevokeUserTokenFamily.
+func (mr *MockRepositoryInterfaceMockRecorder) RevokeUserTokenFamily(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterUser", reflect.TypeOf((*MockRepositoryInterface)(nil).RegisterUser), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeUserTokenFamily", reflect.TypeOf((*MockRepositoryInterface)(nil).RevokeUserTokenFamily), arg0, arg1, arg2, arg3)
 }
 
-// UpdateLoginUser mocks base method.
-func (m *MockRepositoryInterface) UpdateLoginUser(arg0 context.Context, arg1 User) error {
+// SearchUsers mocks base method.
+func (m *MockRepositoryInterface) SearchUsers(arg0 context.Context, arg1 UserSearchInput) ([]UserMatch, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateLoginUser", arg0, arg1)
+	ret := m.ctrl.Call(m, "SearchUsers", arg0, arg1)
+	ret0, _ := ret[0].([]UserMatch)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchUsers indicates an expected call of SearchUsers.
+func (mr *MockRepositoryInterfaceMockRecorder) SearchUsers(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchUsers", reflect.TypeOf((*MockRepositoryInterface)(nil).SearchUsers), arg0, arg1)
+}
+
+// SetTOTPSecret mocks base method.
+func (m *MockRepositoryInterface) SetTOTPSecret(arg0 context.Context, arg1, arg2 string, arg3 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetTOTPSecret", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetTOTPSecret indicates an expected call of SetTOTPSecret.
+func (mr *MockRepositoryInterfaceMockRecorder) SetTOTPSecret(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTOTPSecret", reflect.TypeOf((*MockRepositoryInterface)(nil).SetTOTPSecret), arg0, arg1, arg2, arg3)
+}
+
+// SetUserRole mocks base method.
+func (m *MockRepositoryInterface) SetUserRole(arg0 context.Context, arg1, arg2 string, arg3 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetUserRole", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetUserRole indicates an expected call of SetUserRole.
+func (mr *MockRepositoryInterfaceMockRecorder) SetUserRole(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetUserRole", reflect.TypeOf((*MockRepositoryInterface)(nil).SetUserRole), arg0, arg1, arg2, arg3)
+}
+
+// TouchTokenFamily mocks base method.
+func (m *MockRepositoryInterface) TouchTokenFamily(arg0 context.Context, arg1 TokenFamilySeen) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TouchTokenFamily", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TouchTokenFamily indicates an expected call of TouchTokenFamily.
+func (mr *MockRepositoryInterfaceMockRecorder) TouchTokenFamily(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TouchTokenFamily", reflect.TypeOf((*MockRepositoryInterface)(nil).TouchTokenFamily), arg0, arg1)
+}
+
+// UnlockUser mocks base method.
+func (m *MockRepositoryInterface) UnlockUser(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnlockUser", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UnlockUser indicates an expected call of UnlockUser.
+func (mr *MockRepositoryInterfaceMockRecorder) UnlockUser(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnlockUser", reflect.TypeOf((*MockRepositoryInterface)(nil).UnlockUser), arg0, arg1)
+}
+
+// UpdateNotificationDelivery mocks base method.
+func (m *MockRepositoryInterface) UpdateNotificationDelivery(arg0 context.Context, arg1 NotificationDelivery) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateNotificationDelivery", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateNotificationDelivery indicates an expected call of UpdateNotificationDelivery.
+func (mr *MockRepositoryInterfaceMockRecorder) UpdateNotificationDelivery(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateNotificationDelivery", reflect.TypeOf((*MockRepositoryInterface)(nil).UpdateNotificationDelivery), arg0, arg1)
+}
+
+// UpdatePassword mocks base method.
+func (m *MockRepositoryInterface) UpdatePassword(arg0 context.Context, arg1, arg2 string, arg3 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdatePassword", arg0, arg1, arg2, arg3)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// UpdateLoginUser indicates an expected call of UpdateLoginUser.
-func (mr *MockRepositoryInterfaceMockRecorder) UpdateLoginUser(arg0, arg1 interface{}) *gomock.Call {
+// UpdatePassword indicates an expected call of UpdatePassword.
+func (mr *MockRepositoryInterfaceMockRecorder) UpdatePassword(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateLoginUser", reflect.TypeOf((*MockRepositoryInterface)(nil).UpdateLoginUser), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePassword", reflect.TypeOf((*MockRepositoryInterface)(nil).UpdatePassword), arg0, arg1, arg2, arg3)
 }
 
 // UpdateUserProfile mocks base method.
@@ -120,3 +684,77 @@ func (mr *MockRepositoryInterfaceMockRecorder) UpdateUserProfile(arg0, arg1 inte
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUserProfile", reflect.TypeOf((*MockRepositoryInterface)(nil).UpdateUserProfile), arg0, arg1)
 }
+
+// UsePasswordResetCode mocks base method.
+func (m *MockRepositoryInterface) UsePasswordResetCode(arg0 context.Context, arg1 int, arg2 time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UsePasswordResetCode", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UsePasswordResetCode indicates an expected call of UsePasswordResetCode.
+func (mr *MockRepositoryInterfaceMockRecorder) UsePasswordResetCode(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UsePasswordResetCode", reflect.TypeOf((*MockRepositoryInterface)(nil).UsePasswordResetCode), arg0, arg1, arg2)
+}
+
+// UsePhoneVerificationCode mocks base method.
+func (m *MockRepositoryInterface) UsePhoneVerificationCode(arg0 context.Context, arg1 int, arg2 time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UsePhoneVerificationCode", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UsePhoneVerificationCode indicates an expected call of UsePhoneVerificationCode.
+func (mr *MockRepositoryInterfaceMockRecorder) UsePhoneVerificationCode(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UsePhoneVerificationCode", reflect.TypeOf((*MockRepositoryInterface)(nil).UsePhoneVerificationCode), arg0, arg1, arg2)
+}
+
+// UseRecoveryCode mocks base method.
+func (m *MockRepositoryInterface) UseRecoveryCode(arg0 context.Context, arg1, arg2 string, arg3 time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseRecoveryCode", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UseRecoveryCode indicates an expected call of UseRecoveryCode.
+func (mr *MockRepositoryInterfaceMockRecorder) UseRecoveryCode(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseRecoveryCode", reflect.TypeOf((*MockRepositoryInterface)(nil).UseRecoveryCode), arg0, arg1, arg2, arg3)
+}
+
+// UseTOTPStep mocks base method.
+func (m *MockRepositoryInterface) UseTOTPStep(arg0 context.Context, arg1 string, arg2 int64) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseTOTPStep", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UseTOTPStep indicates an expected call of UseTOTPStep.
+func (mr *MockRepositoryInterfaceMockRecorder) UseTOTPStep(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseTOTPStep", reflect.TypeOf((*MockRepositoryInterface)(nil).UseTOTPStep), arg0, arg1, arg2)
+}
+
+// VerifyPhoneNumber mocks base method.
+func (m *MockRepositoryInterface) VerifyPhoneNumber(arg0 context.Context, arg1, arg2 string, arg3 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyPhoneNumber", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// VerifyPhoneNumber indicates an expected call of VerifyPhoneNumber.
+func (mr *MockRepositoryInterfaceMockRecorder) VerifyPhoneNumber(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyPhoneNumber", reflect.TypeOf((*MockRepositoryInterface)(nil).VerifyPhoneNumber), arg0, arg1, arg2, arg3)
+}
@@ -2,16 +2,22 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	_ "github.com/lib/pq"
+	"time"
 )
 
 type Repository struct {
 	Db *sql.DB
+	// QueryTimeout bounds every query on top of the deadline of the caller's
+	// context. Zero means no bound of its own.
+	QueryTimeout time.Duration
 }
 
 type NewRepositoryOptions struct {
-	Dsn string
+	Dsn          string
+	QueryTimeout time.Duration
 }
 
 func NewRepository(opts NewRepositoryOptions) *Repository {
@@ -20,6 +26,14 @@ func NewRepository(opts NewRepositoryOptions) *Repository {
 		panic(err)
 	}
 	return &Repository{
-		Db: db,
+		Db:           db,
+		QueryTimeout: opts.QueryTimeout,
 	}
 }
+
+func (r *Repository) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.QueryTimeout)
+}
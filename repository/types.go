@@ -11,10 +11,241 @@ type User struct {
 	Password                 string     `json:"password" gorm:"password,not null"`
 	SuccessfullLoginAttempts int64      `json:"sucessfull_login_attempts" gorm:"successfull_login_attempts, not null"`
 	LastLogin                *time.Time `json:"last_login" gorm:"last_login"`
+	FailedLoginAttempts      int64      `json:"failed_login_attempts" gorm:"failed_login_attempts,not null"`
+	LockedUntil              *time.Time `json:"locked_until" gorm:"locked_until"`
+	PhoneVerifiedAt          *time.Time `json:"phone_verified_at" gorm:"phone_verified_at"`
+	PendingPhoneNumber       *string    `json:"pending_phone_number" gorm:"pending_phone_number"`
+	TOTPSecret               *string    `json:"-" gorm:"totp_secret"`
+	TOTPEnabledAt            *time.Time `json:"totp_enabled_at" gorm:"totp_enabled_at"`
+	Role                     string     `json:"role" gorm:"role,not null"`
+	DisabledAt               *time.Time `json:"disabled_at" gorm:"disabled_at"`
 	CreatedAt                time.Time  `json:"created_at" gorm:"created_at,not null"`
 	UpdatedAt                time.Time  `json:"updated_at" gorm:"updated_at,not null"`
 }
 
+// UserSort is what ListUsers orders users by. Users with the same value are
+// ordered by id.
+type UserSort string
+
+const (
+	SortByCreatedAt UserSort = "created_at"
+	// SortByLastLogin puts users who never logged in before everyone else.
+	SortByLastLogin UserSort = "last_login"
+	SortByFullName  UserSort = "full_name"
+)
+
+// UserStatus filters ListUsers on the state of an account.
+type UserStatus string
+
+const (
+	// UserStatusActive is a user who is neither disabled nor locked.
+	UserStatusActive   UserStatus = "active"
+	UserStatusDisabled UserStatus = "disabled"
+	UserStatusLocked   UserStatus = "locked"
+)
+
+// UserCursor is the position of a user in a listing: the value they are
+// sorted by, as returned by User.Cursor, and their id.
+type UserCursor struct {
+	Value string
+	ID    int
+}
+
+// ListUsersInput asks for up to Limit users that are not deleted, ordered by
+// Sort. After starts the page right after a user and Before ends it right
+// before one; at most one of them is set.
+//
+// The From bounds of the ranges are inclusive and the To bounds exclusive.
+// NamePrefix matches regardless of case.
+type ListUsersInput struct {
+	Limit      int
+	Sort       UserSort
+	Descending bool
+	After      *UserCursor
+	Before     *UserCursor
+
+	CreatedFrom   *time.Time
+	CreatedTo     *time.Time
+	LastLoginFrom *time.Time
+	LastLoginTo   *time.Time
+	NamePrefix    string
+	PhonePrefix   string
+	Status        UserStatus
+	// Now tells which users are locked.
+	Now time.Time
+}
+
+// UserPage is a page of ListUsers. HasMore reports whether more users follow
+// the page, or precede it when the page was asked for with Before.
+type UserPage struct {
+	Users   []User
+	HasMore bool
+}
+
+// Cursor returns the position of the user in a listing ordered by sort.
+func (u User) Cursor(sort UserSort) UserCursor {
+	cursor := UserCursor{ID: u.ID}
+	switch sort {
+	case SortByLastLogin:
+		cursor.Value = "-infinity"
+		if u.LastLogin != nil {
+			cursor.Value = u.LastLogin.Format(time.RFC3339Nano)
+		}
+	case SortByFullName:
+		cursor.Value = u.FullName
+	default:
+		cursor.Value = u.CreatedAt.Format(time.RFC3339Nano)
+	}
+	return cursor
+}
+
+// UserSearchInput finds up to Limit users that are not deleted whose name is
+// like Query, misspelled or partly typed, or whose phone number starts with
+// PhonePrefix when it is set.
+type UserSearchInput struct {
+	Query       string
+	PhonePrefix string
+	Limit       int
+}
+
+// UserMatch is a user found by SearchUsers. Rank, from 0 to 1, tells how well
+// they match; a phone number or a name starting with the query ranks 1.
+type UserMatch struct {
+	User
+	Rank float64
+}
+
+// DeleteUserInput soft deletes the user with UserID at DeletedAt. The account
+// and its phone number are kept until PurgeAfter, when PurgeDeletedUsers
+// removes them for good.
+type DeleteUserInput struct {
+	UserID    string
+	DeletedAt time.Time
+	// TokensValidAfter revokes every token issued before it.
+	TokensValidAfter time.Time
+	PurgeAfter       time.Time
+}
+
+// LoginAttemptInput reserves one login attempt for the user with ID. Once
+// MaxFailedAttempts attempts have been made without a successful login, the
+// account is locked until LockedUntil.
+type LoginAttemptInput struct {
+	ID                int
+	MaxFailedAttempts int
+	LockedUntil       time.Time
+	AttemptedAt       time.Time
+}
+
+// TokenFamily groups every refresh token issued from one login, and is the
+// session users see in their session list. Reusing a refresh token revokes the
+// whole family.
+type TokenFamily struct {
+	ID         int        `json:"id"`
+	FamilyID   string     `json:"family_id"`
+	UserID     string     `json:"user_id"`
+	DeviceName string     `json:"device_name"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// TokenFamilySeen updates where and when the session of FamilyID was last used.
+type TokenFamilySeen struct {
+	FamilyID  string
+	IPAddress string
+	UserAgent string
+	SeenAt    time.Time
+}
+
+type RefreshToken struct {
+	ID              int        `json:"id"`
+	TokenID         string     `json:"token_id"`
+	FamilyID        string     `json:"family_id"`
+	UserID          string     `json:"user_id"`
+	UsedAt          *time.Time `json:"used_at"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	FamilyRevokedAt *time.Time `json:"family_revoked_at"`
+}
+
+// RevokedToken is an access token that was invalidated before it expired.
+type RevokedToken struct {
+	ID        int       `json:"id"`
+	TokenID   string    `json:"token_id"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// PasswordResetCode is a one-time code that lets the owner of PhoneNumber set
+// a new password. Only the hash of the code is stored.
+type PasswordResetCode struct {
+	ID          int        `json:"id"`
+	UserID      string     `json:"user_id"`
+	PhoneNumber string     `json:"phone_number"`
+	CodeHash    string     `json:"code_hash"`
+	Attempts    int        `json:"attempts"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// PhoneVerificationCode is a hashed one-time code proving the user controls
+// PhoneNumber, either the number they registered with or the one they are
+// changing to.
+type PhoneVerificationCode struct {
+	ID          int        `json:"id"`
+	UserID      string     `json:"user_id"`
+	PhoneNumber string     `json:"phone_number"`
+	CodeHash    string     `json:"code_hash"`
+	Attempts    int        `json:"attempts"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// EnableTOTPInput turns on two-factor authentication for the user with UserID
+// once they proved their authenticator works with the code of time step Step.
+// The recovery codes replace any the user had before.
+type EnableTOTPInput struct {
+	UserID             string
+	Step               int64
+	RecoveryCodeHashes []string
+	EnabledAt          time.Time
+}
+
+// AuditEvent is one row of the append-only audit log. Changes holds a JSON
+// object of the fields the event changed, or nil.
+type AuditEvent struct {
+	ID        int64     `json:"id"`
+	UserID    string    `json:"user_id"`
+	ActorID   *string   `json:"actor_id"`
+	EventType string    `json:"event_type"`
+	Reason    string    `json:"reason"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	Changes   []byte    `json:"changes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NotificationDelivery records what happened to one message handed to the
+// notifier. The message body is not stored since it may hold a one-time code.
+type NotificationDelivery struct {
+	ID          int        `json:"id"`
+	MessageID   string     `json:"message_id"`
+	PhoneNumber string     `json:"phone_number"`
+	Template    string     `json:"template"`
+	Provider    string     `json:"provider"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	LastError   *string    `json:"last_error"`
+	SentAt      *time.Time `json:"sent_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
 type GetTestByIdInput struct {
 	Id string
 }
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCodeHasher(t *testing.T) {
+	hasher, err := NewCodeHasher(bytes.Repeat([]byte{1}, 32))
+	assert.NoError(t, err)
+
+	hash := hasher.Hash("123456")
+	assert.True(t, hasher.Verify("123456", hash))
+	assert.False(t, hasher.Verify("654321", hash))
+
+	// The same code hashes differently under another key
+	other, err := NewCodeHasher(bytes.Repeat([]byte{2}, 32))
+	assert.NoError(t, err)
+	assert.False(t, other.Verify("123456", hash))
+}
+
+func TestParseCodeHasherKey(t *testing.T) {
+	_, err := ParseCodeHasherKey(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	assert.NoError(t, err)
+
+	_, err = ParseCodeHasherKey(base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.Error(t, err)
+
+	_, err = ParseCodeHasherKey("not base64!")
+	assert.Error(t, err)
+}
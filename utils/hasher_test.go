@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+var testArgon2idParams = Argon2idParams{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestPasswordHasherHashAndVerify(t *testing.T) {
+	hasher := NewPasswordHasher(testArgon2idParams)
+
+	hash, err := hasher.Hash("password")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	otherHash, err := hasher.Hash("password")
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, otherHash, "every hash gets its own salt")
+
+	assert.NoError(t, hasher.Verify("password", hash))
+	assert.ErrorIs(t, hasher.Verify("wrongpassword", hash), ErrPasswordMismatch)
+	assert.False(t, hasher.NeedsRehash(hash))
+}
+
+func TestPasswordHasherVerifiesBcrypt(t *testing.T) {
+	hasher := NewPasswordHasher(testArgon2idParams)
+	legacyHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	assert.NoError(t, hasher.Verify("password", string(legacyHash)))
+	assert.ErrorIs(t, hasher.Verify("wrongpassword", string(legacyHash)), ErrPasswordMismatch)
+	assert.True(t, hasher.NeedsRehash(string(legacyHash)))
+}
+
+func TestPasswordHasherNeedsRehash(t *testing.T) {
+	oldHash, err := NewPasswordHasher(testArgon2idParams).Hash("password")
+	assert.NoError(t, err)
+
+	stronger := testArgon2idParams
+	stronger.Iterations = 2
+	hasher := NewPasswordHasher(stronger)
+
+	assert.True(t, hasher.NeedsRehash(oldHash))
+	assert.NoError(t, hasher.Verify("password", oldHash), "old parameters still verify")
+	assert.True(t, hasher.NeedsRehash("not a hash"))
+	assert.Error(t, hasher.Verify("password", "$argon2id$v=19$m=1024,t=1,p=1$bad"))
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Span is the part of a text from rune Start up to, but not including, rune End.
+type Span struct {
+	Start int
+	End   int
+}
+
+// HighlightMatches returns the parts of text that match a word of query, in
+// order: where the word appears in text regardless of case, or else every word
+// of text at least threshold alike to it, by trigram similarity as pg_trgm
+// computes it, so misspellings the database found are marked too.
+func HighlightMatches(text, query string, threshold float64) []Span {
+	textRunes, queryRunes := lowerRunes(text), lowerRunes(query)
+	textWords := words(textRunes)
+
+	var spans []Span
+	for _, queryWord := range words(queryRunes) {
+		needle := string(queryRunes[queryWord.Start:queryWord.End])
+		needleLength := queryWord.End - queryWord.Start
+		for _, textWord := range textWords {
+			word := string(textRunes[textWord.Start:textWord.End])
+			if i := strings.Index(word, needle); i >= 0 {
+				start := textWord.Start + utf8.RuneCountInString(word[:i])
+				spans = append(spans, Span{Start: start, End: start + needleLength})
+			} else if trigramSimilarity(word, needle) >= threshold {
+				spans = append(spans, textWord)
+			}
+		}
+	}
+	return mergeSpans(spans)
+}
+
+// lowerRunes lowercases rune by rune, so offsets into the result are offsets
+// into s.
+func lowerRunes(s string) []rune {
+	runes := []rune(s)
+	for i, r := range runes {
+		runes[i] = unicode.ToLower(r)
+	}
+	return runes
+}
+
+// words returns the runs of letters and digits, which is what pg_trgm takes
+// trigrams of.
+func words(runes []rune) []Span {
+	var spans []Span
+	start := -1
+	for i, r := range runes {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if inWord && start < 0 {
+			start = i
+		} else if !inWord && start >= 0 {
+			spans = append(spans, Span{Start: start, End: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, Span{Start: start, End: len(runes)})
+	}
+	return spans
+}
+
+func trigrams(word string) map[string]bool {
+	padded := []rune("  " + word + " ")
+	set := make(map[string]bool, len(padded))
+	for i := 0; i+3 <= len(padded); i++ {
+		set[string(padded[i:i+3])] = true
+	}
+	return set
+}
+
+// trigramSimilarity is the share of the trigrams of a and b they have in
+// common, from 0 to 1.
+func trigramSimilarity(a, b string) float64 {
+	aTrigrams, bTrigrams := trigrams(a), trigrams(b)
+	common := 0
+	for trigram := range aTrigrams {
+		if bTrigrams[trigram] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(aTrigrams)+len(bTrigrams)-common)
+}
+
+func mergeSpans(spans []Span) []Span {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	merged := []Span{}
+	for _, span := range spans {
+		if last := len(merged) - 1; last >= 0 && span.Start <= merged[last].End {
+			if span.End > merged[last].End {
+				merged[last].End = span.End
+			}
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return merged
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHighlightMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		query    string
+		expected []Span
+	}{
+		{"Substring Regardless Of Case", "Budi Santoso", "SANT", []Span{{Start: 5, End: 9}}},
+		{"Every Word Of The Query", "Budi Santoso", "santoso budi", []Span{{Start: 0, End: 4}, {Start: 5, End: 12}}},
+		{"Misspelled Word", "Budi Santoso", "santosa", []Span{{Start: 5, End: 12}}},
+		{"Overlapping Matches Merge", "Anastasia", "ana nas", []Span{{Start: 0, End: 4}}},
+		{"Offsets Count Runes", "Zoë Ölander", "ölan", []Span{{Start: 4, End: 8}}},
+		{"Nothing Alike", "Budi Santoso", "xyz", []Span{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, HighlightMatches(tc.text, tc.query, 0.3))
+		})
+	}
+}
+
+func TestTrigramSimilarity(t *testing.T) {
+	// The values pg_trgm's similarity() returns for the same words
+	assert.Equal(t, 1.0, trigramSimilarity("word", "word"))
+	assert.InDelta(t, 0.571429, trigramSimilarity("word", "words"), 0.000001)
+	assert.Equal(t, 0.0, trigramSimilarity("abc", "xyz"))
+}
@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SigningKey is one entry of the KeyRing. Retired keys only have a public
+// key: they can still verify the tokens they signed but never sign new ones.
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+}
+
+// NewSigningKey wraps a private or public RSA or P-256 ECDSA key, picking
+// RS256 or ES256 from the key type.
+func NewSigningKey(kid string, key interface{}) (*SigningKey, error) {
+	signingKey := &SigningKey{ID: kid}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signingKey.Method, signingKey.PrivateKey, signingKey.PublicKey = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		signingKey.Method, signingKey.PublicKey = jwt.SigningMethodRS256, k
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("key %s: only P-256 ECDSA keys are supported", kid)
+		}
+		signingKey.Method, signingKey.PrivateKey, signingKey.PublicKey = jwt.SigningMethodES256, k, &k.PublicKey
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("key %s: only P-256 ECDSA keys are supported", kid)
+		}
+		signingKey.Method, signingKey.PublicKey = jwt.SigningMethodES256, k
+	default:
+		return nil, fmt.Errorf("key %s: unsupported key type %T", kid, key)
+	}
+	return signingKey, nil
+}
+
+// KeyRing holds the key used to sign new tokens and every key whose tokens
+// may still be in circulation.
+type KeyRing struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+// NewKeyRing builds a KeyRing that signs with the key identified by activeKID.
+func NewKeyRing(activeKID string, keys ...*SigningKey) (*KeyRing, error) {
+	ring := &KeyRing{keys: map[string]*SigningKey{}}
+	for _, key := range keys {
+		if _, ok := ring.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %s", key.ID)
+		}
+		ring.keys[key.ID] = key
+	}
+
+	active, ok := ring.keys[activeKID]
+	if !ok {
+		return nil, fmt.Errorf("active key %s not found", activeKID)
+	}
+	if active.PrivateKey == nil {
+		return nil, fmt.Errorf("active key %s has no private key", activeKID)
+	}
+	ring.active = active
+	return ring, nil
+}
+
+// LoadKeyRing reads every <kid>.pem file in dir. To rotate, add the new
+// private key, point activeKID at it and replace the old private key with its
+// public half. Keep that public key in dir until RefreshTokenTTL has passed so
+// the tokens it signed keep verifying until they expire.
+func LoadKeyRing(dir, activeKID string) (*KeyRing, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*SigningKey
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parsePEMKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+		signingKey, err := NewSigningKey(kid, key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, signingKey)
+	}
+
+	return NewKeyRing(activeKID, keys...)
+}
+
+func parsePEMKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
+
+// Sign signs claims with the active key and sets the kid header.
+func (k *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.active.Method, claims)
+	token.Header["kid"] = k.active.ID
+	return token.SignedString(k.active.PrivateKey)
+}
+
+// Keyfunc picks the verification key by the kid header of token. The key's
+// own algorithm must match the token's so alg can't be swapped by a caller.
+func (k *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+	return key.PublicKey, nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every key in the ring so other services can
+// verify our tokens.
+func (k *KeyRing) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range k.keys {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch pub := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			jwk.Kty = "EC"
+			jwk.Crv = "P-256"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32)))
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
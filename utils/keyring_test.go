@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	assert.NoError(t, os.WriteFile(path, data, 0600))
+}
+
+func TestLoadKeyRingRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	oldDER, err := x509.MarshalPKCS8PrivateKey(oldKey)
+	assert.NoError(t, err)
+	writePEM(t, filepath.Join(dir, "2024-01.pem"), "PRIVATE KEY", oldDER)
+
+	oldRing, err := LoadKeyRing(dir, "2024-01")
+	assert.NoError(t, err)
+	oldPair, err := GenerateTokenPair("testUserID", "", RoleUser, oldRing)
+	assert.NoError(t, err)
+
+	// Rotate: the new key signs, the old one is kept as a public key only
+	newDER, err := x509.MarshalECPrivateKey(newKey)
+	assert.NoError(t, err)
+	writePEM(t, filepath.Join(dir, "2024-02.pem"), "EC PRIVATE KEY", newDER)
+	oldPublicDER, err := x509.MarshalPKIXPublicKey(&oldKey.PublicKey)
+	assert.NoError(t, err)
+	writePEM(t, filepath.Join(dir, "2024-01.pem"), "PUBLIC KEY", oldPublicDER)
+
+	newRing, err := LoadKeyRing(dir, "2024-02")
+	assert.NoError(t, err)
+	newPair, err := GenerateTokenPair("testUserID", "", RoleUser, newRing)
+	assert.NoError(t, err)
+	oldToken, newToken := oldPair.AccessToken, newPair.AccessToken
+
+	for _, token := range []string{oldToken, newToken} {
+		claims, err := ValidateJWTToken(token, newRing, DefaultJWTIssuer, DefaultJWTAudience)
+		assert.NoError(t, err)
+		assert.Equal(t, "testUserID", claims.UserID)
+	}
+
+	parsed, _, err := new(jwt.Parser).ParseUnverified(newToken, &JWTClaims{})
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-02", parsed.Header["kid"])
+	assert.Equal(t, "ES256", parsed.Header["alg"])
+
+	_, err = LoadKeyRing(dir, "2024-01")
+	assert.Error(t, err, "a public-only key can't be the active key")
+}
+
+func TestKeyRingRejectsAlgorithmSwap(t *testing.T) {
+	keys := newTestKeyRing(t, "testKey")
+
+	// An HS256 token using the public key as HMAC secret must not verify
+	claims := JWTClaims{
+		UserID:    "testUserID",
+		TokenType: AccessTokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    DefaultJWTIssuer,
+			Audience:  jwt.ClaimStrings{DefaultJWTAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = "testKey"
+	forged, err := token.SignedString([]byte("testKey"))
+	assert.NoError(t, err)
+
+	_, err = ValidateJWTToken(forged, keys, DefaultJWTIssuer, DefaultJWTAudience)
+	assert.Error(t, err)
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	rsaSigningKey, err := NewSigningKey("rsaKey", &rsaKey.PublicKey)
+	assert.NoError(t, err)
+	ecSigningKey, err := NewSigningKey("ecKey", ecKey)
+	assert.NoError(t, err)
+	keys, err := NewKeyRing("ecKey", rsaSigningKey, ecSigningKey)
+	assert.NoError(t, err)
+
+	set := keys.JWKS()
+	assert.Len(t, set.Keys, 2)
+
+	assert.Equal(t, "ecKey", set.Keys[0].Kid)
+	assert.Equal(t, "EC", set.Keys[0].Kty)
+	assert.Equal(t, "ES256", set.Keys[0].Alg)
+	assert.Equal(t, "P-256", set.Keys[0].Crv)
+	assert.Len(t, set.Keys[0].X, 43)
+
+	assert.Equal(t, "rsaKey", set.Keys[1].Kid)
+	assert.Equal(t, "RSA", set.Keys[1].Kty)
+	assert.Equal(t, "RS256", set.Keys[1].Alg)
+	assert.Equal(t, "AQAB", set.Keys[1].E)
+}
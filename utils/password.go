@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxPasswordBytes is the longest password bcrypt hashes in full; anything
+// after the 72nd byte would be silently ignored. New hashes are Argon2id,
+// which has no such limit, but the cap still bounds the work per hash.
+const MaxPasswordBytes = 72
+
+// Password policy rule names, reported in PasswordViolation.Rule.
+const (
+	PasswordRuleMinLength    = "min_length"
+	PasswordRuleMaxBytes     = "max_bytes"
+	PasswordRuleUppercase    = "uppercase"
+	PasswordRuleLowercase    = "lowercase"
+	PasswordRuleDigit        = "digit"
+	PasswordRuleSymbol       = "symbol"
+	PasswordRuleCommon       = "common"
+	PasswordRulePersonalInfo = "personal_info"
+)
+
+// minPersonalInfoPartLength keeps short name parts like "Al" from rejecting
+// unrelated passwords.
+const minPersonalInfoPartLength = 3
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+var commonPasswords = loadCommonPasswords(commonPasswordsFile)
+
+func loadCommonPasswords(list string) map[string]bool {
+	passwords := map[string]bool{}
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			passwords[strings.ToLower(line)] = true
+		}
+	}
+	return passwords
+}
+
+// PasswordPolicy describes what a new password must look like. The zero value
+// only enforces MaxPasswordBytes.
+type PasswordPolicy struct {
+	MinLength      int
+	MaxBytes       int
+	RequireUpper   bool
+	RequireLower   bool
+	RequireDigit   bool
+	RequireSymbol  bool
+	RejectCommon   bool
+	RejectPersonal bool
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:      8,
+	MaxBytes:       MaxPasswordBytes,
+	RequireUpper:   true,
+	RequireLower:   true,
+	RequireDigit:   true,
+	RequireSymbol:  false,
+	RejectCommon:   true,
+	RejectPersonal: true,
+}
+
+// PasswordViolation is one rule of the policy a password does not meet.
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Validate returns every rule password breaks, or nil when it is acceptable.
+// personalInfo holds values such as the user's phone number and name that the
+// password must not contain.
+func (p PasswordPolicy) Validate(password string, personalInfo ...string) []PasswordViolation {
+	var violations []PasswordViolation
+
+	maxBytes := p.MaxBytes
+	if maxBytes <= 0 || maxBytes > MaxPasswordBytes {
+		maxBytes = MaxPasswordBytes
+	}
+	if p.MinLength > 0 && utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, PasswordViolation{PasswordRuleMinLength,
+			fmt.Sprintf("Password Must Be At Least %d Characters Long", p.MinLength)})
+	}
+	if len(password) > maxBytes {
+		violations = append(violations, PasswordViolation{PasswordRuleMaxBytes,
+			fmt.Sprintf("Password Must Not Be Longer Than %d Bytes", maxBytes)})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, PasswordViolation{PasswordRuleUppercase,
+			"Password Must Contain An Uppercase Letter"})
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, PasswordViolation{PasswordRuleLowercase,
+			"Password Must Contain A Lowercase Letter"})
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, PasswordViolation{PasswordRuleDigit,
+			"Password Must Contain A Digit"})
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, PasswordViolation{PasswordRuleSymbol,
+			"Password Must Contain A Symbol"})
+	}
+
+	if p.RejectCommon && commonPasswords[strings.ToLower(password)] {
+		violations = append(violations, PasswordViolation{PasswordRuleCommon,
+			"Password Is Too Common"})
+	}
+	if p.RejectPersonal && containsPersonalInfo(password, personalInfo) {
+		violations = append(violations, PasswordViolation{PasswordRulePersonalInfo,
+			"Password Must Not Contain Your Phone Number Or Name"})
+	}
+
+	return violations
+}
+
+// containsPersonalInfo compares case-insensitively against the digits of
+// phone numbers and each word of a name, skipping parts too short to matter.
+func containsPersonalInfo(password string, personalInfo []string) bool {
+	password = strings.ToLower(password)
+	for _, info := range personalInfo {
+		parts := strings.Fields(strings.ToLower(info))
+		if len(parts) > 1 {
+			parts = append(parts, strings.Join(parts, ""))
+		}
+		for _, part := range parts {
+			part = strings.TrimPrefix(part, "+")
+			if utf8.RuneCountInString(part) >= minPersonalInfoPartLength && strings.Contains(password, part) {
+				return true
+			}
+		}
+	}
+	return false
+}
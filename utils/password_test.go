@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        PasswordPolicy
+		password      string
+		personalInfo  []string
+		expectedRules []string
+	}{
+		{
+			name:          "Valid Password",
+			policy:        DefaultPasswordPolicy,
+			password:      "Kebun-Sawit-2024",
+			personalInfo:  []string{"+62812345678", "John Doe"},
+			expectedRules: nil,
+		},
+		{
+			name:          "Too Short And Missing Classes",
+			policy:        DefaultPasswordPolicy,
+			password:      "abc",
+			expectedRules: []string{PasswordRuleMinLength, PasswordRuleUppercase, PasswordRuleDigit},
+		},
+		{
+			name:          "Longer Than Bcrypt Accepts",
+			policy:        DefaultPasswordPolicy,
+			password:      "Aa1" + strings.Repeat("x", MaxPasswordBytes),
+			expectedRules: []string{PasswordRuleMaxBytes},
+		},
+		{
+			name:          "Zero Policy Still Limits Bytes",
+			policy:        PasswordPolicy{},
+			password:      strings.Repeat("x", MaxPasswordBytes+1),
+			expectedRules: []string{PasswordRuleMaxBytes},
+		},
+		{
+			name:          "Missing Symbol",
+			policy:        PasswordPolicy{RequireSymbol: true},
+			password:      "Password1",
+			expectedRules: []string{PasswordRuleSymbol},
+		},
+		{
+			name:          "Common Password Any Case",
+			policy:        PasswordPolicy{RejectCommon: true},
+			password:      "PassWord123",
+			expectedRules: []string{PasswordRuleCommon},
+		},
+		{
+			name:          "Common Password",
+			policy:        DefaultPasswordPolicy,
+			password:      "P@ssw0rd123",
+			expectedRules: []string{PasswordRuleCommon},
+		},
+		{
+			name:          "Contains Phone Number",
+			policy:        DefaultPasswordPolicy,
+			password:      "Me62812345678",
+			personalInfo:  []string{"+62812345678", "John Doe"},
+			expectedRules: []string{PasswordRulePersonalInfo},
+		},
+		{
+			name:          "Contains Name",
+			policy:        DefaultPasswordPolicy,
+			password:      "JohnDoe2024",
+			personalInfo:  []string{"+62812345678", "John Doe"},
+			expectedRules: []string{PasswordRulePersonalInfo},
+		},
+		{
+			name:          "Short Name Part Is Ignored",
+			policy:        DefaultPasswordPolicy,
+			password:      "Always2024x",
+			personalInfo:  []string{"Al Bundy"},
+			expectedRules: nil,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var rules []string
+			for _, violation := range tc.policy.Validate(tc.password, tc.personalInfo...) {
+				assert.NotEmpty(t, violation.Message)
+				rules = append(rules, violation.Rule)
+			}
+			assert.Equal(t, tc.expectedRules, rules)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// SecretBox encrypts secrets that have to be stored in a form we can read
+// back, such as TOTP secrets, with AES-256-GCM.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox takes a 32 byte key.
+func NewSecretBox(key []byte) (*SecretBox, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secret box key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{aead: aead}, nil
+}
+
+// ParseSecretBoxKey decodes a base64 key as kept in the environment, e.g.
+// made with `openssl rand -base64 32`.
+func ParseSecretBoxKey(encoded string) (*SecretBox, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return NewSecretBox(key)
+}
+
+// Seal encrypts plaintext and returns it as base64 with the nonce in front.
+// context, e.g. the user ID, is authenticated but not stored, so the result
+// can't be moved to another user's row.
+func (b *SecretBox) Seal(plaintext, context string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(plaintext), []byte(context))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts what Seal returned for the same context.
+func (b *SecretBox) Open(sealed, context string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < b.aead.NonceSize() {
+		return "", errors.New("sealed secret is too short")
+	}
+	nonce, ciphertext := data[:b.aead.NonceSize()], data[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, []byte(context))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
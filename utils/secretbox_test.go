@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSecretBox(t *testing.T) {
+	box, err := NewSecretBox(bytes.Repeat([]byte{1}, 32))
+	assert.NoError(t, err)
+
+	sealed, err := box.Seal("JBSWY3DPEHPK3PXP", "user-1")
+	assert.NoError(t, err)
+	assert.NotContains(t, sealed, "JBSWY3DPEHPK3PXP")
+
+	otherSealed, err := box.Seal("JBSWY3DPEHPK3PXP", "user-1")
+	assert.NoError(t, err)
+	assert.NotEqual(t, sealed, otherSealed, "every seal gets its own nonce")
+
+	opened, err := box.Open(sealed, "user-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", opened)
+
+	_, err = box.Open(sealed, "user-2")
+	assert.Error(t, err, "a secret sealed for one user can't be opened for another")
+
+	otherBox, err := NewSecretBox(bytes.Repeat([]byte{2}, 32))
+	assert.NoError(t, err)
+	_, err = otherBox.Open(sealed, "user-1")
+	assert.Error(t, err)
+}
+
+func TestParseSecretBoxKey(t *testing.T) {
+	_, err := ParseSecretBoxKey(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	assert.NoError(t, err)
+
+	_, err = ParseSecretBoxKey(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 16)))
+	assert.Error(t, err)
+
+	_, err = ParseSecretBoxKey("not base64!")
+	assert.Error(t, err)
+}
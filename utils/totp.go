@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters of RFC 6238, using the defaults every authenticator app
+// understands.
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30 * time.Second
+	// TOTPSkew is how many periods either side of now a code is accepted, to
+	// allow for clock drift and slow typing.
+	TOTPSkew = 1
+
+	totpSecretBytes = 20
+)
+
+var ErrInvalidTOTPCode = errors.New("invalid totp code")
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a random 160 bit secret in unpadded base32, the
+// form authenticator apps expect.
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPURI returns the otpauth:// URI that authenticator apps read, usually
+// from a QR code, to add the account.
+func TOTPURI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TOTPDigits))
+	query.Set("period", fmt.Sprint(int(TOTPPeriod.Seconds())))
+	// The label is escaped in full, since some apps read a literal + as a space
+	label := strings.ReplaceAll(url.QueryEscape(issuer+":"+account), "+", "%20")
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// TOTPCode returns the code for secret at t.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return totpCodeAt(key, totpStep(t)), nil
+}
+
+// VerifyTOTP checks code against secret at t and returns the time step it
+// matched. Callers remember the step so a code can't be used twice. It
+// returns ErrInvalidTOTPCode when the code does not match.
+func VerifyTOTP(secret, code string, t time.Time) (int64, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return 0, err
+	}
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return 0, ErrInvalidTOTPCode
+	}
+	now := totpStep(t)
+	for step := now - TOTPSkew; step <= now+TOTPSkew; step++ {
+		if hmac.Equal([]byte(totpCodeAt(key, step)), []byte(code)) {
+			return step, nil
+		}
+	}
+	return 0, ErrInvalidTOTPCode
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod.Seconds())
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	return totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+}
+
+// totpCodeAt is the HOTP value of RFC 4226 for counter step.
+func totpCodeAt(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%modulo)
+}
+
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// GenerateRecoveryCode returns a single-use code such as "k7fq2-m9xtr" that
+// logs a user in when their authenticator is lost. The alphabet leaves out
+// characters that are easy to mistake for each other.
+func GenerateRecoveryCode() (string, error) {
+	var code strings.Builder
+	for i := 0; i < 10; i++ {
+		if i == 5 {
+			code.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(recoveryCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(recoveryCodeAlphabet[n.Int64()])
+	}
+	return code.String(), nil
+}
+
+// HashRecoveryCode returns the SHA-256 of a recovery code, ignoring case,
+// spaces and dashes. Recovery codes are random enough that a fast hash is
+// safe, and it lets a code be looked up by its hash.
+func HashRecoveryCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed "12345678901234567890" of RFC 6238 in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// The last six digits of the RFC 6238 SHA-1 test vectors
+	tests := []struct {
+		unix         int64
+		expectedCode string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tc := range tests {
+		code, err := TOTPCode(rfc6238Secret, time.Unix(tc.unix, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expectedCode, code, "at %d", tc.unix)
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := now.Unix() / 30
+
+	tests := []struct {
+		name          string
+		codeAt        time.Time
+		code          string
+		expectedStep  int64
+		expectedError error
+	}{
+		{name: "Current Code", codeAt: now, expectedStep: step},
+		{name: "Previous Code", codeAt: now.Add(-TOTPPeriod), expectedStep: step - 1},
+		{name: "Next Code", codeAt: now.Add(TOTPPeriod), expectedStep: step + 1},
+		{name: "Too Old", codeAt: now.Add(-2 * TOTPPeriod), expectedError: ErrInvalidTOTPCode},
+		{name: "Wrong Length", code: "12345", expectedError: ErrInvalidTOTPCode},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			code := tc.code
+			if code == "" {
+				code, _ = TOTPCode(rfc6238Secret, tc.codeAt)
+			}
+			matched, err := VerifyTOTP(rfc6238Secret, code, now)
+			if tc.expectedError != nil {
+				assert.ErrorIs(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedStep, matched)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	otherSecret, err := GenerateTOTPSecret()
+	assert.NoError(t, err)
+	assert.NotEqual(t, secret, otherSecret)
+
+	code, err := TOTPCode(secret, time.Now())
+	assert.NoError(t, err)
+	_, err = VerifyTOTP(secret, code, time.Now())
+	assert.NoError(t, err)
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTPURI("UserService", "+62812345678", rfc6238Secret))
+	assert.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/UserService:+62812345678", uri.Path)
+	assert.Equal(t, rfc6238Secret, uri.Query().Get("secret"))
+	assert.Equal(t, "UserService", uri.Query().Get("issuer"))
+	assert.Equal(t, "6", uri.Query().Get("digits"))
+	assert.Equal(t, "30", uri.Query().Get("period"))
+}
+
+func TestRecoveryCode(t *testing.T) {
+	code, err := GenerateRecoveryCode()
+	assert.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[a-z2-9]{5}-[a-z2-9]{5}$`), code)
+
+	otherCode, err := GenerateRecoveryCode()
+	assert.NoError(t, err)
+	assert.NotEqual(t, code, otherCode)
+
+	assert.Equal(t, HashRecoveryCode("abcde-fghjk"), HashRecoveryCode("ABCDE FGHJK"))
+	assert.Equal(t, HashRecoveryCode("abcde-fghjk"), HashRecoveryCode("abcdefghjk"))
+	assert.NotEqual(t, HashRecoveryCode("abcde-fghjk"), HashRecoveryCode("abcde-fghjm"))
+}
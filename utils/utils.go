@@ -1,110 +1,199 @@
 package utils
 
 import (
+	"crypto/rand"
 	"errors"
+	"fmt"
 	"github.com/golang-jwt/jwt/v4"
-	"golang.org/x/crypto/bcrypt"
-	"strconv"
-	"strings"
+	"github.com/google/uuid"
+	"math/big"
+	"os"
 	"time"
 )
 
+const (
+	AccessTokenType  = "access"
+	RefreshTokenType = "refresh"
+	// MFATokenType is the challenge handed out by a login that still needs a
+	// second factor. It can only be exchanged for a token pair.
+	MFATokenType = "mfa"
+
+	DefaultJWTIssuer   = "UserService"
+	DefaultJWTAudience = "UserService"
+
+	// ScopePhoneVerify limits a token to verifying the phone number of an
+	// account that has not done so yet. Tokens without scopes are unrestricted.
+	ScopePhoneVerify = "phone:verify"
+
+	// Roles of users. Every user has RoleUser unless support staff or an
+	// administrator was given another one.
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+
+	AccessTokenTTL  = time.Minute * 15   // Access token expires in 15 minutes
+	RefreshTokenTTL = time.Hour * 24 * 7 // Refresh token expires in 7 days
+	MFATokenTTL     = time.Minute * 5    // MFA challenge expires in 5 minutes
+)
+
 type JWTClaims struct {
-	UserID string `json:"user_id"`
+	UserID    string   `json:"user_id"`
+	TokenType string   `json:"type"`
+	FamilyID  string   `json:"fid,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+	Role      string   `json:"role,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func CheckPhoneNumber(s string) bool {
-	if strings.HasPrefix(s, "+") {
-		_, err := strconv.Atoi(s[1:])
-		if err != nil {
-			return false
-		}
-		return true
-	}
-	return false
-}
-
+// HashPassword hashes password with Argon2id and DefaultArgon2idParams.
 func HashPassword(password string) (string, error) {
-	cost := 10
+	return defaultPasswordHasher.Hash(password)
+}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), cost)
-	if err != nil {
-		return "", err
-	}
+// CheckPassword verifies providedPassword against an Argon2id or bcrypt hash.
+func CheckPassword(providedPassword, hashedPassword string) error {
+	return defaultPasswordHasher.Verify(providedPassword, hashedPassword)
+}
 
-	return string(hashedPassword), nil
+// TokenPair is a freshly signed access and refresh token. The refresh token ID
+// and expiry are kept so the caller can track the refresh token server side.
+type TokenPair struct {
+	AccessToken      string
+	RefreshToken     string
+	RefreshTokenID   string
+	RefreshExpiresAt time.Time
 }
 
-func CheckPassword(providedPassword, hashedPassword string) error {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(providedPassword))
+// GenerateNumericCode returns a random code of the given number of digits for
+// one-time codes sent to users.
+func GenerateNumericCode(digits int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+	n, err := rand.Int(rand.Reader, max)
 	if err != nil {
-		return err
+		return "", err
 	}
-	return nil
+	return fmt.Sprintf("%0*d", digits, n), nil
 }
 
-func GenerateJWTToken(userID, secret string) (string, string, error) {
-	signingKey := []byte(secret)
+// GenerateTokenPair signs an access and refresh token for userID. Both carry
+// the token family of the login so the whole session can be revoked at once,
+// the role of the user and the scopes, if any, the tokens are restricted to.
+func GenerateTokenPair(userID, familyID, role string, keys *KeyRing, scopes ...string) (*TokenPair, error) {
+	now := time.Now()
 	// Generate access token
 	accessTokenClaims := JWTClaims{
-		UserID: userID,
+		UserID:    userID,
+		TokenType: AccessTokenType,
+		FamilyID:  familyID,
+		Scopes:    scopes,
+		Role:      role,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute * 15)), // Access token expires in 15 minutes
+			ID:        uuid.New().String(),
+			Issuer:    JWTIssuer(),
+			Audience:  jwt.ClaimStrings{JWTAudience()},
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
 		},
 	}
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessTokenClaims)
-	accessTokenString, err := accessToken.SignedString(signingKey)
+	accessTokenString, err := keys.Sign(accessTokenClaims)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
 	// Generate refresh token
+	refreshExpiresAt := now.Add(RefreshTokenTTL)
 	refreshTokenClaims := JWTClaims{
-		UserID: userID,
+		UserID:    userID,
+		TokenType: RefreshTokenType,
+		FamilyID:  familyID,
+		Scopes:    scopes,
+		Role:      role,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 24 * 7)), // Refresh token expires in 7 days
+			ID:        uuid.New().String(),
+			Issuer:    JWTIssuer(),
+			Audience:  jwt.ClaimStrings{JWTAudience()},
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(refreshExpiresAt),
 		},
 	}
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshTokenClaims)
-	refreshTokenString, err := refreshToken.SignedString(signingKey)
+	refreshTokenString, err := keys.Sign(refreshTokenClaims)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	return accessTokenString, refreshTokenString, nil
+	return &TokenPair{
+		AccessToken:      accessTokenString,
+		RefreshToken:     refreshTokenString,
+		RefreshTokenID:   refreshTokenClaims.ID,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
 }
 
-func DecodeJWTToken(tokenString string) (*string, error) {
-	parser := new(jwt.Parser)
-	token, _, err := parser.ParseUnverified(tokenString, jwt.MapClaims{})
+// GenerateMFAToken signs the challenge a user exchanges, together with their
+// second factor, for a token pair. It returns the token and its ID.
+func GenerateMFAToken(userID string, keys *KeyRing) (string, string, error) {
+	now := time.Now()
+	claims := JWTClaims{
+		UserID:    userID,
+		TokenType: MFATokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    JWTIssuer(),
+			Audience:  jwt.ClaimStrings{JWTAudience()},
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(MFATokenTTL)),
+		},
+	}
+	token, err := keys.Sign(claims)
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
+	return token, claims.ID, nil
+}
 
+// ValidateJWTToken verifies the signature of tokenString against the key named
+// by its kid header and checks its expiry, issuer and audience.
+func ValidateJWTToken(tokenString string, keys *KeyRing, issuer, audience string) (*JWTClaims, error) {
+	claims := &JWTClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, keys.Keyfunc)
 	if err != nil {
 		return nil, err
 	}
-
-	// Access the token claims
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, err
+	if !token.Valid {
+		return nil, errors.New("invalid token")
 	}
 
-	// Extract specific claims
-	username, ok := claims["user_id"].(string)
-	if !ok {
+	if !claims.VerifyExpiresAt(time.Now(), true) {
+		return nil, errors.New("token has no expiry")
+	}
+	if !claims.VerifyIssuer(issuer, true) {
+		return nil, errors.New("invalid token issuer")
+	}
+	if !claims.VerifyAudience(audience, true) {
+		return nil, errors.New("invalid token audience")
+	}
+	if claims.UserID == "" {
 		return nil, errors.New("User Id Not Found")
 	}
 
-	return &username, nil
+	return claims, nil
+}
+
+// JWTIssuer returns the iss claim put into and expected from our tokens.
+func JWTIssuer() string {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return DefaultJWTIssuer
 }
 
-func GetTokenFromAuthHeader(auth string) string {
-	strs := strings.Split(auth, " ")
-	if len(strs) > 1 {
-		return strs[1]
+// JWTAudience returns the aud claim put into and expected from our tokens.
+func JWTAudience() string {
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		return audience
 	}
-	return ""
+	return DefaultJWTAudience
 }
@@ -1,29 +1,15 @@
 package utils
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
-func TestCheckPhoneNumber(t *testing.T) {
-	tests := []struct {
-		name     string
-		input    string
-		expected bool
-	}{
-		{"Valid Phone Number", "+1234567890", true},
-		{"Invalid Phone Number", "1234567890", false},
-		{"Invalid Phone Number Format", "+1234567890a", false},
-	}
-
-	for _, tc := range tests {
-		t.Run(tc.name, func(t *testing.T) {
-			result := CheckPhoneNumber(tc.input)
-			assert.Equal(t, tc.expected, result)
-		})
-	}
-}
-
 func TestHashAndCheckPassword(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -48,50 +34,78 @@ func TestHashAndCheckPassword(t *testing.T) {
 	}
 }
 
-func TestGenerateAndDecodeJWTToken(t *testing.T) {
+func newTestKeyRing(t *testing.T, kid string) *KeyRing {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	signingKey, err := NewSigningKey(kid, privateKey)
+	assert.NoError(t, err)
+	keys, err := NewKeyRing(kid, signingKey)
+	assert.NoError(t, err)
+	return keys
+}
+
+func TestGenerateAndValidateJWTToken(t *testing.T) {
+	keys := newTestKeyRing(t, "testKey")
+	otherKeys := newTestKeyRing(t, "testKey")
+	pair, err := GenerateTokenPair("testUserID", "", RoleUser, keys)
+	assert.NoError(t, err)
+	accessToken, refreshToken := pair.AccessToken, pair.RefreshToken
+
 	tests := []struct {
-		name        string
-		userID      string
-		secret      string
-		expectedErr bool
+		name              string
+		token             string
+		keys              *KeyRing
+		issuer            string
+		audience          string
+		expectedTokenType string
+		expectedErr       bool
 	}{
-		{"Valid Token Generation", "testUserID", "testSecret", false},
+		{"Valid Access Token", accessToken, keys, DefaultJWTIssuer, DefaultJWTAudience, AccessTokenType, false},
+		{"Valid Refresh Token", refreshToken, keys, DefaultJWTIssuer, DefaultJWTAudience, RefreshTokenType, false},
+		{"Wrong Key", accessToken, otherKeys, DefaultJWTIssuer, DefaultJWTAudience, "", true},
+		{"Wrong Issuer", accessToken, keys, "OtherService", DefaultJWTAudience, "", true},
+		{"Wrong Audience", accessToken, keys, DefaultJWTIssuer, "OtherService", "", true},
+		{"Malformed Token", "not.a.token", keys, DefaultJWTIssuer, DefaultJWTAudience, "", true},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			accessToken, _, err := GenerateJWTToken(tc.userID, tc.secret)
+			claims, err := ValidateJWTToken(tc.token, tc.keys, tc.issuer, tc.audience)
 			if tc.expectedErr {
 				assert.Error(t, err)
 			} else {
 				assert.NoError(t, err)
-				userIDFromToken, err := DecodeJWTToken(accessToken)
-				assert.NoError(t, err)
-				assert.Equal(t, tc.userID, *userIDFromToken)
+				assert.Equal(t, "testUserID", claims.UserID)
+				assert.Equal(t, tc.expectedTokenType, claims.TokenType)
+				assert.NotEmpty(t, claims.ID)
 			}
 		})
 	}
 }
 
-func TestGetTokenFromAuthHeader(t *testing.T) {
-	tests := []struct {
-		name        string
-		authHeader  string
-		expected    string
-		expectedErr bool
-	}{
-		{"Valid Authorization Header", "Bearer token123", "token123", false},
-		{"Empty Authorization Header", "", "token123", true},
+func TestValidateJWTTokenRejectsUnsignedToken(t *testing.T) {
+	claims := JWTClaims{
+		UserID:    "testUserID",
+		TokenType: AccessTokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    DefaultJWTIssuer,
+			Audience:  jwt.ClaimStrings{DefaultJWTAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
 	}
+	token := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	token.Header["kid"] = "testKey"
+	forged, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	assert.NoError(t, err)
 
-	for _, tc := range tests {
-		t.Run(tc.name, func(t *testing.T) {
-			token := GetTokenFromAuthHeader(tc.authHeader)
-			if !tc.expectedErr {
-				assert.Equal(t, tc.expected, token)
-			} else {
-				assert.NotEqual(t, tc.expected, token)
-			}
-		})
+	_, err = ValidateJWTToken(forged, newTestKeyRing(t, "testKey"), DefaultJWTIssuer, DefaultJWTAudience)
+	assert.Error(t, err)
+}
+
+func TestGenerateNumericCode(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		code, err := GenerateNumericCode(6)
+		assert.NoError(t, err)
+		assert.Regexp(t, `^[0-9]{6}$`, code)
 	}
 }